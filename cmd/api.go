@@ -0,0 +1,108 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/imishinist/nature-remo-exporter/pkg/collector"
+	"github.com/tenntenn/natureremo"
+)
+
+// snapshot holds the latest responses of the Nature Remo API to serve them as JSON.
+type snapshot struct {
+	mu         sync.RWMutex
+	updatedAt  time.Time
+	devices    []*natureremo.Device
+	appliances []*natureremo.Appliance
+}
+
+// SetDevices replaces the devices with the latest ones.
+func (s *snapshot) SetDevices(devices []*natureremo.Device) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices = devices
+	s.updatedAt = time.Now()
+}
+
+// UpdatedAt returns the time of the last update, or the zero time if it has not been updated.
+func (s *snapshot) UpdatedAt() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.updatedAt
+}
+
+// SetAppliances replaces the appliances with the latest ones.
+func (s *snapshot) SetAppliances(appliances []*natureremo.Appliance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.appliances = appliances
+}
+
+// Handler serves the snapshot as JSON, or 503 until the first successful update.
+func (s *snapshot) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if s.updatedAt.IsZero() {
+			http.Error(w, "no successful update yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Last-Modified", s.updatedAt.UTC().Format(http.TimeFormat))
+		json.NewEncoder(w).Encode(struct {
+			UpdatedAt  time.Time               `json:"updated_at"`
+			Devices    []*natureremo.Device    `json:"devices"`
+			Appliances []*natureremo.Appliance `json:"appliances,omitempty"`
+		}{s.updatedAt, s.devices, s.appliances})
+	})
+}
+
+// updateAppliances fetches all appliances from the Nature Remo API and stores them to the snapshot.
+// It returns the samples of the smart meters of Nature Remo E among them.
+func updateAppliances(ctx context.Context, fetcher collector.DeviceFetcher, metrics *collector.Metrics, snap *snapshot) ([]sample, error) {
+	var appliances []*natureremo.Appliance
+	var meters *smartMeters
+	err := apiRetry.Do(ctx, func(ctx context.Context) error {
+		ctx, res := withAPIResponse(ctx)
+		ctx, meters = withSmartMeters(ctx)
+		var err error
+		appliances, err = fetcher.FetchAppliances(ctx)
+		metrics.ObserveAPIRequest(&res.APIRequest)
+		if err != nil {
+			return newAPIError(err, res)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all appliances from Nature Remo API: %w", err)
+	}
+	metrics.IncAPICallsTotal()
+	snap.SetAppliances(appliances)
+	return meters.Samples, nil
+}
@@ -0,0 +1,381 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	archiveDir       string
+	archiveFormat    string
+	archiveRetention time.Duration
+
+	archiveS3Bucket    string
+	archiveS3Prefix    string
+	archiveS3Region    string
+	archiveS3Endpoint  string
+	archiveS3Retention time.Duration
+	archiveS3Timeout   time.Duration
+)
+
+// archiveFileFormat is a format of the archive files.
+type archiveFileFormat struct {
+	ext         string
+	contentType string
+	write       func(w io.Writer, samples []sample) error
+}
+
+var archiveFileFormats = map[string]archiveFileFormat{
+	"parquet": {".parquet", "application/vnd.apache.parquet", writeParquet},
+	"csv":     {".csv", "text/csv", writeCSV},
+	"json":    {".jsonl", "application/jsonl", writeJSONLines},
+}
+
+// archiveSink archives the readings of each day as a file in a directory, and uploads the files to S3 if configured.
+// The readings of the day are spooled in a JSON lines file until the day ends, so that they survive restarts.
+// The days are in the local time zone.
+type archiveSink struct {
+	dir       string
+	format    archiveFileFormat
+	retention time.Duration
+
+	s3            *s3Client
+	s3Prefix      string
+	s3Retention   time.Duration
+	uploadTimeout time.Duration
+	logger        *slog.Logger
+	// uploadMu is held while the files are uploaded in the background, so that one upload runs at a time.
+	uploadMu sync.Mutex
+	// uploaded holds the names of the files in the bucket, which is listed on the first upload.
+	uploaded map[string]bool
+	// pending is set when there may be files to upload.
+	pending atomic.Bool
+}
+
+// newArchiveSink creates a sink archiving to the directory,
+// which deletes the files of the days older than the retention unless it is 0.
+func newArchiveSink(dir, format string, retention time.Duration) (*archiveSink, error) {
+	f, ok := archiveFileFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("invalid archive format %q: must be parquet, csv or json", format)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %v", err)
+	}
+	return &archiveSink{dir: dir, format: f, retention: retention}, nil
+}
+
+// uploadTo uploads the archive files to the bucket with the prefix,
+// and deletes the ones of the days older than the retention from the bucket unless it is 0.
+// The uploads run in the background with the timeout, not to delay the polls and the other sinks.
+func (s *archiveSink) uploadTo(client *s3Client, prefix string, retention, timeout time.Duration, logger *slog.Logger) {
+	s.s3 = client
+	s.s3Prefix = prefix
+	s.s3Retention = retention
+	s.uploadTimeout = timeout
+	s.logger = logger
+	s.pending.Store(true)
+}
+
+func (s *archiveSink) Name() string {
+	return "archive"
+}
+
+func (s *archiveSink) spoolPath(day string) string {
+	return filepath.Join(s.dir, "readings-"+day+".jsonl.part")
+}
+
+func (s *archiveSink) archivePath(day string) string {
+	return filepath.Join(s.dir, "readings-"+day+s.format.ext)
+}
+
+func (s *archiveSink) Write(ctx context.Context, samples []sample) error {
+	days := make(map[string][]sample)
+	for _, sample := range samples {
+		day := sample.Timestamp.Local().Format(time.DateOnly)
+		days[day] = append(days[day], sample)
+	}
+	for day, samples := range days {
+		// the sensors keep reporting the last readings of the archived days until they have new ones
+		if _, err := os.Stat(s.archivePath(day)); err == nil {
+			if _, err := os.Stat(s.spoolPath(day)); os.IsNotExist(err) {
+				continue
+			}
+		}
+		if err := s.spool(day, samples); err != nil {
+			return err
+		}
+	}
+	return s.rollover(ctx, time.Now().Format(time.DateOnly))
+}
+
+// spool appends the samples to the spool of the day.
+func (s *archiveSink) spool(day string, samples []sample) error {
+	f, err := os.OpenFile(s.spoolPath(day), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, sample := range samples {
+		if err := enc.Encode(sample); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rollover archives the spools of the days before today, and uploads them.
+func (s *archiveSink) rollover(ctx context.Context, today string) error {
+	spools, err := filepath.Glob(s.spoolPath("*"))
+	if err != nil {
+		return err
+	}
+	for _, spool := range spools {
+		day := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(spool), "readings-"), ".jsonl.part")
+		if day >= today {
+			continue
+		}
+		if err := s.archive(day); err != nil {
+			return fmt.Errorf("failed to archive readings of %s: %v", day, err)
+		}
+		s.pending.Store(true)
+	}
+	if s.s3 == nil {
+		return s.removeExpired()
+	}
+	// the expired files are removed by the upload, once the ones to upload are in the bucket
+	if s.pending.Load() && s.uploadMu.TryLock() {
+		s.pending.Store(false)
+		go func() {
+			defer s.uploadMu.Unlock()
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.uploadTimeout)
+			defer cancel()
+			if err := s.upload(ctx); err != nil {
+				s.logger.Error(fmt.Sprintf("failed to upload archives to S3: %v", err), slog.String("sink", s.Name()))
+				s.pending.Store(true)
+			}
+		}()
+	}
+	return nil
+}
+
+// removeExpired removes the archive files of the days older than the retention from the directory.
+// If the files are uploaded, the ones which are still to be uploaded are kept.
+func (s *archiveSink) removeExpired() error {
+	if s.retention <= 0 {
+		return nil
+	}
+	oldest := time.Now().Add(-s.retention).Format(time.DateOnly)
+	files, err := filepath.Glob(s.archivePath("*"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		day, ok := archiveDay(name)
+		if !ok || day >= oldest || s.toUpload(name, day) {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			return fmt.Errorf("failed to remove expired archive: %v", err)
+		}
+	}
+	return nil
+}
+
+// toUpload reports whether the archive file of the day is to be uploaded,
+// which is when it is not in the bucket and not older than the retention of the bucket.
+func (s *archiveSink) toUpload(name, day string) bool {
+	if s.s3 == nil || s.uploaded[name] {
+		return false
+	}
+	return s.s3Retention <= 0 || day >= time.Now().Add(-s.s3Retention).Format(time.DateOnly)
+}
+
+// upload uploads the archive files which are not in the bucket yet, applies the retention to the bucket,
+// and then removes the expired files from the directory. It must be called with uploadMu held.
+func (s *archiveSink) upload(ctx context.Context) error {
+	if s.uploaded == nil {
+		objects, err := s.s3.List(ctx, s.s3Prefix)
+		if err != nil {
+			return err
+		}
+		uploaded := make(map[string]bool, len(objects))
+		for _, object := range objects {
+			uploaded[strings.TrimPrefix(object.Key, s.s3Prefix)] = true
+		}
+		s.uploaded = uploaded
+	}
+
+	files, err := filepath.Glob(s.archivePath("*"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		// the files kept longer in the directory than in the bucket are not uploaded again
+		if day, ok := archiveDay(name); !ok || !s.toUpload(name, day) {
+			continue
+		}
+		body, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := s.s3.Put(ctx, s.s3Prefix+name, body, s.format.contentType); err != nil {
+			return err
+		}
+		s.uploaded[name] = true
+	}
+
+	if s.s3Retention > 0 {
+		oldest := time.Now().Add(-s.s3Retention).Format(time.DateOnly)
+		for name := range s.uploaded {
+			day, ok := archiveDay(name)
+			if !ok || day >= oldest {
+				continue
+			}
+			if err := s.s3.Delete(ctx, s.s3Prefix+name); err != nil {
+				return err
+			}
+			delete(s.uploaded, name)
+		}
+	}
+	return s.removeExpired()
+}
+
+// archiveDay returns the day of the archive file name, or false if it is not an archive file.
+func archiveDay(name string) (string, bool) {
+	day, ok := strings.CutPrefix(name, "readings-")
+	if !ok || len(day) < len(time.DateOnly) {
+		return "", false
+	}
+	day = day[:len(time.DateOnly)]
+	if _, err := time.Parse(time.DateOnly, day); err != nil {
+		return "", false
+	}
+	return day, true
+}
+
+// archive writes the readings in the spool of the day to the archive file, and removes the spool.
+// The readings seen in multiple polls are deduplicated.
+func (s *archiveSink) archive(day string) error {
+	f, err := os.Open(s.spoolPath(day))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	type key struct {
+		deviceID, metric string
+		timestamp        time.Time
+	}
+	seen := make(map[key]bool)
+	var samples []sample
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var sample sample
+		// a line may be incomplete if the process crashed while spooling it
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			continue
+		}
+		k := key{sample.DeviceID, sample.Metric, sample.Timestamp}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		samples = append(samples, sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		a, b := samples[i], samples[j]
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.Before(b.Timestamp)
+		}
+		if a.DeviceID != b.DeviceID {
+			return a.DeviceID < b.DeviceID
+		}
+		return a.Metric < b.Metric
+	})
+
+	tmp, err := os.CreateTemp(s.dir, ".readings-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := s.format.write(tmp, samples); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.archivePath(day)); err != nil {
+		return err
+	}
+	return os.Remove(s.spoolPath(day))
+}
+
+// writeCSV writes the samples as CSV with a header.
+func writeCSV(w io.Writer, samples []sample) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"timestamp", "device_id", "device_name", "metric", "value"})
+	for _, sample := range samples {
+		cw.Write([]string{
+			sample.Timestamp.Format(time.RFC3339),
+			sample.DeviceID,
+			sample.DeviceName,
+			sample.Metric,
+			strconv.FormatFloat(sample.Value, 'f', -1, 64),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeJSONLines writes the samples as JSON lines.
+func writeJSONLines(w io.Writer, samples []sample) error {
+	enc := json.NewEncoder(w)
+	for _, sample := range samples {
+		if err := enc.Encode(sample); err != nil {
+			return err
+		}
+	}
+	return nil
+}
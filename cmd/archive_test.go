@@ -0,0 +1,120 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeArchives writes empty archive files of the days before today, and returns their names.
+func writeArchives(t *testing.T, s *archiveSink, daysAgo ...int) []string {
+	t.Helper()
+	var names []string
+	for _, n := range daysAgo {
+		path := s.archivePath(time.Now().AddDate(0, 0, -n).Format(time.DateOnly))
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, filepath.Base(path))
+	}
+	return names
+}
+
+// archiveFiles returns the names of the archive files in the directory.
+func archiveFiles(t *testing.T, s *archiveSink) []string {
+	t.Helper()
+	files, err := filepath.Glob(s.archivePath("*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, file := range files {
+		files[i] = filepath.Base(file)
+	}
+	return files
+}
+
+func TestArchiveSinkRemovesExpiredFiles(t *testing.T) {
+	s, err := newArchiveSink(t.TempDir(), "json", 72*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := writeArchives(t, s, 10, 5, 2, 1)
+	if err := s.rollover(context.Background(), time.Now().Format(time.DateOnly)); err != nil {
+		t.Fatalf("rollover() error = %v", err)
+	}
+	if got, want := archiveFiles(t, s), names[2:]; !slices.Equal(got, want) {
+		t.Errorf("archive files = %v, want %v", got, want)
+	}
+}
+
+func TestArchiveSinkUploadsInBackground(t *testing.T) {
+	var mu sync.Mutex
+	var put []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			mu.Lock()
+			put = append(put, filepath.Base(r.URL.Path))
+			mu.Unlock()
+			return
+		}
+		w.Write([]byte(`<ListBucketResult></ListBucketResult>`))
+	}))
+	defer srv.Close()
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	client, err := newS3Client(context.Background(), "backup", "ap-northeast-1", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newArchiveSink(t.TempDir(), "json", 72*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.uploadTo(client, "", 240*time.Hour, time.Minute, slog.Default())
+	names := writeArchives(t, s, 20, 5, 1)
+
+	// the upload outlives the context of the write, which is canceled as soon as it returns
+	ctx, cancel := context.WithCancel(context.Background())
+	err = s.rollover(ctx, time.Now().Format(time.DateOnly))
+	cancel()
+	if err != nil {
+		t.Fatalf("rollover() error = %v", err)
+	}
+	s.uploadMu.Lock()
+	defer s.uploadMu.Unlock()
+
+	// the file older than the retention of the bucket is not uploaded, and the uploaded files are removed by the retention of the directory
+	if want := names[1:]; !slices.Equal(put, want) {
+		t.Errorf("uploaded %v, want %v", put, want)
+	}
+	if got, want := archiveFiles(t, s), names[2:]; !slices.Equal(got, want) {
+		t.Errorf("archive files = %v, want %v", got, want)
+	}
+	if s.pending.Load() {
+		t.Error("pending after a successful upload")
+	}
+}
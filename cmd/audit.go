@@ -0,0 +1,101 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var apiAuditLogFile string
+
+// auditEntry is a line of the audit log, which is written for each call to the API including the retries.
+type auditEntry struct {
+	Time            time.Time `json:"time"`
+	Method          string    `json:"method"`
+	Endpoint        string    `json:"endpoint"`
+	Status          int       `json:"status,omitempty"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	// the rate limit headers are kept as they are, including the malformed ones
+	RateLimitLimit     *int   `json:"rate_limit_limit,omitempty"`
+	RateLimitRemaining *int   `json:"rate_limit_remaining,omitempty"`
+	RateLimitReset     *int64 `json:"rate_limit_reset,omitempty"`
+	Error              string `json:"error,omitempty"`
+}
+
+// auditTransport appends an auditEntry of each request to the file as a line of JSON.
+type auditTransport struct {
+	next http.RoundTripper
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newAuditTransport(next http.RoundTripper, path string) (*auditTransport, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log file: %v", err)
+	}
+	return &auditTransport{next: next, file: f}, nil
+}
+
+func (t *auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	entry := auditEntry{
+		Time:            start.UTC(),
+		Method:          req.Method,
+		Endpoint:        req.URL.Path,
+		DurationSeconds: time.Since(start).Seconds(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if resp != nil {
+		entry.Status = resp.StatusCode
+		if v, err := strconv.Atoi(resp.Header.Get("X-Rate-Limit-Limit")); err == nil {
+			entry.RateLimitLimit = &v
+		}
+		if v, err := strconv.Atoi(resp.Header.Get("X-Rate-Limit-Remaining")); err == nil {
+			entry.RateLimitRemaining = &v
+		}
+		if v, err := strconv.ParseInt(resp.Header.Get("X-Rate-Limit-Reset"), 10, 64); err == nil {
+			entry.RateLimitReset = &v
+		}
+	}
+
+	b, merr := json.Marshal(entry)
+	if merr != nil {
+		return resp, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// a line is written by a call, so that the lines are not interleaved with the other processes appending to the file
+	if _, werr := t.file.Write(append(b, '\n')); werr != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		// the audit log is asked to account for every call, so its failure is not ignored
+		return nil, fmt.Errorf("failed to write audit log: %v", werr)
+	}
+	return resp, err
+}
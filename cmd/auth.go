@@ -0,0 +1,137 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bearerTokensEnv is the environment variable to set the bearer tokens separated by commas.
+const bearerTokensEnv = "NATURE_REMO_EXPORTER_BEARER_TOKENS"
+
+// bearerAuth requires one of the static bearer tokens on requests.
+// The tokens are read from the file, which is reloaded when it is modified, and from the environment variable.
+type bearerAuth struct {
+	file   string
+	env    []string
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	tokens  []string
+	modTime time.Time
+}
+
+func newBearerAuth(file string, logger *slog.Logger) (*bearerAuth, error) {
+	a := &bearerAuth{file: file, logger: logger}
+	for _, token := range strings.Split(os.Getenv(bearerTokensEnv), ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			a.env = append(a.env, token)
+		}
+	}
+	if file != "" {
+		if err := a.reload(); err != nil {
+			return nil, err
+		}
+	}
+	if len(a.env) == 0 && len(a.tokens) == 0 {
+		return nil, fmt.Errorf("no bearer tokens configured")
+	}
+	return a, nil
+}
+
+// reload reads the tokens from the file, one per line. Empty lines and lines starting with # are ignored.
+func (a *bearerAuth) reload() error {
+	info, err := os.Stat(a.file)
+	if err != nil {
+		return fmt.Errorf("failed to stat bearer token file: %v", err)
+	}
+	b, err := os.ReadFile(a.file)
+	if err != nil {
+		return fmt.Errorf("failed to read bearer token file: %v", err)
+	}
+	var tokens []string
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, line)
+	}
+	a.tokens = tokens
+	a.modTime = info.ModTime()
+	return nil
+}
+
+func (a *bearerAuth) valid(token string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file != "" {
+		if info, err := os.Stat(a.file); err == nil && info.ModTime().After(a.modTime) {
+			if err := a.reload(); err != nil {
+				a.logger.Error(err.Error())
+			} else {
+				a.logger.Info("bearer tokens reloaded")
+			}
+		}
+	}
+
+	ok := false
+	for _, tokens := range [][]string{a.tokens, a.env} {
+		for _, t := range tokens {
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+				ok = true
+			}
+		}
+	}
+	return ok
+}
+
+// Token returns one of the tokens, for a replica to call another replica sharing the configuration.
+func (a *bearerAuth) Token() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, tokens := range [][]string{a.env, a.tokens} {
+		if len(tokens) > 0 {
+			return tokens[0]
+		}
+	}
+	return ""
+}
+
+// Handler wraps the handler to require a valid bearer token.
+func (a *bearerAuth) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !a.valid(token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
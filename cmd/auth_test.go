@@ -0,0 +1,106 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTokenFile writes the bearer token file with the modification time,
+// so that the reload does not depend on the resolution of the file system.
+func writeTokenFile(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBearerAuthHandler(t *testing.T) {
+	t.Setenv(bearerTokensEnv, "env-token, ")
+	path := filepath.Join(t.TempDir(), "tokens")
+	writeTokenFile(t, path, "# prometheus\nfile-token\n\n", time.Now().Add(-time.Hour))
+	auth, err := newBearerAuth(path, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(authorization string) int {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("WWW-Authenticate = %q, want Bearer", rec.Header().Get("WWW-Authenticate"))
+		}
+		return rec.Code
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{name: "missing token", want: http.StatusUnauthorized},
+		{name: "wrong token", authorization: "Bearer wrong", want: http.StatusUnauthorized},
+		{name: "basic authentication", authorization: "Basic ZmlsZS10b2tlbjo=", want: http.StatusUnauthorized},
+		{name: "comment is not a token", authorization: "Bearer # prometheus", want: http.StatusUnauthorized},
+		{name: "token of the file", authorization: "Bearer file-token", want: http.StatusOK},
+		{name: "token of the environment variable", authorization: "Bearer env-token", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := request(tt.authorization); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("reloaded token file", func(t *testing.T) {
+		writeTokenFile(t, path, "rotated-token\n", time.Now())
+		if got := request("Bearer rotated-token"); got != http.StatusOK {
+			t.Errorf("status of the new token = %d, want %d", got, http.StatusOK)
+		}
+		if got := request("Bearer file-token"); got != http.StatusUnauthorized {
+			t.Errorf("status of the old token = %d, want %d", got, http.StatusUnauthorized)
+		}
+		if got := request("Bearer env-token"); got != http.StatusOK {
+			t.Errorf("status of the token of the environment variable = %d, want %d", got, http.StatusOK)
+		}
+	})
+}
+
+func TestNewBearerAuthNoTokens(t *testing.T) {
+	t.Setenv(bearerTokensEnv, "")
+	path := filepath.Join(t.TempDir(), "tokens")
+	writeTokenFile(t, path, "# no tokens\n", time.Now())
+	if _, err := newBearerAuth(path, slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+		t.Error("newBearerAuth() error = nil, want an error without tokens")
+	}
+}
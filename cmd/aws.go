@@ -0,0 +1,93 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+)
+
+// loadAWSConfig loads the configuration of the AWS SDK in the region given by the flag,
+// or in the one of AWS_REGION, AWS_DEFAULT_REGION or the shared config file if empty.
+// The credentials are resolved by the default chain of the SDK: the environment variables, the shared files,
+// the web identity token of IRSA, and the roles of ECS tasks and EC2 instances by IMDSv2.
+// They are retrieved once so that missing credentials are reported at the start.
+func loadAWSConfig(ctx context.Context, region string) (aws.Config, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return cfg, err
+	}
+	if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// awsSigner signs the requests with AWS Signature Version 4.
+// The credentials are retrieved for each request, as the provider caches them and refreshes the temporary ones before they expire.
+type awsSigner struct {
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+	region      string
+	service     string
+}
+
+func newAWSSigner(credentials aws.CredentialsProvider, region, service string, optFns ...func(*v4.SignerOptions)) *awsSigner {
+	return &awsSigner{
+		credentials: credentials,
+		signer:      v4.NewSigner(optFns...),
+		region:      region,
+		service:     service,
+	}
+}
+
+// Sign signs the request with the body. The host, the Content-Type and the X-Amz-* headers must be set before.
+func (s *awsSigner) Sign(req *http.Request, body []byte) error {
+	creds, err := s.credentials.Retrieve(req.Context())
+	if err != nil {
+		return fmt.Errorf("failed to retrieve credentials of AWS: %v", err)
+	}
+	hash := sha256.Sum256(body)
+	return s.signer.SignHTTP(req.Context(), creds, req, hex.EncodeToString(hash[:]), s.service, s.region, time.Now())
+}
+
+// awsEscape escapes s as required by the canonical request, where only the unreserved characters are kept.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
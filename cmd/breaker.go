@@ -0,0 +1,103 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	apiCircuitBreakerFailures int
+	apiCircuitBreakerCooldown time.Duration
+)
+
+// breakerState is the state of the circuit breaker, which is exported as the value of the metric.
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker stops the polls for a cool-down period after consecutive failures.
+// After the period, a poll is allowed to probe the API, and the breaker is closed if it succeeds.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	gauge     prometheus.Gauge
+
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, gauge prometheus.Gauge) *circuitBreaker {
+	gauge.Set(float64(breakerClosed))
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, gauge: gauge}
+}
+
+func (b *circuitBreaker) setState(state breakerState) {
+	b.state = state
+	b.gauge.Set(float64(state))
+}
+
+// Allow reports whether a poll may call the API.
+func (b *circuitBreaker) Allow(now time.Time) bool {
+	if b.state != breakerOpen {
+		return true
+	}
+	if now.Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.setState(breakerHalfOpen)
+	return true
+}
+
+// Success closes the breaker. It returns true if the breaker was not closed.
+func (b *circuitBreaker) Success() bool {
+	b.failures = 0
+	if b.state == breakerClosed {
+		return false
+	}
+	b.setState(breakerClosed)
+	return true
+}
+
+// Failure counts a failed poll. It returns true if the breaker is opened by the failure.
+func (b *circuitBreaker) Failure(now time.Time) bool {
+	b.failures++
+	if b.state != breakerHalfOpen && b.failures < b.threshold {
+		return false
+	}
+	b.openedAt = now
+	b.setState(breakerOpen)
+	return true
+}
@@ -0,0 +1,68 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_circuit_breaker_state"})
+	b := newCircuitBreaker(3, time.Minute, gauge)
+
+	// each step is an event at the time after t0, and the expected state after it
+	steps := []struct {
+		name  string
+		at    time.Duration
+		event func(now time.Time) bool
+		want  bool
+		state breakerState
+	}{
+		{name: "closed allows", at: 0, event: b.Allow, want: true, state: breakerClosed},
+		{name: "first failure", at: time.Second, event: b.Failure, want: false, state: breakerClosed},
+		{name: "second failure", at: 2 * time.Second, event: b.Failure, want: false, state: breakerClosed},
+		{name: "success resets the failures", at: 3 * time.Second, event: func(time.Time) bool { return b.Success() }, want: false, state: breakerClosed},
+		{name: "failure after the reset", at: 4 * time.Second, event: b.Failure, want: false, state: breakerClosed},
+		{name: "second failure after the reset", at: 5 * time.Second, event: b.Failure, want: false, state: breakerClosed},
+		{name: "threshold opens", at: 6 * time.Second, event: b.Failure, want: true, state: breakerOpen},
+		{name: "open rejects", at: 7 * time.Second, event: b.Allow, want: false, state: breakerOpen},
+		{name: "open rejects until the cooldown", at: 6*time.Second + time.Minute - time.Nanosecond, event: b.Allow, want: false, state: breakerOpen},
+		{name: "cooldown half-opens", at: 6*time.Second + time.Minute, event: b.Allow, want: true, state: breakerHalfOpen},
+		{name: "half-open failure reopens", at: 70 * time.Second, event: b.Failure, want: true, state: breakerOpen},
+		{name: "cooldown restarts", at: 70*time.Second + 59*time.Second, event: b.Allow, want: false, state: breakerOpen},
+		{name: "cooldown half-opens again", at: 70*time.Second + time.Minute, event: b.Allow, want: true, state: breakerHalfOpen},
+		{name: "half-open success closes", at: 131 * time.Second, event: func(time.Time) bool { return b.Success() }, want: true, state: breakerClosed},
+		{name: "closed allows again", at: 132 * time.Second, event: b.Allow, want: true, state: breakerClosed},
+		{name: "closed needs the threshold again", at: 133 * time.Second, event: b.Failure, want: false, state: breakerClosed},
+	}
+	for _, step := range steps {
+		if got := step.event(t0.Add(step.at)); got != step.want {
+			t.Errorf("%s: got %v, want %v", step.name, got, step.want)
+		}
+		if b.state != step.state {
+			t.Errorf("%s: state = %v, want %v", step.name, b.state, step.state)
+		}
+		if got := testutil.ToFloat64(gauge); got != float64(step.state) {
+			t.Errorf("%s: gauge = %v, want %v", step.name, got, float64(step.state))
+		}
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// bufferedResponse is an http.ResponseWriter which buffers the response.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *bufferedResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *bufferedResponse) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
+
+func (r *bufferedResponse) WriteHeader(status int) {
+	r.status = status
+}
+
+type cachedResponse struct {
+	createdAt time.Time
+	status    int
+	header    http.Header
+	body      []byte
+}
+
+// scrapeCache is a middleware which serves the cached response to the scrapes
+// arriving within ttl of the previous one, e.g. from a pair of HA Prometheus servers.
+// The responses are cached per format and encoding negotiated from Accept and Accept-Encoding,
+// so that the clients cannot grow the cache by varying the headers.
+type scrapeCache struct {
+	next http.Handler
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*cachedResponse
+}
+
+func newScrapeCache(next http.Handler, ttl time.Duration) *scrapeCache {
+	return &scrapeCache{
+		next:    next,
+		ttl:     ttl,
+		entries: make(map[string]*cachedResponse),
+	}
+}
+
+func (c *scrapeCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := scrapeCacheKey(r.Header)
+
+	// concurrent scrapes wait for the first one instead of gathering the metrics again
+	c.mu.Lock()
+	for k, e := range c.entries {
+		if time.Since(e.createdAt) >= c.ttl {
+			delete(c.entries, k)
+		}
+	}
+	entry, ok := c.entries[key]
+	if !ok || time.Since(entry.createdAt) >= c.ttl {
+		buf := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+		c.next.ServeHTTP(buf, r)
+		entry = &cachedResponse{
+			createdAt: time.Now(),
+			status:    buf.status,
+			header:    buf.header,
+			body:      buf.body.Bytes(),
+		}
+		if entry.status == http.StatusOK {
+			c.entries[key] = entry
+		}
+	}
+	c.mu.Unlock()
+
+	for k, v := range entry.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(entry.status)
+	w.Write(entry.body)
+}
+
+// scrapeCacheKey returns the key of the response to the request headers.
+// It is one of the few formats and encodings promhttp negotiates, whether OpenMetrics is enabled or not.
+func scrapeCacheKey(h http.Header) string {
+	encoding := "identity"
+	for _, part := range strings.Split(h.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(part, ";")
+		if strings.TrimSpace(name) == "gzip" && strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0" {
+			encoding = "gzip"
+			break
+		}
+	}
+	return string(expfmt.Negotiate(h)) + "\n" + string(expfmt.NegotiateIncludingOpenMetrics(h)) + "\n" + encoding
+}
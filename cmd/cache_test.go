@@ -0,0 +1,67 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestScrapeCacheKeysOnNegotiatedFormat(t *testing.T) {
+	calls := 0
+	c := newScrapeCache(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprintln(w, "nature_remo_temperature 20")
+	}), time.Hour)
+
+	for i := 0; i < 100; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r.Header.Set("Accept", fmt.Sprintf("text/plain;q=0.%d", i%10+1))
+		r.Header.Set("Accept-Encoding", fmt.Sprintf("gzip, x-junk-%d", i))
+		c.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+	if len(c.entries) != 1 {
+		t.Errorf("cache has %d entries, want 1", len(c.entries))
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	c.ServeHTTP(httptest.NewRecorder(), r)
+	if calls != 2 {
+		t.Errorf("handler called %d times without gzip, want 2", calls)
+	}
+}
+
+func TestScrapeCacheDropsExpiredEntries(t *testing.T) {
+	c := newScrapeCache(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "nature_remo_temperature 20")
+	}), time.Millisecond)
+
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	c.ServeHTTP(httptest.NewRecorder(), r)
+	time.Sleep(2 * time.Millisecond)
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if len(c.entries) != 1 {
+		t.Errorf("cache has %d entries, want only the fresh one", len(c.entries))
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// lineAPIURL is the endpoint of the Messaging API of LINE to push messages.
+const lineAPIURL = "https://api.line.me/v2/bot/message/push"
+
+type slackConfig struct {
+	// APIURL is the URL of an incoming webhook.
+	APIURL  string `yaml:"api_url"`
+	Channel string `yaml:"channel"`
+}
+
+type discordConfig struct {
+	WebhookURL string `yaml:"webhook_url"`
+}
+
+// lineConfig is the configuration of a LINE Messaging API channel.
+// To is the ID of the user, group or room to push the messages to.
+type lineConfig struct {
+	ChannelAccessToken string `yaml:"channel_access_token"`
+	To                 string `yaml:"to"`
+}
+
+// chatText formats the notification as a line of a chat message.
+func chatText(n notification) string {
+	return "[" + strings.ToUpper(n.Status) + "] " + n.Message
+}
+
+// slackNotifier posts the notifications to an incoming webhook of Slack.
+type slackNotifier struct {
+	client *http.Client
+	config slackConfig
+}
+
+func (s *slackNotifier) Notify(ctx context.Context, n notification) error {
+	msg := map[string]string{"text": chatText(n)}
+	if s.config.Channel != "" {
+		msg["channel"] = s.config.Channel
+	}
+	return postJSON(ctx, s.client, s.config.APIURL, nil, msg)
+}
+
+// discordNotifier posts the notifications to a webhook of a Discord channel.
+type discordNotifier struct {
+	client *http.Client
+	config discordConfig
+}
+
+func (d *discordNotifier) Notify(ctx context.Context, n notification) error {
+	return postJSON(ctx, d.client, d.config.WebhookURL, nil, map[string]string{"content": chatText(n)})
+}
+
+// lineNotifier pushes the notifications as text messages by the Messaging API of LINE.
+type lineNotifier struct {
+	client *http.Client
+	url    string
+	config lineConfig
+}
+
+type lineMessage struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func (l *lineNotifier) Notify(ctx context.Context, n notification) error {
+	body := struct {
+		To       string        `json:"to"`
+		Messages []lineMessage `json:"messages"`
+	}{
+		To:       l.config.To,
+		Messages: []lineMessage{{Type: "text", Text: chatText(n)}},
+	}
+	return postJSON(ctx, l.client, l.url, map[string]string{"Authorization": "Bearer " + l.config.ChannelAccessToken}, body)
+}
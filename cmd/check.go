@@ -0,0 +1,207 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/imishinist/nature-remo-exporter/pkg/collector"
+	"github.com/spf13/cobra"
+	"github.com/tenntenn/natureremo"
+)
+
+// Exit codes of the Nagios plugin API.
+const (
+	checkOK       = 0
+	checkWarning  = 1
+	checkCritical = 2
+	checkUnknown  = 3
+)
+
+var checkStatusNames = map[int]string{
+	checkOK:       "OK",
+	checkWarning:  "WARNING",
+	checkCritical: "CRITICAL",
+	checkUnknown:  "UNKNOWN",
+}
+
+// sensorTypes maps the metric names to the sensor types of Nature Remo.
+var sensorTypes = map[string]natureremo.SensorType{
+	"temperature":  natureremo.SensorTypeTemperature,
+	"humidity":     natureremo.SensorTypeHumidity,
+	"illumination": natureremo.SensorTypeIllumination,
+	"movement":     natureremo.SensorTypeMovement,
+}
+
+var (
+	checkMetric string
+	checkDevice string
+	checkWarn   string
+	checkCrit   string
+
+	// checkCmd represents the check command
+	checkCmd = &cobra.Command{
+		Use:   "check",
+		Short: "Check a sensor value like a Nagios/Icinga plugin",
+		Long: `Check polls the Nature Remo API once and compares a sensor value of a device
+with the warning and critical thresholds.
+
+The thresholds are the ranges of the Nagios plugin guidelines, which alert if the value is outside of them:
+"28" (outside 0 to 28), "10:" (below 10), "~:30" (above 30), "10:30" (outside 10 to 30),
+or "@10:20" (inside 10 to 20).
+
+It prints a status line with performance data and exits with the status code
+of the Nagios plugin API (0: OK, 1: WARNING, 2: CRITICAL, 3: UNKNOWN).`,
+		Example: `  nature-remo-exporter check --metric temperature --device "Living" --warn 28 --crit 32
+  nature-remo-exporter check --metric temperature --device "Living" --warn 10:28 --crit 5:32`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			code, message := runCheck(cmd)
+			fmt.Fprintf(cmd.OutOrStdout(), "%s - %s\n", checkStatusNames[code], message)
+			os.Exit(code)
+		},
+	}
+)
+
+func runCheck(cmd *cobra.Command) (int, string) {
+	sensorType, ok := sensorTypes[checkMetric]
+	if !ok {
+		return checkUnknown, fmt.Sprintf("unknown metric %q (available: %s)", checkMetric, strings.Join(sensorNames(), ", "))
+	}
+	if checkDevice == "" {
+		return checkUnknown, "--device is required"
+	}
+
+	warn, err := parseCheckRange(checkWarn)
+	if err != nil {
+		return checkUnknown, fmt.Sprintf("invalid --warn: %v", err)
+	}
+	crit, err := parseCheckRange(checkCrit)
+	if err != nil {
+		return checkUnknown, fmt.Sprintf("invalid --crit: %v", err)
+	}
+
+	fetcher, err := newFetcher()
+	if err != nil {
+		return checkUnknown, err.Error()
+	}
+	devices, _, err := fetchDevices(cmd.Context(), fetcher, collector.NewMetrics())
+	if err != nil {
+		return checkUnknown, err.Error()
+	}
+
+	var device *natureremo.Device
+	for _, d := range devices {
+		if d.Name == checkDevice || d.ID == checkDevice {
+			device = d
+			break
+		}
+	}
+	if device == nil {
+		return checkUnknown, fmt.Sprintf("device %q not found", checkDevice)
+	}
+	event, ok := device.NewestEvents[sensorType]
+	if !ok {
+		return checkUnknown, fmt.Sprintf("device %q has no %s sensor", device.Name, checkMetric)
+	}
+
+	code := checkOK
+	switch {
+	case crit != nil && crit.Alert(event.Value):
+		code = checkCritical
+	case warn != nil && warn.Alert(event.Value):
+		code = checkWarning
+	}
+	return code, fmt.Sprintf("%s %s is %g | %s=%g;%s;%s",
+		device.Name, checkMetric, event.Value,
+		checkMetric, event.Value, checkWarn, checkCrit)
+}
+
+// checkRange is a threshold range of the Nagios plugin guidelines.
+// See https://nagios-plugins.org/doc/guidelines.html#THRESHOLDFORMAT
+type checkRange struct {
+	start, end float64
+	// inside reports whether to alert inside the range instead of outside, by the @ prefix.
+	inside bool
+}
+
+// parseCheckRange parses the range in the format of [@]start:end, where start defaults to 0 if omitted,
+// end defaults to infinity if omitted after the colon, and start ~ means negative infinity.
+// It returns nil for an empty string.
+func parseCheckRange(s string) (*checkRange, error) {
+	if s == "" {
+		return nil, nil
+	}
+	r := &checkRange{end: math.Inf(1)}
+	var spec string
+	spec, r.inside = strings.CutPrefix(s, "@")
+	if spec == "" {
+		return nil, fmt.Errorf("empty range %q", s)
+	}
+	start, end, ok := strings.Cut(spec, ":")
+	if !ok {
+		start, end = "0", spec
+	}
+	var err error
+	switch start {
+	case "~":
+		r.start = math.Inf(-1)
+	case "":
+	default:
+		if r.start, err = strconv.ParseFloat(start, 64); err != nil {
+			return nil, fmt.Errorf("invalid start of range %q", s)
+		}
+	}
+	if end != "" {
+		if r.end, err = strconv.ParseFloat(end, 64); err != nil {
+			return nil, fmt.Errorf("invalid end of range %q", s)
+		}
+	}
+	if r.start > r.end {
+		return nil, fmt.Errorf("start of range %q is greater than its end", s)
+	}
+	return r, nil
+}
+
+// Alert reports whether the value should be alerted by the range.
+func (r *checkRange) Alert(v float64) bool {
+	inside := r.start <= v && v <= r.end
+	return inside == r.inside
+}
+
+func sensorNames() []string {
+	names := make([]string, 0, len(sensorTypes))
+	for name := range sensorTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+
+	checkCmd.Flags().StringVar(&checkMetric, "metric", "temperature", "Metric to check (temperature, humidity, illumination, movement)")
+	checkCmd.Flags().StringVar(&checkDevice, "device", "", "Name or ID of the device to check")
+	checkCmd.Flags().StringVar(&checkWarn, "warn", "", "Warning threshold range, e.g. 28, 10:, ~:30, 10:30 or @10:20; the status is WARNING if the value is outside of it")
+	checkCmd.Flags().StringVar(&checkCrit, "crit", "", "Critical threshold range, e.g. 32, 5:, ~:35, 5:32 or @5:10; the status is CRITICAL if the value is outside of it")
+}
@@ -0,0 +1,59 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"testing"
+)
+
+func TestCheckRange(t *testing.T) {
+	tests := []struct {
+		spec   string
+		values map[float64]bool
+	}{
+		{spec: "28", values: map[float64]bool{-1: true, 0: false, 28: false, 28.1: true}},
+		{spec: "10:", values: map[float64]bool{9.9: true, 10: false, 100: false}},
+		{spec: "~:30", values: map[float64]bool{-20: false, 30: false, 30.5: true}},
+		{spec: "10:30", values: map[float64]bool{9: true, 10: false, 30: false, 31: true}},
+		{spec: "@10:20", values: map[float64]bool{9: false, 10: true, 20: true, 21: false}},
+		{spec: "-5:-1", values: map[float64]bool{-6: true, -3: false, 0: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			r, err := parseCheckRange(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for v, want := range tt.values {
+				if got := r.Alert(v); got != want {
+					t.Errorf("Alert(%g) = %v, want %v", v, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCheckRangeInvalid(t *testing.T) {
+	for _, spec := range []string{"abc", "10:abc", "30:10", "@"} {
+		if _, err := parseCheckRange(spec); err == nil {
+			t.Errorf("parseCheckRange(%q) succeeded, want error", spec)
+		}
+	}
+	if r, err := parseCheckRange(""); r != nil || err != nil {
+		t.Errorf("parseCheckRange(\"\") = %v, %v, want nil", r, err)
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/imishinist/nature-remo-exporter/pkg/collector"
+	"github.com/tenntenn/natureremo"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// apiBaseURL overrides the base URL of the Nature Remo API if it is not empty.
+var apiBaseURL string
+
+// apiProxyURL is the URL of the proxy to the Nature Remo API, which overrides HTTP_PROXY, HTTPS_PROXY and NO_PROXY if it is not empty.
+var apiProxyURL string
+
+// Connection settings of the transport of the API client.
+var (
+	apiMaxIdleConns        int
+	apiIdleConnTimeout     time.Duration
+	apiTLSHandshakeTimeout time.Duration
+	apiDisableKeepAlives   bool
+)
+
+// apiTimeout is the deadline of each API call including reading the response, or 0 for no deadline.
+var apiTimeout time.Duration
+
+// newFetcher creates the fetcher of the devices, which is the Nature Remo API unless the mock mode or the replay is enabled.
+func newFetcher() (collector.DeviceFetcher, error) {
+	if mockMode && replayDir != "" {
+		return nil, fmt.Errorf("--mock and --replay cannot be used together")
+	}
+	if replayDir != "" {
+		return newReplayFetcher(replayDir)
+	}
+	if mockMode {
+		return newMockFetcher(time.Now, rand.New(rand.NewSource(time.Now().UnixNano()))), nil
+	}
+	client, err := newClient(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	return collector.NewClientFetcher(client), nil
+}
+
+// newClient creates a Nature Remo API client which has its own http.Client,
+// so that its transport can be customized without affecting http.DefaultClient.
+func newClient(token string) (*natureremo.Client, error) {
+	base, err := newTransport()
+	if err != nil {
+		return nil, err
+	}
+	client := natureremo.NewClient(token)
+	if apiBaseURL != "" {
+		// the client appends the version and the path to the base URL without a separator
+		client.BaseURL = strings.TrimSuffix(apiBaseURL, "/") + "/"
+	}
+	var transport http.RoundTripper = &userAgentTransport{next: base, userAgent: userAgent()}
+	if apiAuditLogFile != "" {
+		// the audit log is written for each request sent, including the conditional ones answered by 304 Not Modified
+		transport, err = newAuditTransport(transport, apiAuditLogFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if apiConditionalRequests {
+		transport = newETagTransport(transport)
+	}
+	if apiResponses != nil {
+		transport = apiResponses.Transport(transport)
+	}
+	if recordDir != "" {
+		// the cached bodies of 304 Not Modified are also recorded, since the client receives them
+		transport, err = newRecordingTransport(transport, recordDir, recordMaxFiles)
+		if err != nil {
+			return nil, err
+		}
+	}
+	transport = &tracingTransport{next: &responseRecorder{next: &smartMeterRecorder{next: transport}}}
+	if apiRateLimitRedisURL != "" {
+		key := apiRateLimitRedisKey
+		if key == "" {
+			key = defaultRedisKey(token)
+		}
+		bucket, err := newRedisBucket(apiRateLimitRedisURL, key, apiRateLimitRequests, apiRateLimitPeriod)
+		if err != nil {
+			return nil, err
+		}
+		// the calls not sent by the exhausted budget are not recorded as the API requests
+		transport = &budgetTransport{next: transport, bucket: bucket}
+	}
+	client.HTTPClient = &http.Client{
+		Transport: transport,
+		// a hung connection would block the poll forever without the deadline
+		Timeout: apiTimeout,
+	}
+	return client, nil
+}
+
+// newTransport creates the base transport of the API client as a clone of http.DefaultTransport,
+// which takes the proxy from HTTP_PROXY, HTTPS_PROXY and NO_PROXY unless apiProxyURL is set.
+func newTransport() (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = apiMaxIdleConns
+	t.IdleConnTimeout = apiIdleConnTimeout
+	t.TLSHandshakeTimeout = apiTLSHandshakeTimeout
+	t.DisableKeepAlives = apiDisableKeepAlives
+	if apiProxyURL != "" {
+		u, err := url.Parse(apiProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %v", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("invalid proxy URL %q: scheme must be http, https or socks5", u.Redacted())
+		}
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q: host is missing", u.Redacted())
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	return t, nil
+}
+
+// maxAdaptiveInterval is the longest interval adaptiveInterval stretches the base interval to,
+// which is the window of the rate limit of Nature Remo API.
+const maxAdaptiveInterval = 5 * time.Minute
+
+// adaptiveInterval returns the interval to make the calls of each poll with the remaining rate limit until it is reset.
+// It is stretched from the base interval only if the polls at the base interval would run out of the limit,
+// and never beyond maxAdaptiveInterval even if the reset is announced later than that.
+// The calls of each poll can be fractional if some endpoints are not called by every poll.
+func adaptiveInterval(base time.Duration, rl collector.RateLimit, calls float64, now time.Time) time.Duration {
+	window := rl.Reset.Sub(now)
+	if window <= 0 {
+		return base
+	}
+	d := window
+	if polls := int(float64(rl.Remaining) / calls); polls > 0 {
+		d = window / time.Duration(polls)
+	}
+	d = min(d, maxAdaptiveInterval)
+	if d < base {
+		return base
+	}
+	return d
+}
+
+type apiResponseKey struct{}
+
+// apiResponse holds the response of an API call made with the context returned by withAPIResponse.
+type apiResponse struct {
+	collector.APIRequest
+	StatusCode int
+	Header     http.Header
+	// NotModified reports whether the body is the cached one since the server answered 304 Not Modified.
+	NotModified bool
+}
+
+// withAPIResponse returns a context which records the response of the API call made with it.
+// StatusCode is 0 if no response has been received.
+func withAPIResponse(ctx context.Context) (context.Context, *apiResponse) {
+	res := &apiResponse{}
+	return context.WithValue(ctx, apiResponseKey{}, res), res
+}
+
+// responseRecorder is an http.RoundTripper which records the responses to the apiResponse in the request context.
+type responseRecorder struct {
+	next http.RoundTripper
+}
+
+func (t *responseRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, ok := req.Context().Value(apiResponseKey{}).(*apiResponse)
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+	ctx, phases := withRequestPhases(req.Context())
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	res.Endpoint = req.URL.Path
+	res.Duration = time.Since(start)
+	res.Phases = phases.Durations()
+	if sc := trace.SpanContextFromContext(req.Context()); sc.IsSampled() {
+		res.TraceID = sc.TraceID().String()
+	}
+	if resp != nil {
+		res.StatusCode = resp.StatusCode
+		res.Header = resp.Header.Clone()
+		if rl, ok := collector.ParseRateLimit(resp.Header); ok {
+			res.RateLimit = &rl
+		}
+	}
+	return resp, err
+}
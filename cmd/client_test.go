@@ -0,0 +1,55 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/imishinist/nature-remo-exporter/pkg/collector"
+)
+
+func TestAdaptiveInterval(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		base      time.Duration
+		remaining int
+		reset     time.Duration
+		want      time.Duration
+	}{
+		{name: "enough budget", base: time.Minute, remaining: 30, reset: 5 * time.Minute, want: time.Minute},
+		{name: "stretched", base: time.Minute, remaining: 2, reset: 5 * time.Minute, want: 150 * time.Second},
+		{name: "exhausted", base: time.Minute, remaining: 0, reset: 5 * time.Minute, want: 5 * time.Minute},
+		{name: "reset announced beyond the window", base: time.Minute, remaining: 0, reset: time.Hour, want: maxAdaptiveInterval},
+		{name: "base beyond the window", base: 10 * time.Minute, remaining: 0, reset: time.Hour, want: 10 * time.Minute},
+		{name: "already reset", base: time.Minute, remaining: 0, reset: -time.Second, want: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := collector.RateLimit{Limit: 30, Remaining: tt.remaining, Reset: now.Add(tt.reset)}
+			got := adaptiveInterval(tt.base, rl, 1, now)
+			if got != tt.want {
+				t.Errorf("adaptiveInterval() = %s, want %s", got, tt.want)
+			}
+			// the loop beats once per period, which must not be reported as stuck
+			if limit := livenessMaxSilence(tt.base, true); 2*got >= limit {
+				t.Errorf("livenessMaxSilence() = %s, too short for two jittered periods of %s", limit, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cloudWatchMaxMetricData is the maximum number of values in a PutMetricData request.
+const cloudWatchMaxMetricData = 1000
+
+var (
+	cloudWatchNamespace string
+	cloudWatchRegion    string
+	cloudWatchEndpoint  string
+)
+
+// cloudWatchUnits are the CloudWatch units of the sensors. The others have no unit.
+var cloudWatchUnits = map[string]string{
+	"humidity": "Percent",
+}
+
+// cloudWatchSink puts the samples to Amazon CloudWatch with the PutMetricData action of the Query API.
+type cloudWatchSink struct {
+	client    *http.Client
+	endpoint  string
+	signer    *awsSigner
+	namespace string
+}
+
+func newCloudWatchSink(ctx context.Context, namespace, region, endpoint string) (*cloudWatchSink, error) {
+	cfg, err := loadAWSConfig(ctx, region)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials of CloudWatch: %v", err)
+	}
+	region = cfg.Region
+	if region == "" {
+		return nil, fmt.Errorf("--cloudwatch.region or AWS_REGION is required with --cloudwatch.namespace")
+	}
+	if endpoint == "" {
+		endpoint = "https://monitoring." + region + ".amazonaws.com/"
+		if strings.HasPrefix(region, "cn-") {
+			endpoint = "https://monitoring." + region + ".amazonaws.com.cn/"
+		}
+	}
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid CloudWatch endpoint: %v", err)
+	}
+	return &cloudWatchSink{
+		client:    &http.Client{},
+		endpoint:  endpoint,
+		signer:    newAWSSigner(cfg.Credentials, region, "monitoring"),
+		namespace: namespace,
+	}, nil
+}
+
+func (s *cloudWatchSink) Name() string {
+	return "cloudwatch"
+}
+
+func (s *cloudWatchSink) Write(ctx context.Context, samples []sample) error {
+	for len(samples) > 0 {
+		n := min(len(samples), cloudWatchMaxMetricData)
+		if err := s.put(ctx, samples[:n]); err != nil {
+			return err
+		}
+		samples = samples[n:]
+	}
+	return nil
+}
+
+func (s *cloudWatchSink) put(ctx context.Context, samples []sample) error {
+	form := url.Values{
+		"Action":    {"PutMetricData"},
+		"Version":   {"2010-08-01"},
+		"Namespace": {s.namespace},
+	}
+	for i, sample := range samples {
+		member := "MetricData.member." + strconv.Itoa(i+1) + "."
+		form.Set(member+"MetricName", sample.Metric)
+		form.Set(member+"Value", strconv.FormatFloat(sample.Value, 'f', -1, 64))
+		form.Set(member+"Timestamp", sample.Timestamp.UTC().Format(time.RFC3339))
+		form.Set(member+"Unit", "None")
+		if unit, ok := cloudWatchUnits[sample.Metric]; ok {
+			form.Set(member+"Unit", unit)
+		}
+		form.Set(member+"Dimensions.member.1.Name", "DeviceID")
+		form.Set(member+"Dimensions.member.1.Value", sample.DeviceID)
+		if sample.DeviceName != "" {
+			form.Set(member+"Dimensions.member.2.Name", "DeviceName")
+			form.Set(member+"Dimensions.member.2.Value", sample.DeviceName)
+		}
+	}
+	body := []byte(form.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	if err := s.signer.Sign(req, body); err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("CloudWatch returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
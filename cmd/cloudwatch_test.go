@@ -0,0 +1,91 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestCloudWatchSinkWrite(t *testing.T) {
+	var requests []*http.Request
+	var forms []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		form, _ := url.ParseQuery(string(body))
+		requests = append(requests, r)
+		forms = append(forms, form)
+	}))
+	defer srv.Close()
+
+	// the credentials of a role are refreshed, so they are retrieved for each request
+	retrieved := 0
+	credentials := aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		retrieved++
+		return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token"}, nil
+	})
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	s, err := newCloudWatchSink(context.Background(), "NatureRemo", "ap-northeast-1", srv.URL)
+	if err != nil {
+		t.Fatalf("newCloudWatchSink() error = %v", err)
+	}
+	s.signer.credentials = credentials
+
+	// the samples beyond the limit of PutMetricData are put by another request
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	samples := make([]sample, cloudWatchMaxMetricData+1)
+	for i := range samples {
+		samples[i] = sample{Metric: "temperature", DeviceID: "remo", DeviceName: "Living", Value: 21.5, Timestamp: now}
+	}
+	samples[0].Metric = "humidity"
+	if err := s.Write(context.Background(), samples); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if len(requests) != 2 || retrieved != 2 {
+		t.Fatalf("got %d requests with credentials retrieved %d times, want 2", len(requests), retrieved)
+	}
+
+	form := forms[0]
+	for key, want := range map[string]string{
+		"Action":                                        "PutMetricData",
+		"Namespace":                                     "NatureRemo",
+		"MetricData.member.1.MetricName":                "humidity",
+		"MetricData.member.1.Unit":                      "Percent",
+		"MetricData.member.1.Value":                     "21.5",
+		"MetricData.member.1.Timestamp":                 "2024-01-02T03:04:05Z",
+		"MetricData.member.1.Dimensions.member.1.Value": "remo",
+		"MetricData.member.1.Dimensions.member.2.Value": "Living",
+		"MetricData.member.2.Unit":                      "None",
+	} {
+		if got := form.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	auth := regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=AKID/\d{8}/ap-northeast-1/monitoring/aws4_request, SignedHeaders=\S*content-type;\S*x-amz-date;x-amz-security-token, Signature=[0-9a-f]{64}$`)
+	if got := requests[0].Header.Get("Authorization"); !auth.MatchString(got) {
+		t.Errorf("Authorization = %s", got)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/imishinist/nature-remo-exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/spf13/cobra"
+)
+
+// collectCmd represents the collect command
+var collectCmd = &cobra.Command{
+	Use:   "collect",
+	Short: "Poll the Nature Remo API once and print metrics to stdout",
+	Long: `Collect polls the Nature Remo API once, writes the metrics in the Prometheus
+text exposition format to stdout and exits.
+
+This is useful together with the textfile collector of node_exporter, or for
+checking what will be exported.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fetcher, err := newFetcher()
+		if err != nil {
+			return err
+		}
+		plugins, err := newPlugins()
+		if err != nil {
+			return err
+		}
+		reg := prometheus.NewRegistry()
+		c := collector.New(fetcher, metricsOptions(plugins), reg)
+		devices, _, err := fetchDevices(cmd.Context(), fetcher, c.Metrics())
+		if err != nil {
+			return err
+		}
+		if err := c.Reflect(cmd.Context(), devices, devices); err != nil {
+			return err
+		}
+		mfs, err := reg.Gather()
+		if err != nil {
+			return fmt.Errorf("failed to gather metrics: %v", err)
+		}
+		for _, mf := range mfs {
+			if _, err := expfmt.MetricFamilyToText(cmd.OutOrStdout(), mf); err != nil {
+				return fmt.Errorf("failed to write metrics: %v", err)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(collectCmd)
+}
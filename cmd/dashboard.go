@@ -0,0 +1,176 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/imishinist/nature-remo-exporter/pkg/collector"
+	"github.com/spf13/cobra"
+	"github.com/tenntenn/natureremo"
+)
+
+// dashboardPanel describes a panel of the generated Grafana dashboard.
+type dashboardPanel struct {
+	Title  string
+	Expr   string
+	Unit   string
+	Sensor string
+}
+
+var dashboardPanels = []dashboardPanel{
+	{Title: "Temperature", Expr: `nature_remo_temperature{name=~"$device"}`, Unit: "celsius", Sensor: "temperature"},
+	{Title: "Humidity", Expr: `nature_remo_humidity{name=~"$device"}`, Unit: "percent", Sensor: "humidity"},
+	{Title: "Illumination", Expr: `nature_remo_illumination{name=~"$device"}`, Unit: "none", Sensor: "illumination"},
+	{Title: "Movements", Expr: `increase(nature_remo_movements_total[$__rate_interval]) * on(id) group_left(name) nature_remo_device_info{name=~"$device"}`, Unit: "none", Sensor: "movement"},
+	{Title: "API calls", Expr: `rate(nature_remo_api_calls_total[$__rate_interval])`, Unit: "reqps"},
+}
+
+var (
+	dashboardTitle  string
+	dashboardOutput string
+
+	// dashboardCmd represents the dashboard command
+	dashboardCmd = &cobra.Command{
+		Use:   "dashboard",
+		Short: "Manage Grafana dashboards",
+	}
+
+	// dashboardGenerateCmd represents the dashboard generate command
+	dashboardGenerateCmd = &cobra.Command{
+		Use:   "generate",
+		Short: "Generate a Grafana dashboard JSON",
+		Long: `Generate prints a ready-to-import Grafana dashboard JSON tailored to the devices
+in the account. It has a variable for the device name, and a panel for each sensor
+supported by at least one of the devices.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fetcher, err := newFetcher()
+			if err != nil {
+				return err
+			}
+			devices, _, err := fetchDevices(cmd.Context(), fetcher, collector.NewMetrics())
+			if err != nil {
+				return err
+			}
+
+			var w io.Writer = cmd.OutOrStdout()
+			if dashboardOutput != "" && dashboardOutput != "-" {
+				f, err := os.Create(dashboardOutput)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(generateDashboard(dashboardTitle, devices))
+		},
+	}
+)
+
+// generateDashboard generates a Grafana dashboard model for the devices.
+func generateDashboard(title string, devices []*natureremo.Device) map[string]any {
+	supported := make(map[string]bool)
+	options := []any{map[string]any{"text": "All", "value": "$__all", "selected": true}}
+	for _, device := range devices {
+		options = append(options, map[string]any{"text": device.Name, "value": device.Name, "selected": false})
+		for name, sensorType := range sensorTypes {
+			if _, ok := device.NewestEvents[sensorType]; ok {
+				supported[name] = true
+			}
+		}
+	}
+
+	datasource := map[string]any{"type": "prometheus", "uid": "${datasource}"}
+	var panels []any
+	for _, p := range dashboardPanels {
+		if p.Sensor != "" && !supported[p.Sensor] {
+			continue
+		}
+		i := len(panels)
+		legend := "{{name}}"
+		if p.Sensor == "" {
+			legend = "api calls"
+		}
+		panels = append(panels, map[string]any{
+			"id":         i + 1,
+			"type":       "timeseries",
+			"title":      p.Title,
+			"datasource": datasource,
+			"gridPos":    map[string]any{"h": 8, "w": 12, "x": (i % 2) * 12, "y": (i / 2) * 8},
+			"fieldConfig": map[string]any{
+				"defaults":  map[string]any{"unit": p.Unit},
+				"overrides": []any{},
+			},
+			"targets": []any{
+				map[string]any{
+					"refId":        "A",
+					"datasource":   datasource,
+					"expr":         conformMetricNames(p.Expr),
+					"legendFormat": legend,
+				},
+			},
+		})
+	}
+
+	return map[string]any{
+		"title":         title,
+		"uid":           "nature-remo",
+		"tags":          []string{"nature-remo"},
+		"timezone":      "browser",
+		"schemaVersion": 39,
+		"refresh":       "1m",
+		"time":          map[string]any{"from": "now-24h", "to": "now"},
+		"templating": map[string]any{
+			"list": []any{
+				map[string]any{
+					"name":  "datasource",
+					"label": "Data source",
+					"type":  "datasource",
+					"query": "prometheus",
+				},
+				map[string]any{
+					"name":       "device",
+					"label":      "Device",
+					"type":       "query",
+					"datasource": datasource,
+					"query":      conformMetricNames("label_values(nature_remo_temperature, name)"),
+					"refresh":    2,
+					"multi":      true,
+					"includeAll": true,
+					"current":    map[string]any{"text": "All", "value": "$__all"},
+					"options":    options,
+				},
+			},
+		},
+		"panels": panels,
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(dashboardCmd)
+	dashboardCmd.AddCommand(dashboardGenerateCmd)
+
+	dashboardGenerateCmd.Flags().StringVar(&dashboardTitle, "title", "Nature Remo", "Title of the dashboard")
+	dashboardGenerateCmd.Flags().StringVarP(&dashboardOutput, "output", "o", "-", "File to write the dashboard to, or - for stdout")
+}
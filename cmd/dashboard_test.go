@@ -0,0 +1,113 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/tenntenn/natureremo"
+)
+
+func TestGenerateDashboard(t *testing.T) {
+	tests := []struct {
+		name    string
+		devices []*natureremo.Device
+		want    []string
+	}{
+		{
+			name: "temperature only",
+			devices: []*natureremo.Device{
+				{DeviceCore: natureremo.DeviceCore{Name: "Living"}, NewestEvents: map[natureremo.SensorType]natureremo.SensorValue{
+					natureremo.SensorTypeTemperature: {Value: 25},
+				}},
+			},
+			want: []string{"Temperature", "API calls"},
+		},
+		{
+			name: "all sensors",
+			devices: []*natureremo.Device{
+				{DeviceCore: natureremo.DeviceCore{Name: "Living"}, NewestEvents: map[natureremo.SensorType]natureremo.SensorValue{
+					natureremo.SensorTypeTemperature: {Value: 25},
+					natureremo.SensorTypeHumidity:    {Value: 40},
+				}},
+				{DeviceCore: natureremo.DeviceCore{Name: "Bedroom"}, NewestEvents: map[natureremo.SensorType]natureremo.SensorValue{
+					natureremo.SensorTypeIllumination: {Value: 100},
+					natureremo.SensorTypeMovement:     {Value: 1},
+				}},
+			},
+			want: []string{"Temperature", "Humidity", "Illumination", "Movements", "API calls"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(generateDashboard("Nature Remo", tt.devices))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var dashboard struct {
+				Panels []struct {
+					Title string `json:"title"`
+				} `json:"panels"`
+			}
+			if err := json.Unmarshal(b, &dashboard); err != nil {
+				t.Fatalf("invalid dashboard JSON: %v", err)
+			}
+			var titles []string
+			for _, p := range dashboard.Panels {
+				titles = append(titles, p.Title)
+			}
+			if !slices.Equal(titles, tt.want) {
+				t.Errorf("panels = %v, want %v", titles, tt.want)
+			}
+		})
+	}
+}
+
+func TestDashboardGenerateMock(t *testing.T) {
+	oldMockMode, oldOutput := mockMode, dashboardOutput
+	t.Cleanup(func() { mockMode, dashboardOutput = oldMockMode, oldOutput })
+	mockMode, dashboardOutput = true, ""
+
+	var buf bytes.Buffer
+	dashboardGenerateCmd.SetOut(&buf)
+	dashboardGenerateCmd.SetContext(context.Background())
+	t.Cleanup(func() { dashboardGenerateCmd.SetOut(nil) })
+	if err := dashboardGenerateCmd.RunE(dashboardGenerateCmd, nil); err != nil {
+		t.Fatalf("RunE() error = %v", err)
+	}
+
+	var dashboard struct {
+		Panels []struct {
+			Title string `json:"title"`
+		} `json:"panels"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &dashboard); err != nil {
+		t.Fatalf("invalid dashboard JSON: %v", err)
+	}
+	// the mock devices have all the sensors
+	var titles []string
+	for _, p := range dashboard.Panels {
+		titles = append(titles, p.Title)
+	}
+	if len(titles) != len(dashboardPanels) {
+		t.Errorf("panels = %v, want a panel for each sensor and the API calls", titles)
+	}
+}
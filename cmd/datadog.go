@@ -0,0 +1,121 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// datadogAPIKeyEnv is the environment variable to set the Datadog API key instead of the flag.
+const datadogAPIKeyEnv = "DD_API_KEY"
+
+// datadogGauge is the type of the gauge metrics in the series API.
+const datadogGauge = 3
+
+var (
+	datadogSite   string
+	datadogAPIKey string
+	datadogPrefix string
+	datadogTags   []string
+)
+
+// datadogSink submits the samples to the metrics API of Datadog as gauges.
+type datadogSink struct {
+	client    *http.Client
+	seriesURL string
+	apiKey    string
+	prefix    string
+	extra     []string
+}
+
+type datadogPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+type datadogSeries struct {
+	Metric string         `json:"metric"`
+	Type   int            `json:"type"`
+	Points []datadogPoint `json:"points"`
+	Tags   []string       `json:"tags"`
+}
+
+// newDatadogSink creates a sink which submits to the site of Datadog, such as datadoghq.com or datadoghq.eu.
+func newDatadogSink(site, apiKey, prefix string, extraTags []string) (*datadogSink, error) {
+	if apiKey == "" {
+		apiKey = os.Getenv(datadogAPIKeyEnv)
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("--datadog.api-key or %s is required with --datadog.site", datadogAPIKeyEnv)
+	}
+	return &datadogSink{
+		client:    &http.Client{},
+		seriesURL: "https://api." + site + "/api/v2/series",
+		apiKey:    apiKey,
+		prefix:    prefix,
+		extra:     extraTags,
+	}, nil
+}
+
+func (s *datadogSink) Name() string {
+	return "datadog"
+}
+
+func (s *datadogSink) Write(ctx context.Context, samples []sample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	series := make([]datadogSeries, 0, len(samples))
+	for _, sample := range samples {
+		series = append(series, datadogSeries{
+			Metric: s.prefix + sample.Metric,
+			Type:   datadogGauge,
+			Points: []datadogPoint{{Timestamp: sample.Timestamp.Unix(), Value: sample.Value}},
+			Tags: append([]string{
+				"device_id:" + sample.DeviceID,
+				"device_name:" + sample.DeviceName,
+			}, s.extra...),
+		})
+	}
+	body, err := json.Marshal(map[string][]datadogSeries{"series": series})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.seriesURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", s.apiKey)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Datadog returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/imishinist/nature-remo-exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	webEnableDebugAPI   bool
+	webEnableDebugState bool
+)
+
+// apiResponses keeps the last raw responses of the API for /debug/api if it is enabled.
+var apiResponses *rawResponses
+
+// rawResponse is a response of the API as received, with the request which has the token redacted.
+type rawResponse struct {
+	Time           time.Time       `json:"time"`
+	Method         string          `json:"method"`
+	URL            string          `json:"url"`
+	RequestHeader  http.Header     `json:"request_header"`
+	Status         int             `json:"status"`
+	ResponseHeader http.Header     `json:"response_header"`
+	Body           json.RawMessage `json:"body,omitempty"`
+	// BodyText is the body which is not JSON, such as an error page of a proxy.
+	BodyText string `json:"body_text,omitempty"`
+}
+
+// rawResponses keeps the last raw response of each endpoint, such as devices and appliances.
+type rawResponses struct {
+	mu        sync.Mutex
+	responses map[string]*rawResponse
+}
+
+func newRawResponses() *rawResponses {
+	return &rawResponses{responses: make(map[string]*rawResponse)}
+}
+
+// Transport returns an http.RoundTripper which keeps the responses of next.
+func (r *rawResponses) Transport(next http.RoundTripper) http.RoundTripper {
+	return &rawResponseTransport{next: next, responses: r}
+}
+
+// Handler serves the last responses by the endpoint.
+func (r *rawResponses) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		enc.Encode(r.responses)
+	})
+}
+
+type rawResponseTransport struct {
+	next      http.RoundTripper
+	responses *rawResponses
+}
+
+func (t *rawResponseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	header := req.Header.Clone()
+	if header.Get("Authorization") != "" {
+		header.Set("Authorization", "<redacted>")
+	}
+	raw := &rawResponse{
+		Time:           time.Now(),
+		Method:         req.Method,
+		URL:            req.URL.Redacted(),
+		RequestHeader:  header,
+		Status:         resp.StatusCode,
+		ResponseHeader: resp.Header.Clone(),
+	}
+	if json.Valid(body) {
+		raw.Body = body
+	} else {
+		raw.BodyText = string(body)
+	}
+	t.responses.mu.Lock()
+	t.responses.responses[path.Base(req.URL.Path)] = raw
+	t.responses.mu.Unlock()
+	return resp, nil
+}
+
+// pollState is the internal state of the polling loop for /debug/state.
+// It is copied by the loop after each poll, since the loop owns the state.
+type pollState struct {
+	PolledAt time.Time `json:"polled_at"`
+	Ready    bool      `json:"ready"`
+	Idle     bool      `json:"idle"`
+	// Movements is the last movement and the movement counter of each device, to tell why a movement is not counted.
+	Movements           map[string]collector.DeviceState `json:"movements"`
+	AppliancesUpdatedAt *time.Time                       `json:"appliances_updated_at,omitempty"`
+	RateLimitedUntil    *time.Time                       `json:"rate_limited_until,omitempty"`
+	CircuitBreaker      string                           `json:"circuit_breaker,omitempty"`
+	// DeviceIntervals and DevicesUpdatedAt are the intervals in --devices.config.file and the last updates by them.
+	DeviceIntervals  map[string]string    `json:"device_intervals,omitempty"`
+	DevicesUpdatedAt map[string]time.Time `json:"devices_updated_at,omitempty"`
+	// Leader is the advertised URL of the leader in the HA mode.
+	Leader string `json:"leader,omitempty"`
+}
+
+// debugState serves the internal state on /debug/state.
+type debugState struct {
+	snap *snapshot
+	reg  prometheus.Gatherer
+
+	mu    sync.Mutex
+	state pollState
+}
+
+func newDebugState(snap *snapshot, reg prometheus.Gatherer) *debugState {
+	return &debugState{snap: snap, reg: reg}
+}
+
+// Set replaces the state of the polling loop.
+func (d *debugState) Set(state pollState) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.state = state
+}
+
+func (d *debugState) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		series := 0
+		mfs, err := d.reg.Gather()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to gather metrics: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for _, mf := range mfs {
+			series += len(mf.GetMetric())
+		}
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(struct {
+			pollState
+			SnapshotUpdatedAt *time.Time `json:"snapshot_updated_at,omitempty"`
+			// Series is the number of the series exported on /metrics.
+			Series int `json:"series"`
+		}{d.state, timeOrNil(d.snap.UpdatedAt()), series})
+	})
+}
+
+// timeOrNil returns nil for the zero time, to omit it in JSON.
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,161 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/imishinist/nature-remo-exporter/pkg/collector"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// maxClockSkew is the clock skew which is reported as a problem by doctor.
+	maxClockSkew = 30 * time.Second
+	// minRateLimitRemaining is the remaining rate limit which is reported as a problem by doctor.
+	minRateLimitRemaining = 30
+	// maxEventAge is the age of the newest sensor event which is reported as a problem by doctor.
+	maxEventAge = time.Hour
+)
+
+// doctor prints the findings of the diagnostics.
+type doctor struct {
+	w        io.Writer
+	problems int
+}
+
+func (d *doctor) ok(format string, args ...any) {
+	fmt.Fprintf(d.w, "[OK]   %s\n", fmt.Sprintf(format, args...))
+}
+
+func (d *doctor) warn(hint string, format string, args ...any) {
+	d.problems++
+	fmt.Fprintf(d.w, "[WARN] %s\n", fmt.Sprintf(format, args...))
+	fmt.Fprintf(d.w, "       hint: %s\n", hint)
+}
+
+func (d *doctor) fail(hint string, format string, args ...any) {
+	d.problems++
+	fmt.Fprintf(d.w, "[FAIL] %s\n", fmt.Sprintf(format, args...))
+	fmt.Fprintf(d.w, "       hint: %s\n", hint)
+}
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose common problems",
+	Long: `Doctor checks the token, the reachability of the Nature Remo API,
+the rate limit budget, the clock skew and the sensors of each device,
+and prints actionable findings.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		d := &doctor{w: cmd.OutOrStdout()}
+		runDoctor(cmd, d)
+		if d.problems > 0 {
+			return fmt.Errorf("%d problem(s) found", d.problems)
+		}
+		fmt.Fprintln(d.w, "no problems found")
+		return nil
+	},
+}
+
+func runDoctor(cmd *cobra.Command, d *doctor) {
+	ctx := cmd.Context()
+	if accessToken == "" {
+		d.fail("create an access token at https://home.nature.global and pass it with --token", "access token is not set")
+		return
+	}
+
+	client, err := newClient(accessToken)
+	if err != nil {
+		d.fail("fix --api.proxy-url", "%v", err)
+		return
+	}
+
+	meCtx, res := withAPIResponse(ctx)
+	user, err := client.UserService.Me(meCtx)
+	status, header := res.StatusCode, res.Header
+	switch {
+	case err != nil && status == 0:
+		d.fail("check the network connectivity, DNS and proxy settings (HTTPS_PROXY or --api.proxy-url) to the API, or --api.base-url", "Nature Remo API is not reachable: %v", err)
+		return
+	case status == http.StatusUnauthorized:
+		d.fail("the token may be revoked or mistyped; generate a new one at https://home.nature.global", "token is invalid: %v", err)
+		return
+	case err != nil:
+		d.fail("retry later; see https://status.nature.global for outages", "Nature Remo API returned an error: %v", err)
+		return
+	}
+	d.ok("Nature Remo API is reachable")
+	d.ok("token is valid (user: %s)", user.Nickname)
+
+	if rl, ok := collector.ParseRateLimit(header); ok {
+		if rl.Remaining < minRateLimitRemaining {
+			d.warn("increase --interval or stop other tools sharing the token", "rate limit budget is low: %d/%d remaining until %s", rl.Remaining, rl.Limit, rl.Reset.Format(time.RFC3339))
+		} else {
+			d.ok("rate limit budget: %d/%d remaining until %s", rl.Remaining, rl.Limit, rl.Reset.Format(time.RFC3339))
+		}
+	} else {
+		d.warn("the API may have changed; rate limiting cannot be monitored", "rate limit headers are missing")
+	}
+
+	if date, err := http.ParseTime(header.Get("Date")); err == nil {
+		skew := time.Since(date).Round(time.Second)
+		if skew > maxClockSkew || skew < -maxClockSkew {
+			d.warn("synchronize the clock with NTP", "clock skew against the API server is %s", skew)
+		} else {
+			d.ok("clock skew against the API server is %s", skew)
+		}
+	}
+
+	devices, err := client.DeviceService.GetAll(ctx)
+	if err != nil {
+		d.fail("retry later; see https://status.nature.global for outages", "failed to get all devices: %v", err)
+		return
+	}
+	if len(devices) == 0 {
+		d.warn("register a device with the Nature Remo app", "no devices found")
+		return
+	}
+	for _, device := range devices {
+		var sensors []string
+		for _, name := range sensorNames() {
+			event, ok := device.NewestEvents[sensorTypes[name]]
+			if !ok {
+				continue
+			}
+			sensors = append(sensors, name)
+			if age := time.Since(event.CreatedAt); name != "movement" && age > maxEventAge {
+				d.warn("check the power supply and Wi-Fi connection of the device", "device %q: %s was last updated %s ago", device.Name, name, age.Round(time.Second))
+			}
+		}
+		if len(sensors) == 0 {
+			d.warn("some models such as Nature Remo E have no sensors", "device %q (firmware %s) has no sensors", device.Name, device.FirmwareVersion)
+			continue
+		}
+		d.ok("device %q (firmware %s) supports: %s", device.Name, device.FirmwareVersion, strings.Join(sensors, ", "))
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
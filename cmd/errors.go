@@ -0,0 +1,189 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// apiErrorClass is the category of errors from the Nature Remo API.
+type apiErrorClass string
+
+const (
+	apiErrorAuth      apiErrorClass = "auth"
+	apiErrorRateLimit apiErrorClass = "rate-limit"
+	apiErrorNetwork   apiErrorClass = "network"
+	apiErrorDecode    apiErrorClass = "decode"
+	apiErrorServer    apiErrorClass = "server"
+	apiErrorClient    apiErrorClass = "client"
+)
+
+// apiError is an error from the Nature Remo API classified by its cause.
+type apiError struct {
+	Class      apiErrorClass
+	Endpoint   string
+	StatusCode int
+	// Retryable reports whether the call may succeed if it is retried.
+	Retryable bool
+	// RetryAt is when the rate limit is reset, if the call was rate limited and the response tells it.
+	RetryAt time.Time
+
+	Err error
+}
+
+// newAPIError classifies the error of the API call by its response.
+func newAPIError(err error, res *apiResponse) *apiError {
+	e := &apiError{
+		Endpoint:   res.Endpoint,
+		StatusCode: res.StatusCode,
+		Err:        err,
+	}
+	var budgetErr *budgetError
+	if errors.As(err, &budgetErr) {
+		e.Class, e.RetryAt = apiErrorRateLimit, budgetErr.RetryAt
+		return e
+	}
+	switch status := res.StatusCode; {
+	case status == 0:
+		e.Class, e.Retryable = apiErrorNetwork, true
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		e.Class, e.Retryable = apiErrorAuth, false
+	case status == http.StatusTooManyRequests:
+		// retrying only extends the limit, and RetryAt tells when the polls can be resumed
+		e.Class, e.Retryable = apiErrorRateLimit, false
+		e.RetryAt = retryAt(res.Header, time.Now())
+	case status >= 500:
+		e.Class, e.Retryable = apiErrorServer, true
+	case status >= 400:
+		e.Class, e.Retryable = apiErrorClient, false
+	default:
+		// the response was successful, but it could not be decoded
+		e.Class, e.Retryable = apiErrorDecode, false
+	}
+	return e
+}
+
+// retryAt returns when a rate limited call can be retried by Retry-After, or X-Rate-Limit-Reset if it is missing.
+// It returns the zero time if neither tells it.
+func retryAt(h http.Header, now time.Time) time.Time {
+	if v := h.Get("Retry-After"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil {
+			return now.Add(time.Duration(seconds) * time.Second)
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return t
+		}
+	}
+	if reset, err := strconv.ParseInt(h.Get("X-Rate-Limit-Reset"), 10, 64); err == nil {
+		return time.Unix(reset, 0)
+	}
+	return time.Time{}
+}
+
+// rateLimitedUntil returns when the rate limit which failed the call is reset, or the zero time if it is unknown.
+func rateLimitedUntil(err error) time.Time {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.Class != apiErrorRateLimit {
+		return time.Time{}
+	}
+	return apiErr.RetryAt
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s error: %v", e.Class, e.Err)
+}
+
+func (e *apiError) Unwrap() error {
+	return e.Err
+}
+
+// logError logs the error with the structured fields of apiError if it wraps one.
+func logError(logger *slog.Logger, err error) {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		logger.Error(err.Error())
+		return
+	}
+	logger.Error(err.Error(),
+		slog.String("error_class", string(apiErr.Class)),
+		slog.Int("http_status", apiErr.StatusCode),
+		slog.String("endpoint", apiErr.Endpoint),
+		slog.Bool("retryable", apiErr.Retryable),
+	)
+}
+
+// errorSampler suppresses repeated error logs.
+// It logs the first occurrence of an error, and then logs a summary of the repeats every summaryInterval.
+type errorSampler struct {
+	logger          *slog.Logger
+	summaryInterval time.Duration
+
+	last       string
+	count      int
+	since      time.Time
+	lastLogged time.Time
+	suppressed int
+}
+
+func newErrorSampler(logger *slog.Logger, summaryInterval time.Duration) *errorSampler {
+	return &errorSampler{
+		logger:          logger,
+		summaryInterval: summaryInterval,
+	}
+}
+
+// Error logs the error unless it is a repeat of the last error within summaryInterval.
+func (s *errorSampler) Error(err error) {
+	now := time.Now()
+	if s.summaryInterval <= 0 || err.Error() != s.last {
+		s.last = err.Error()
+		s.count = 1
+		s.since = now
+		s.lastLogged = now
+		s.suppressed = 0
+		logError(s.logger, err)
+		return
+	}
+
+	s.count++
+	s.suppressed++
+	if now.Sub(s.lastLogged) < s.summaryInterval {
+		return
+	}
+	logError(s.logger.With(
+		slog.Int("failures", s.count),
+		slog.Duration("failing_for", now.Sub(s.since).Round(time.Second)),
+	), fmt.Errorf("failed %d times in the last %s: %w", s.suppressed, now.Sub(s.lastLogged).Round(time.Second), err))
+	s.lastLogged = now
+	s.suppressed = 0
+}
+
+// Success resets the state, and logs the recovery if errors have been logged.
+func (s *errorSampler) Success() {
+	if s.count > 0 {
+		s.logger.Info(fmt.Sprintf("recovered after %d failures", s.count),
+			slog.Duration("failing_for", time.Since(s.since).Round(time.Second)))
+	}
+	s.last = ""
+	s.count = 0
+	s.suppressed = 0
+}
@@ -0,0 +1,84 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewAPIError(t *testing.T) {
+	budgetRetryAt := time.Now().Add(time.Minute)
+	tests := []struct {
+		name          string
+		err           error
+		status        int
+		header        http.Header
+		wantClass     apiErrorClass
+		wantRetryable bool
+		wantRetryAt   bool
+	}{
+		{name: "network", status: 0, wantClass: apiErrorNetwork, wantRetryable: true},
+		{name: "unauthorized", status: http.StatusUnauthorized, wantClass: apiErrorAuth},
+		{name: "forbidden", status: http.StatusForbidden, wantClass: apiErrorAuth},
+		{name: "rate limited", status: http.StatusTooManyRequests, wantClass: apiErrorRateLimit},
+		{
+			name:        "rate limited with Retry-After",
+			status:      http.StatusTooManyRequests,
+			header:      http.Header{"Retry-After": {"120"}},
+			wantClass:   apiErrorRateLimit,
+			wantRetryAt: true,
+		},
+		{
+			name:        "shared budget exhausted",
+			err:         &budgetError{RetryAt: budgetRetryAt},
+			wantClass:   apiErrorRateLimit,
+			wantRetryAt: true,
+		},
+		{name: "internal server error", status: http.StatusInternalServerError, wantClass: apiErrorServer, wantRetryable: true},
+		{name: "service unavailable", status: http.StatusServiceUnavailable, wantClass: apiErrorServer, wantRetryable: true},
+		{name: "not found", status: http.StatusNotFound, wantClass: apiErrorClient},
+		{name: "undecodable response", status: http.StatusOK, wantClass: apiErrorDecode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.err
+			if err == nil {
+				err = errors.New("failed")
+			}
+			res := &apiResponse{StatusCode: tt.status, Header: tt.header}
+			if res.Header == nil {
+				res.Header = http.Header{}
+			}
+			e := newAPIError(err, res)
+			if e.Class != tt.wantClass {
+				t.Errorf("Class = %s, want %s", e.Class, tt.wantClass)
+			}
+			if e.Retryable != tt.wantRetryable {
+				t.Errorf("Retryable = %v, want %v", e.Retryable, tt.wantRetryable)
+			}
+			if got := !e.RetryAt.IsZero(); got != tt.wantRetryAt {
+				t.Errorf("RetryAt = %v, want set %v", e.RetryAt, tt.wantRetryAt)
+			}
+			if !errors.Is(e, err) {
+				t.Errorf("the error does not wrap %v", err)
+			}
+		})
+	}
+}
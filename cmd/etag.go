@@ -0,0 +1,94 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+var apiConditionalRequests bool
+
+// etagTransport sends conditional requests with the ETag of the last response of each URL.
+// The client of the API does not accept 304 Not Modified, so the cached body is served as 200 OK instead,
+// with the headers of the 304 response such as the rate limit.
+type etagTransport struct {
+	next http.RoundTripper
+
+	mu    sync.Mutex
+	cache map[string]etagEntry
+}
+
+type etagEntry struct {
+	etag        string
+	contentType string
+	body        []byte
+}
+
+func newETagTransport(next http.RoundTripper) *etagTransport {
+	return &etagTransport{next: next, cache: make(map[string]etagEntry)}
+}
+
+func (t *etagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.next.RoundTrip(req)
+	}
+	key := req.URL.String()
+	t.mu.Lock()
+	entry, cached := t.cache[key]
+	t.mu.Unlock()
+	if cached {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", entry.etag)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached:
+		resp.Body.Close()
+		if res, ok := req.Context().Value(apiResponseKey{}).(*apiResponse); ok {
+			res.NotModified = true
+		}
+		resp.StatusCode = http.StatusOK
+		resp.Status = "200 OK"
+		resp.Header.Set("Content-Type", entry.contentType)
+		resp.Header.Set("Content-Length", strconv.Itoa(len(entry.body)))
+		resp.ContentLength = int64(len(entry.body))
+		resp.Body = io.NopCloser(bytes.NewReader(entry.body))
+	case resp.StatusCode == http.StatusOK && resp.Header.Get("ETag") != "":
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		t.mu.Lock()
+		t.cache[key] = etagEntry{etag: resp.Header.Get("ETag"), contentType: resp.Header.Get("Content-Type"), body: body}
+		t.mu.Unlock()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	case resp.StatusCode == http.StatusOK && cached:
+		t.mu.Lock()
+		delete(t.cache, key)
+		t.mu.Unlock()
+	}
+	return resp, nil
+}
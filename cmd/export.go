@@ -0,0 +1,102 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportFrom   string
+	exportTo     string
+	exportDevice string
+	exportMetric string
+	exportOutput string
+
+	// exportCmd represents the export command
+	exportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Export the readings in the history database",
+	}
+
+	// exportCSVCmd represents the export csv command
+	exportCSVCmd = &cobra.Command{
+		Use:   "csv",
+		Short: "Export the readings in the history database as CSV",
+		Long: `CSV dumps the readings stored by --history.path between --from and --to as CSV,
+for spreadsheets and energy reports. The time is in RFC 3339 or in Unix time,
+and the range is the last 24 hours by default.
+
+  nature-remo-exporter export csv --history.path=history.db --from=2024-01-01T00:00:00+09:00 --device=Living`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if historyPath == "" {
+				return fmt.Errorf("--history.path is required")
+			}
+			// do not create an empty database by a typo
+			if _, err := os.Stat(historyPath); err != nil {
+				return fmt.Errorf("failed to open history database: %v", err)
+			}
+			to, err := parseHistoryTime(exportTo, time.Now())
+			if err != nil {
+				return fmt.Errorf("invalid --to: %v", err)
+			}
+			from, err := parseHistoryTime(exportFrom, to.Add(-24*time.Hour))
+			if err != nil {
+				return fmt.Errorf("invalid --from: %v", err)
+			}
+
+			hist, err := openHistory(historyPath, 0)
+			if err != nil {
+				return err
+			}
+			defer hist.Close()
+			samples, err := hist.Query(cmd.Context(), exportDevice, exportMetric, from, to)
+			if err != nil {
+				return fmt.Errorf("failed to query history: %v", err)
+			}
+
+			var w io.Writer = cmd.OutOrStdout()
+			if exportOutput != "" && exportOutput != "-" {
+				f, err := os.Create(exportOutput)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			return writeCSV(w, samples)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.AddCommand(exportCSVCmd)
+
+	exportCSVCmd.Flags().StringVar(&exportFrom, "from", "", "Start of the time range (default 24 hours before --to)")
+	exportCSVCmd.Flags().StringVar(&exportTo, "to", "", "End of the time range (default now)")
+	exportCSVCmd.Flags().StringVar(&exportDevice, "device", "", "ID or name of the device to export (default all devices)")
+	exportCSVCmd.Flags().StringVar(&exportMetric, "metric", "", "Metric to export, such as temperature (default all metrics)")
+	exportCSVCmd.Flags().StringVarP(&exportOutput, "output", "o", "-", "File to write the CSV to, or - for stdout")
+}
@@ -0,0 +1,200 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tenntenn/natureremo"
+)
+
+var (
+	recordDir      string
+	recordMaxFiles int
+	replayDir      string
+)
+
+// fixtureTimeFormat is the time in the names of the fixtures, which sort in the order of the responses.
+const fixtureTimeFormat = "20060102T150405.000000000Z"
+
+// recordingTransport writes the body of each successful response to a fixture in the directory,
+// named by the last element of the path and the time, e.g. devices-20240102T030405.000000000Z.json.
+// Only the latest maxFiles fixtures of each endpoint are kept unless it is 0.
+type recordingTransport struct {
+	next     http.RoundTripper
+	dir      string
+	maxFiles int
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func newRecordingTransport(next http.RoundTripper, dir string, maxFiles int) (*recordingTransport, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create record directory: %v", err)
+	}
+	return &recordingTransport{next: next, dir: dir, maxFiles: maxFiles}, nil
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	endpoint := path.Base(req.URL.Path)
+	name := fmt.Sprintf("%s-%s.json", endpoint, t.now().Format(fixtureTimeFormat))
+	if err := os.WriteFile(filepath.Join(t.dir, name), body, 0o644); err != nil {
+		// the recording is asked for a bug report, so its failure is not ignored
+		return nil, fmt.Errorf("failed to record response: %v", err)
+	}
+	if err := t.removeOldest(endpoint); err != nil {
+		return nil, fmt.Errorf("failed to remove old fixtures: %v", err)
+	}
+	return resp, nil
+}
+
+// removeOldest removes the oldest fixtures of the endpoint beyond maxFiles.
+func (t *recordingTransport) removeOldest(endpoint string) error {
+	if t.maxFiles <= 0 {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fixtures, err := filepath.Glob(filepath.Join(t.dir, endpoint+"-*.json"))
+	if err != nil {
+		return err
+	}
+	// the names sort in the order of the responses
+	sort.Strings(fixtures)
+	for len(fixtures) > t.maxFiles {
+		if err := os.Remove(fixtures[0]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		fixtures = fixtures[1:]
+	}
+	return nil
+}
+
+// now returns the current time in UTC, which is after the last one not to overwrite a fixture.
+func (t *recordingTransport) now() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now().UTC()
+	if !now.After(t.last) {
+		now = t.last.Add(time.Nanosecond)
+	}
+	t.last = now
+	return now
+}
+
+// replayFetcher is a collector.DeviceFetcher which returns the fixtures recorded by recordingTransport in order.
+// The last fixture is returned repeatedly after all fixtures are replayed.
+type replayFetcher struct {
+	devices    []string
+	appliances []string
+
+	mu             sync.Mutex
+	devicesNext    int
+	appliancesNext int
+}
+
+func newReplayFetcher(dir string) (*replayFetcher, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replay directory: %v", err)
+	}
+	f := &replayFetcher{}
+	for _, e := range entries {
+		switch name := e.Name(); {
+		case strings.HasPrefix(name, "devices-") && strings.HasSuffix(name, ".json"):
+			f.devices = append(f.devices, filepath.Join(dir, name))
+		case strings.HasPrefix(name, "appliances-") && strings.HasSuffix(name, ".json"):
+			f.appliances = append(f.appliances, filepath.Join(dir, name))
+		}
+	}
+	if len(f.devices) == 0 {
+		return nil, fmt.Errorf("no devices fixtures in replay directory %s", dir)
+	}
+	sort.Strings(f.devices)
+	sort.Strings(f.appliances)
+	return f, nil
+}
+
+func (f *replayFetcher) FetchDevices(ctx context.Context) ([]*natureremo.Device, error) {
+	var devices []*natureremo.Device
+	if err := readFixture(f.next(f.devices, &f.devicesNext), &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+func (f *replayFetcher) FetchAppliances(ctx context.Context) ([]*natureremo.Appliance, error) {
+	if len(f.appliances) == 0 {
+		return nil, fmt.Errorf("no appliances fixtures to replay")
+	}
+	name := f.next(f.appliances, &f.appliancesNext)
+	var appliances []*natureremo.Appliance
+	if err := readFixture(name, &appliances); err != nil {
+		return nil, err
+	}
+	var meters []smartMeterAppliance
+	if err := readFixture(name, &meters); err == nil {
+		recordSmartMeters(ctx, smartMeterSamples(meters))
+	}
+	return appliances, nil
+}
+
+// next returns the next fixture of the list and advances the index, staying at the last one.
+func (f *replayFetcher) next(fixtures []string, i *int) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := fixtures[*i]
+	if *i < len(fixtures)-1 {
+		*i++
+	}
+	return name
+}
+
+func readFixture(name string, v any) error {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("failed to read fixture: %v", err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("failed to parse fixture %s: %v", name, err)
+	}
+	return nil
+}
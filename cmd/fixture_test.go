@@ -0,0 +1,74 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordingTransportKeepsLatestFixtures(t *testing.T) {
+	n := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		fmt.Fprintf(w, "[%d]", n)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	transport, err := newRecordingTransport(http.DefaultTransport, dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: transport}
+	get := func(path string) {
+		t.Helper()
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	for i := 0; i < 5; i++ {
+		get("/1/devices")
+	}
+	get("/1/appliances")
+
+	devices, _ := filepath.Glob(filepath.Join(dir, "devices-*.json"))
+	if len(devices) != 3 {
+		t.Fatalf("kept %d devices fixtures, want 3", len(devices))
+	}
+	// the oldest ones are removed
+	for i, fixture := range devices {
+		b, err := os.ReadFile(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("[%d]", i+3); string(b) != want {
+			t.Errorf("fixture %d = %s, want %s", i, b, want)
+		}
+	}
+	if appliances, _ := filepath.Glob(filepath.Join(dir, "appliances-*.json")); len(appliances) != 1 {
+		t.Errorf("kept %d appliances fixtures, want 1", len(appliances))
+	}
+}
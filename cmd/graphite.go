@@ -0,0 +1,105 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+)
+
+var (
+	graphiteAddress      string
+	graphitePathTemplate string
+)
+
+// graphiteInvalidChars matches the characters which cannot be a part of a path component of Graphite.
+var graphiteInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_\-]+`)
+
+// graphiteDeviceName returns the device name as a path component of Graphite.
+// The names with non-ASCII characters such as "リビング" would be sanitized into the same "_" and overwrite each other,
+// so the device ID is used for them instead.
+func graphiteDeviceName(sample sample) string {
+	name := graphiteInvalidChars.ReplaceAllString(sample.DeviceName, "_")
+	if strings.Trim(name, "_") == "" || !isASCII(sample.DeviceName) {
+		return graphiteInvalidChars.ReplaceAllString(sample.DeviceID, "_")
+	}
+	return name
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// graphiteSink sends the samples to Carbon in the plaintext protocol.
+type graphiteSink struct {
+	address string
+	path    *template.Template
+}
+
+func newGraphiteSink(address, pathTemplate string) (*graphiteSink, error) {
+	t, err := template.New("path").Option("missingkey=error").Parse(pathTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Graphite path template: %v", err)
+	}
+	return &graphiteSink{address: address, path: t}, nil
+}
+
+func (s *graphiteSink) Name() string {
+	return "graphite"
+}
+
+func (s *graphiteSink) Write(ctx context.Context, samples []sample) error {
+	var body bytes.Buffer
+	for _, sample := range samples {
+		// the fields are sanitized so that they do not split the path
+		sanitized := sample
+		sanitized.Metric = graphiteInvalidChars.ReplaceAllString(sample.Metric, "_")
+		sanitized.DeviceID = graphiteInvalidChars.ReplaceAllString(sample.DeviceID, "_")
+		sanitized.DeviceName = graphiteDeviceName(sample)
+		if err := s.path.Execute(&body, sanitized); err != nil {
+			return fmt.Errorf("failed to execute Graphite path template: %v", err)
+		}
+		fmt.Fprintf(&body, " %s %d\n", strconv.FormatFloat(sample.Value, 'f', -1, 64), sample.Timestamp.Unix())
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+	}
+	_, err = conn.Write(body.Bytes())
+	return err
+}
@@ -0,0 +1,77 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGraphiteSinkWrite(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var got []string
+		for sc := bufio.NewScanner(conn); sc.Scan(); {
+			got = append(got, sc.Text())
+		}
+		lines <- got
+	}()
+
+	s, err := newGraphiteSink(ln.Addr().String(), "nature_remo.{{.DeviceName}}.{{.Metric}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Unix(1700000000, 0)
+	samples := []sample{
+		{Metric: "temperature", DeviceID: "id-1", DeviceName: "Living room", Value: 20.5, Timestamp: ts},
+		{Metric: "temperature", DeviceID: "id-2", DeviceName: "リビング", Value: 21, Timestamp: ts},
+		{Metric: "temperature", DeviceID: "id-3", DeviceName: "寝室", Value: -3.5, Timestamp: ts},
+		{Metric: "temperature", DeviceID: "id-4", DeviceName: "Remo 寝室", Value: 18, Timestamp: ts},
+	}
+	if err := s.Write(context.Background(), samples); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"nature_remo.Living_room.temperature 20.5 1700000000",
+		"nature_remo.id-2.temperature 21 1700000000",
+		"nature_remo.id-3.temperature -3.5 1700000000",
+		"nature_remo.id-4.temperature 18 1700000000",
+	}
+	got := <-lines
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
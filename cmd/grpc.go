@@ -0,0 +1,171 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/exporter-toolkit/web"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	apiv1 "github.com/imishinist/nature-remo-exporter/api/v1"
+)
+
+// grpcService serves the snapshot and the stream over gRPC.
+type grpcService struct {
+	apiv1.UnimplementedExporterServiceServer
+
+	snap   *snapshot
+	events *stream
+}
+
+func (s *grpcService) ListDevices(ctx context.Context, req *apiv1.ListDevicesRequest) (*apiv1.ListDevicesResponse, error) {
+	s.snap.mu.RLock()
+	defer s.snap.mu.RUnlock()
+	if s.snap.updatedAt.IsZero() {
+		return nil, status.Error(codes.Unavailable, "no successful update yet")
+	}
+
+	res := &apiv1.ListDevicesResponse{UpdatedAt: timestamppb.New(s.snap.updatedAt)}
+	for _, device := range s.snap.devices {
+		d := &apiv1.Device{
+			Id:              device.ID,
+			Name:            device.Name,
+			FirmwareVersion: device.FirmwareVersion,
+			MacAddress:      device.MacAddress,
+			BtMacAddress:    device.BtMacAddress,
+			SerialNumber:    device.SerialNumber,
+			NewestEvents:    make(map[string]*apiv1.SensorValue, len(device.NewestEvents)),
+		}
+		for sensor, value := range device.NewestEvents {
+			d.NewestEvents[string(sensor)] = &apiv1.SensorValue{
+				Value:     value.Value,
+				CreatedAt: timestamppb.New(value.CreatedAt),
+			}
+		}
+		res.Devices = append(res.Devices, d)
+	}
+	return res, nil
+}
+
+func (s *grpcService) WatchReadings(req *apiv1.WatchReadingsRequest, srv apiv1.ExporterService_WatchReadingsServer) error {
+	ch := s.events.subscribe()
+	defer s.events.unsubscribe(ch)
+
+	for {
+		select {
+		case <-srv.Context().Done():
+			return nil
+		case <-s.events.done:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case r := <-ch:
+			err := srv.Send(&apiv1.Reading{
+				DeviceId:   r.DeviceID,
+				DeviceName: r.DeviceName,
+				Sensor:     string(r.Sensor),
+				Value:      r.Value,
+				CreatedAt:  timestamppb.New(r.CreatedAt),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// grpcAuth requires the bearer tokens and the basic authentication of the web configuration file on the calls,
+// in the authorization metadata as the HTTP endpoints require them in the header.
+type grpcAuth struct {
+	bearer *bearerAuth
+	web    *webConfigLoader
+}
+
+func (a *grpcAuth) authorize(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if a.web != nil {
+		if cfg, _ := a.web.Get(); len(cfg.Users) > 0 && !a.validBasic(cfg, values) {
+			return status.Error(codes.Unauthenticated, "invalid basic authentication")
+		}
+	}
+	if a.bearer != nil && !a.validBearer(values) {
+		return status.Error(codes.Unauthenticated, "invalid bearer token")
+	}
+	return nil
+}
+
+func (a *grpcAuth) validBasic(cfg *web.Config, values []string) bool {
+	for _, v := range values {
+		// parse the credentials in the same way as the HTTP server
+		r := &http.Request{Header: http.Header{"Authorization": {v}}}
+		if user, pass, ok := r.BasicAuth(); ok && a.web.authenticate(cfg, user, pass) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *grpcAuth) validBearer(values []string) bool {
+	for _, v := range values {
+		if token, ok := strings.CutPrefix(v, "Bearer "); ok && a.bearer.valid(token) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServerOptions returns the interceptors to require the authentication.
+func (a *grpcAuth) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := a.authorize(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := a.authorize(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	}
+}
+
+// newGRPCServer creates a gRPC server of the ExporterService.
+// The bearer tokens, the basic authentication of the web configuration file
+// and the TLS configuration of the HTTP server are shared if any.
+func newGRPCServer(snap *snapshot, events *stream, auth *bearerAuth, web *webConfigLoader, tlsConfig *tls.Config) *grpc.Server {
+	var opts []grpc.ServerOption
+	if auth != nil || web != nil {
+		opts = append(opts, (&grpcAuth{bearer: auth, web: web}).ServerOptions()...)
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig.Clone())))
+	}
+	s := grpc.NewServer(opts...)
+	apiv1.RegisterExporterServiceServer(s, &grpcService{snap: snap, events: events})
+	return s
+}
@@ -0,0 +1,412 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imishinist/nature-remo-exporter/pkg/collector"
+	"github.com/tenntenn/natureremo"
+)
+
+var (
+	haLeaseName      string
+	haLeaseNamespace string
+	haLeaseDuration  time.Duration
+	haAdvertiseURL   string
+
+	haBasicAuthUsername     string
+	haBasicAuthPasswordFile string
+	haTLSCert               string
+	haTLSKey                string
+	haTLSCA                 string
+)
+
+// the files of the service account mounted to the pods of Kubernetes
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// leaseTimeFormat is the format of MicroTime of Kubernetes
+	leaseTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// lease is the part of a Lease of coordination.k8s.io/v1 used for the leader election.
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions"`
+}
+
+// leaseElector elects the leader of the replicas by a Lease of Kubernetes.
+// The identity of each replica is the URL which the others fetch the devices from while it is the leader.
+// The expiry of the lease is judged by the time when its renewal is observed, not to depend on the clocks of the replicas.
+type leaseElector struct {
+	client   *http.Client
+	url      string
+	name     string
+	identity string
+	duration time.Duration
+
+	mu        sync.Mutex
+	holder    string
+	renewedAt time.Time
+	// observed is the last lease seen, and observedAt is when it was seen to change
+	observed   leaseSpec
+	observedAt time.Time
+}
+
+// newLeaseElector creates an elector by the service account of the pod.
+func newLeaseElector(namespace, name, identity string, duration time.Duration) (*leaseElector, error) {
+	if duration < 3*time.Second {
+		return nil, fmt.Errorf("--ha.lease-duration must be at least 3s")
+	}
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("the leader election requires running in Kubernetes: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	if namespace == "" {
+		b, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the namespace of the service account: %v", err)
+		}
+		namespace = strings.TrimSpace(string(b))
+	}
+	pool, err := loadCertPool(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	return &leaseElector{
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}},
+			Timeout:   duration / 3,
+		},
+		url:      fmt.Sprintf("https://%s/apis/coordination.k8s.io/v1/namespaces/%s/leases/", net.JoinHostPort(host, port), url.PathEscape(namespace)),
+		name:     name,
+		identity: identity,
+		duration: duration,
+	}, nil
+}
+
+// IsLeader reports whether this replica holds the lease, which must have been renewed within the lease duration.
+func (e *leaseElector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.holder == e.identity && time.Since(e.renewedAt) < e.duration
+}
+
+// Leader returns the identity of the leader, or an empty string if it is unknown.
+func (e *leaseElector) Leader() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.holder
+}
+
+// Run tries to acquire or renew the lease every third of the lease duration until the context is canceled,
+// and releases the lease if it holds it, so that another replica takes over without waiting for the expiry.
+func (e *leaseElector) Run(ctx context.Context, logger *slog.Logger, changed func(leader bool)) {
+	t := time.NewTicker(e.duration / 3)
+	defer t.Stop()
+	leader := false
+	for {
+		if err := e.tryAcquireOrRenew(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn(fmt.Sprintf("failed to acquire or renew lease %s: %v", e.name, err))
+		}
+		if l := e.IsLeader(); l != leader {
+			leader = l
+			changed(leader)
+		}
+		select {
+		case <-ctx.Done():
+			if leader {
+				ctx, cancel := context.WithTimeout(context.Background(), e.duration/3)
+				defer cancel()
+				if err := e.release(ctx); err != nil {
+					logger.Warn(fmt.Sprintf("failed to release lease %s: %v", e.name, err))
+				}
+			}
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (e *leaseElector) tryAcquireOrRenew(ctx context.Context) error {
+	now := time.Now()
+	l, err := e.get(ctx)
+	if err != nil {
+		return err
+	}
+	if l == nil {
+		l = &lease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease", Metadata: leaseMetadata{Name: e.name}}
+		e.take(l, now)
+		return e.save(ctx, http.MethodPost, e.url, l, now)
+	}
+
+	e.mu.Lock()
+	if l.Spec != e.observed {
+		e.observed = l.Spec
+		e.observedAt = now
+	}
+	e.holder = l.Spec.HolderIdentity
+	expired := l.Spec.HolderIdentity == "" || now.After(e.observedAt.Add(time.Duration(l.Spec.LeaseDurationSeconds)*time.Second))
+	e.mu.Unlock()
+
+	switch {
+	case l.Spec.HolderIdentity == e.identity:
+		l.Spec.RenewTime = now.UTC().Format(leaseTimeFormat)
+		l.Spec.LeaseDurationSeconds = int(e.duration.Seconds())
+	case expired:
+		e.take(l, now)
+	default:
+		return nil
+	}
+	return e.save(ctx, http.MethodPut, e.url+url.PathEscape(e.name), l, now)
+}
+
+// take makes the lease held by this replica.
+func (e *leaseElector) take(l *lease, now time.Time) {
+	if l.Spec.HolderIdentity != "" || l.Metadata.ResourceVersion != "" {
+		l.Spec.LeaseTransitions++
+	}
+	l.Spec.HolderIdentity = e.identity
+	l.Spec.LeaseDurationSeconds = int(e.duration.Seconds())
+	l.Spec.AcquireTime = now.UTC().Format(leaseTimeFormat)
+	l.Spec.RenewTime = l.Spec.AcquireTime
+}
+
+// release clears the holder of the lease.
+func (e *leaseElector) release(ctx context.Context) error {
+	l, err := e.get(ctx)
+	if err != nil || l == nil || l.Spec.HolderIdentity != e.identity {
+		return err
+	}
+	l.Spec.HolderIdentity = ""
+	l.Spec.LeaseDurationSeconds = 1
+	return e.save(ctx, http.MethodPut, e.url+url.PathEscape(e.name), l, time.Now())
+}
+
+// get returns the lease, or nil if it does not exist.
+func (e *leaseElector) get(ctx context.Context) (*lease, error) {
+	resp, err := e.do(ctx, http.MethodGet, e.url+url.PathEscape(e.name), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, leaseError(resp)
+	}
+	l := &lease{}
+	if err := json.NewDecoder(resp.Body).Decode(l); err != nil {
+		return nil, fmt.Errorf("failed to parse lease: %v", err)
+	}
+	return l, nil
+}
+
+// save creates or updates the lease held by this replica. A conflict means that another replica has updated it first.
+func (e *leaseElector) save(ctx context.Context, method, u string, l *lease, now time.Time) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	resp, err := e.do(ctx, method, u, b)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusConflict:
+		return nil
+	default:
+		return leaseError(resp)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.holder = l.Spec.HolderIdentity
+	e.observed = l.Spec
+	e.observedAt = now
+	if e.holder == e.identity {
+		e.renewedAt = now
+	}
+	return nil
+}
+
+func (e *leaseElector) do(ctx context.Context, method, u string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	// the token of the service account is rotated, so it is read for each request
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the token of the service account: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	return e.client.Do(req)
+}
+
+func leaseError(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("unexpected response %s: %s", resp.Status, strings.TrimSpace(string(b)))
+}
+
+// defaultAdvertiseURL returns the URL of this replica by the IP address of the pod and the port of the listen address,
+// with https if the web server serves TLS.
+func defaultAdvertiseURL(listenAddress string, useTLS bool) (string, error) {
+	ip := os.Getenv("POD_IP")
+	if ip == "" {
+		return "", fmt.Errorf("--ha.advertise-url or POD_IP is required for the leader election")
+	}
+	_, port, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the port of %s: %v", listenAddress, err)
+	}
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(ip, port), nil
+}
+
+// newHAClient creates the client which the followers call the leader with.
+// It presents the client certificate of --ha.tls-cert and --ha.tls-key, reloaded when modified,
+// and verifies the leader by the CA of --ha.tls-ca or the system roots.
+func newHAClient(timeout time.Duration, logger *slog.Logger) (*http.Client, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if haTLSCert != "" || haTLSKey != "" {
+		if haTLSCert == "" || haTLSKey == "" {
+			return nil, fmt.Errorf("both --ha.tls-cert and --ha.tls-key are required for the client certificate")
+		}
+		reloader, err := newCertReloader(haTLSCert, haTLSKey, logger)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetClientCertificate = reloader.GetClientCertificate
+	}
+	if haTLSCA != "" {
+		pool, err := loadCertPool(haTLSCA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+// haFetcher fetches the devices from the API while this replica is the leader,
+// and from /api/v1/devices of the leader otherwise, so that only the leader calls the API.
+type haFetcher struct {
+	api     collector.DeviceFetcher
+	elector *leaseElector
+	client  *http.Client
+	// token is the bearer token to call the leader with, if the replicas require one.
+	token string
+	// username and password are the basic authentication to call the leader with, if the web config requires one.
+	username string
+	password string
+}
+
+func (f *haFetcher) FetchDevices(ctx context.Context) ([]*natureremo.Device, error) {
+	if f.elector.IsLeader() {
+		return f.api.FetchDevices(ctx)
+	}
+	res, err := f.fetchLeader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return res.Devices, nil
+}
+
+func (f *haFetcher) FetchAppliances(ctx context.Context) ([]*natureremo.Appliance, error) {
+	if f.elector.IsLeader() {
+		return f.api.FetchAppliances(ctx)
+	}
+	res, err := f.fetchLeader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return res.Appliances, nil
+}
+
+type leaderDevices struct {
+	Devices    []*natureremo.Device    `json:"devices"`
+	Appliances []*natureremo.Appliance `json:"appliances"`
+}
+
+func (f *haFetcher) fetchLeader(ctx context.Context) (*leaderDevices, error) {
+	leader := f.elector.Leader()
+	if leader == "" {
+		return nil, fmt.Errorf("no leader elected yet")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(leader, "/")+"/api/v1/devices", nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case f.username != "":
+		req.SetBasicAuth(f.username, f.password)
+	case f.token != "":
+		req.Header.Set("Authorization", "Bearer "+f.token)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch devices from leader %s: %v", leader, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch devices from leader %s: %s", leader, resp.Status)
+	}
+	res := &leaderDevices{}
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return nil, fmt.Errorf("failed to parse devices of leader %s: %v", leader, err)
+	}
+	return res, nil
+}
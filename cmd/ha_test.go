@@ -0,0 +1,140 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tenntenn/natureremo"
+)
+
+func TestDefaultAdvertiseURL(t *testing.T) {
+	t.Setenv("POD_IP", "10.0.0.1")
+	for _, tt := range []struct {
+		useTLS bool
+		want   string
+	}{
+		{false, "http://10.0.0.1:9199"},
+		{true, "https://10.0.0.1:9199"},
+	} {
+		got, err := defaultAdvertiseURL(":9199", tt.useTLS)
+		if err != nil {
+			t.Fatalf("defaultAdvertiseURL() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("defaultAdvertiseURL(%v) = %s, want %s", tt.useTLS, got, tt.want)
+		}
+	}
+}
+
+// writeClientCert writes a self-signed client certificate and its key, and returns their paths.
+func writeClientCert(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "follower"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestHAFetcherFetchesFromLeader(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "follower" {
+			http.Error(w, "no client certificate", http.StatusForbidden)
+			return
+		}
+		if user, password, ok := r.BasicAuth(); !ok || user != "replica" || password != "secret" {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(leaderDevices{Devices: []*natureremo.Device{{DeviceCore: natureremo.DeviceCore{ID: "d1"}}}})
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := writeClientCert(t, dir)
+	defer func(cert, key, ca string) { haTLSCert, haTLSKey, haTLSCA = cert, key, ca }(haTLSCert, haTLSKey, haTLSCA)
+	haTLSCert, haTLSKey, haTLSCA = certFile, keyFile, caFile
+
+	client, err := newHAClient(10*time.Second, slog.Default())
+	if err != nil {
+		t.Fatalf("newHAClient() error = %v", err)
+	}
+	f := &haFetcher{
+		elector:  &leaseElector{identity: "https://follower", holder: srv.URL},
+		client:   client,
+		token:    "ignored",
+		username: "replica",
+		password: "secret",
+	}
+	devices, err := f.FetchDevices(context.Background())
+	if err != nil {
+		t.Fatalf("FetchDevices() error = %v", err)
+	}
+	if len(devices) != 1 || devices[0].ID != "d1" {
+		t.Errorf("FetchDevices() = %v, want d1", devices)
+	}
+
+	f.password = "wrong"
+	if _, err := f.FetchDevices(context.Background()); err == nil {
+		t.Error("FetchDevices() succeeded with a wrong password")
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// health tracks the state of the polling loop for the health endpoints.
+type health struct {
+	mu          sync.Mutex
+	running     bool
+	heartbeat   time.Time
+	lastSuccess time.Time
+	// lastError is the error of the last poll if it failed.
+	lastError error
+}
+
+// Beat records that the polling loop is alive.
+func (h *health) Beat() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running = true
+	h.heartbeat = time.Now()
+}
+
+// Stop records that the polling loop has exited.
+func (h *health) Stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running = false
+}
+
+// Success records a successful update.
+func (h *health) Success() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastSuccess = time.Now()
+	h.lastError = nil
+}
+
+// Failure records a failed update.
+func (h *health) Failure(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastError = err
+}
+
+// alive reports whether the polling loop is running and has beaten within maxSilence.
+func (h *health) alive(maxSilence time.Duration) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.running {
+		return fmt.Errorf("polling loop is not running")
+	}
+	if since := time.Since(h.heartbeat); since > maxSilence {
+		return fmt.Errorf("polling loop has been stuck for %s", since.Round(time.Second))
+	}
+	return nil
+}
+
+// livenessMaxSilence returns how long the polling loop may be silent before it is considered stuck,
+// which is a few ticks of the longest period between the polls, including the one stretched by --interval-adaptive.
+func livenessMaxSilence(interval time.Duration, adaptive bool) time.Duration {
+	period := interval
+	if adaptive {
+		period = max(interval, maxAdaptiveInterval)
+	}
+	return 3*period + time.Minute
+}
+
+// LivenessHandler returns 200 as long as the polling loop is alive, otherwise 503.
+func (h *health) LivenessHandler(maxSilence time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := h.alive(maxSilence); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// ready reports whether the last successful update is within maxAge.
+func (h *health) ready(maxAge time.Duration) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.lastSuccess.IsZero() {
+		if h.lastError != nil {
+			return fmt.Errorf("no successful update yet: %v", h.lastError)
+		}
+		return fmt.Errorf("no successful update yet")
+	}
+	if since := time.Since(h.lastSuccess); since > maxAge {
+		return fmt.Errorf("last successful update was %s ago", since.Round(time.Second))
+	}
+	return nil
+}
+
+// ReadinessHandler returns 200 if the metrics are fresh, otherwise 503.
+func (h *health) ReadinessHandler(maxAge time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := h.ready(maxAge); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
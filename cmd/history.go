@@ -0,0 +1,177 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+var (
+	historyPath      string
+	historyRetention time.Duration
+)
+
+// historySchema creates the table of the readings.
+// A reading is stored once even though it is seen in the polls until the next event of the sensor.
+const historySchema = `
+CREATE TABLE IF NOT EXISTS readings (
+	device_id   TEXT    NOT NULL,
+	device_name TEXT    NOT NULL,
+	metric      TEXT    NOT NULL,
+	value       REAL    NOT NULL,
+	timestamp   INTEGER NOT NULL,
+	PRIMARY KEY (device_id, metric, timestamp)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS readings_timestamp ON readings (timestamp);
+`
+
+// history stores the readings in a SQLite database, and serves them by the time range.
+type history struct {
+	db        *sql.DB
+	retention time.Duration
+}
+
+// openHistory opens the database of the path, creating it if it does not exist.
+// The readings older than the retention are deleted on each write, or kept forever if it is 0.
+func openHistory(path string, retention time.Duration) (*history, error) {
+	// the driver is written in pure Go, so that the history works in the binaries built without cgo
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history database: %v", err)
+	}
+	// SQLite allows only one writer, so the connection is shared to avoid the lock errors
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open history database: %v", err)
+	}
+	return &history{db: db, retention: retention}, nil
+}
+
+func (h *history) Name() string {
+	return "history"
+}
+
+func (h *history) Write(ctx context.Context, samples []sample) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT OR IGNORE INTO readings (device_id, device_name, metric, value, timestamp) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, sample := range samples {
+		if _, err := stmt.ExecContext(ctx, sample.DeviceID, sample.DeviceName, sample.Metric, sample.Value, sample.Timestamp.Unix()); err != nil {
+			return err
+		}
+	}
+	if h.retention > 0 {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM readings WHERE timestamp < ?", time.Now().Add(-h.retention).Unix()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Query returns the readings between from and to in the order of the time.
+// The device is matched with the ID or the name, and all devices are returned if it is empty.
+func (h *history) Query(ctx context.Context, device, metric string, from, to time.Time) ([]sample, error) {
+	rows, err := h.db.QueryContext(ctx, `
+SELECT device_id, device_name, metric, value, timestamp FROM readings
+WHERE timestamp >= ? AND timestamp <= ?
+  AND (? = '' OR device_id = ? OR device_name = ?)
+  AND (? = '' OR metric = ?)
+ORDER BY timestamp, device_id, metric`,
+		from.Unix(), to.Unix(), device, device, device, metric, metric)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []sample{}
+	for rows.Next() {
+		var s sample
+		var timestamp int64
+		if err := rows.Scan(&s.DeviceID, &s.DeviceName, &s.Metric, &s.Value, &timestamp); err != nil {
+			return nil, err
+		}
+		s.Timestamp = time.Unix(timestamp, 0).UTC()
+		samples = append(samples, s)
+	}
+	return samples, rows.Err()
+}
+
+// Handler serves the readings selected by the device, metric, from and to parameters as JSON.
+// The time range is the last 24 hours by default.
+func (h *history) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		q := r.URL.Query()
+		to, err := parseHistoryTime(q.Get("to"), time.Now())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid to parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		from, err := parseHistoryTime(q.Get("from"), to.Add(-24*time.Hour))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid from parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		readings, err := h.Query(r.Context(), q.Get("device"), q.Get("metric"), from, to)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to query history: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Readings []sample `json:"readings"`
+		}{readings})
+	})
+}
+
+func (h *history) Close() error {
+	return h.db.Close()
+}
+
+// parseHistoryTime parses the time in RFC 3339 or in Unix time, or returns def if it is empty.
+func parseHistoryTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,98 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Phases of an API request which are exported as the phase label of nature_remo_api_request_phase_duration_seconds.
+// dns, connect and tls are observed only when a new connection is opened.
+const (
+	phaseDNS     = "dns"
+	phaseConnect = "connect"
+	phaseTLS     = "tls"
+	phaseTTFB    = "ttfb"
+)
+
+// requestPhases holds the durations of the phases of a request measured with net/http/httptrace.
+// It is safe for concurrent use, since the dials may report after the request is done with another connection.
+type requestPhases struct {
+	mu        sync.Mutex
+	start     time.Time
+	started   map[string]time.Time
+	durations map[string]time.Duration
+}
+
+// withRequestPhases returns a context which measures the phases of the request made with it.
+func withRequestPhases(ctx context.Context) (context.Context, *requestPhases) {
+	p := &requestPhases{
+		start:     time.Now(),
+		started:   make(map[string]time.Time),
+		durations: make(map[string]time.Duration),
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { p.begin(phaseDNS) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { p.end(phaseDNS) },
+		ConnectStart:         func(string, string) { p.begin(phaseConnect) },
+		ConnectDone:          func(string, string, error) { p.end(phaseConnect) },
+		TLSHandshakeStart:    func() { p.begin(phaseTLS) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { p.end(phaseTLS) },
+		GotFirstResponseByte: func() { p.since(phaseTTFB, p.start) },
+	}), p
+}
+
+// begin records the start of the phase. The first start is kept if the phase is started several times,
+// e.g. when the addresses are dialed in parallel.
+func (p *requestPhases) begin(phase string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.started[phase]; !ok {
+		p.started[phase] = time.Now()
+	}
+}
+
+// end records the duration of the phase since it began.
+func (p *requestPhases) end(phase string) {
+	p.mu.Lock()
+	start, ok := p.started[phase]
+	p.mu.Unlock()
+	if ok {
+		p.since(phase, start)
+	}
+}
+
+func (p *requestPhases) since(phase string, start time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.durations[phase] = time.Since(start)
+}
+
+// Durations returns a copy of the durations of the phases observed so far.
+func (p *requestPhases) Durations() map[string]time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	d := make(map[string]time.Duration, len(p.durations))
+	for phase, v := range p.durations {
+		d[phase] = v
+	}
+	return d
+}
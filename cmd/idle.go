@@ -0,0 +1,67 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	idleAfter    time.Duration
+	idleInterval time.Duration
+)
+
+// scrapeTracker records the time of the last scrape, so that the polls can be slowed down while nobody scrapes.
+type scrapeTracker struct {
+	after time.Duration
+	// last is the time of the last scrape in Unix nanoseconds. It is initialized with the start time.
+	last atomic.Int64
+	wake chan struct{}
+}
+
+func newScrapeTracker(after time.Duration) *scrapeTracker {
+	t := &scrapeTracker{after: after, wake: make(chan struct{}, 1)}
+	t.last.Store(time.Now().UnixNano())
+	return t
+}
+
+// Idle reports whether nobody has scraped for the period.
+func (t *scrapeTracker) Idle(now time.Time) bool {
+	return now.Sub(time.Unix(0, t.last.Load())) >= t.after
+}
+
+// Wake returns a channel which receives a value when the exporter is scraped while idle.
+func (t *scrapeTracker) Wake() <-chan struct{} {
+	return t.wake
+}
+
+// Handler records the scrapes of the handler.
+func (t *scrapeTracker) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		if t.Idle(now) {
+			select {
+			case t.wake <- struct{}{}:
+			default:
+			}
+		}
+		t.last.Store(now.UnixNano())
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,125 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// influxDBTokenEnv is the environment variable to set the InfluxDB API token instead of the flag.
+const influxDBTokenEnv = "INFLUXDB_TOKEN"
+
+var (
+	influxDBURL         string
+	influxDBOrg         string
+	influxDBBucket      string
+	influxDBToken       string
+	influxDBMeasurement string
+)
+
+// influxDBSink writes the samples to the write API of InfluxDB v2 in the line protocol.
+type influxDBSink struct {
+	client      *http.Client
+	writeURL    string
+	token       string
+	measurement string
+}
+
+func newInfluxDBSink(rawURL, org, bucket, token, measurement string) (*influxDBSink, error) {
+	if org == "" || bucket == "" {
+		return nil, fmt.Errorf("--influxdb.org and --influxdb.bucket are required with --influxdb.url")
+	}
+	if token == "" {
+		token = os.Getenv(influxDBTokenEnv)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid InfluxDB URL: %v", err)
+	}
+	u = u.JoinPath("api", "v2", "write")
+	u.RawQuery = url.Values{"org": {org}, "bucket": {bucket}, "precision": {"s"}}.Encode()
+	return &influxDBSink{
+		client:      &http.Client{},
+		writeURL:    u.String(),
+		token:       token,
+		measurement: measurement,
+	}, nil
+}
+
+func (s *influxDBSink) Name() string {
+	return "influxdb"
+}
+
+func (s *influxDBSink) Write(ctx context.Context, samples []sample) error {
+	var body bytes.Buffer
+	for _, sample := range samples {
+		fmt.Fprintf(&body, "%s,device_id=%s,device_name=%s %s=%s %d\n",
+			influxEscape(s.measurement, ", "),
+			influxEscape(sample.DeviceID, ",= "),
+			influxEscape(sample.DeviceName, ",= "),
+			influxEscape(sample.Metric, ",= "),
+			strconv.FormatFloat(sample.Value, 'f', -1, 64),
+			sample.Timestamp.Unix(),
+		)
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.writeURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("InfluxDB returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// influxEscape escapes the characters of the line protocol with backslashes.
+// Empty tag values are not allowed, so they are replaced with a placeholder.
+func influxEscape(s, chars string) string {
+	if s == "" {
+		return "unknown"
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(chars, r) || r == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,108 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+)
+
+// kafkaSASLPasswordEnv is the environment variable to set the SASL password of Kafka instead of the flag.
+const kafkaSASLPasswordEnv = "KAFKA_SASL_PASSWORD"
+
+var (
+	kafkaBrokers      []string
+	kafkaTopic        string
+	kafkaClientID     string
+	kafkaTLS          bool
+	kafkaSASLUsername string
+	kafkaSASLPassword string
+)
+
+// kafkaSink produces each sample to a topic of Kafka as a JSON message.
+// The messages are keyed by the device ID, so that the readings of a device are kept in order in a partition.
+// The client retries the produce requests rejected by the brokers which are no longer the leaders,
+// and compresses the batches with snappy.
+type kafkaSink struct {
+	client *kgo.Client
+}
+
+func newKafkaSink(brokers []string, topic, clientID string, useTLS bool, username, password string) (*kafkaSink, error) {
+	if topic == "" {
+		return nil, fmt.Errorf("--kafka.topic is required with --kafka.brokers")
+	}
+	if username != "" && password == "" {
+		password = os.Getenv(kafkaSASLPasswordEnv)
+	}
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(brokers...),
+		kgo.ClientID(clientID),
+		kgo.DefaultProduceTopic(topic),
+		// follow auto.create.topics.enable of the brokers
+		kgo.AllowAutoTopicCreation(),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+		kgo.ProducerBatchCompression(kgo.SnappyCompression(), kgo.NoCompression()),
+	}
+	if useTLS {
+		// the server name is set to the host of each broker
+		opts = append(opts, kgo.DialTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}))
+	}
+	if username != "" {
+		if !useTLS {
+			return nil, fmt.Errorf("--kafka.sasl-username requires --kafka.tls, since SASL/PLAIN sends the password in plaintext")
+		}
+		opts = append(opts, kgo.SASL(plain.Auth{User: username, Pass: password}.AsMechanism()))
+	}
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka client: %v", err)
+	}
+	return &kafkaSink{client: client}, nil
+}
+
+func (s *kafkaSink) Name() string {
+	return "kafka"
+}
+
+func (s *kafkaSink) Write(ctx context.Context, samples []sample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	records := make([]*kgo.Record, 0, len(samples))
+	for _, sample := range samples {
+		value, err := json.Marshal(sample)
+		if err != nil {
+			return err
+		}
+		records = append(records, &kgo.Record{Key: []byte(sample.DeviceID), Value: value, Timestamp: sample.Timestamp})
+	}
+	if err := s.client.ProduceSync(ctx, records...).FirstErr(); err != nil {
+		return fmt.Errorf("failed to produce to Kafka: %v", err)
+	}
+	return nil
+}
+
+func (s *kafkaSink) Close() error {
+	s.client.Close()
+	return nil
+}
@@ -0,0 +1,195 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+const kafkaTestTopic = "nature-remo"
+
+func newKafkaCluster(t *testing.T) *kfake.Cluster {
+	t.Helper()
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(3), kfake.SeedTopics(3, kafkaTestTopic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cluster.Close)
+	return cluster
+}
+
+// consumeKafka reads n records of the test topic from the beginning.
+func consumeKafka(t *testing.T, brokers []string, n int) []*kgo.Record {
+	t.Helper()
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(brokers...),
+		kgo.ConsumeTopics(kafkaTestTopic),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var records []*kgo.Record
+	for len(records) < n {
+		fetches := client.PollFetches(ctx)
+		if err := ctx.Err(); err != nil {
+			t.Fatalf("got %d records, want %d: %v", len(records), n, err)
+		}
+		fetches.EachRecord(func(r *kgo.Record) {
+			records = append(records, r)
+		})
+	}
+	return records
+}
+
+func kafkaTestSamples() []sample {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []sample{
+		{Metric: "temperature", DeviceID: "device-1", DeviceName: "living", Value: 21.5, Timestamp: now},
+		{Metric: "humidity", DeviceID: "device-1", DeviceName: "living", Value: 40, Timestamp: now},
+		{Metric: "temperature", DeviceID: "device-2", DeviceName: "bedroom", Value: 19, Timestamp: now.Add(-time.Minute)},
+	}
+}
+
+func TestKafkaSinkWrite(t *testing.T) {
+	cluster := newKafkaCluster(t)
+	brokers := cluster.ListenAddrs()
+
+	s, err := newKafkaSink(brokers, kafkaTestTopic, "nature-remo-exporter", false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	samples := kafkaTestSamples()
+	if err := s.Write(context.Background(), samples); err != nil {
+		t.Fatal(err)
+	}
+
+	records := consumeKafka(t, brokers, len(samples))
+	partitions := make(map[string]int32)
+	got := make(map[string]sample)
+	for _, r := range records {
+		var value sample
+		if err := json.Unmarshal(r.Value, &value); err != nil {
+			t.Fatal(err)
+		}
+		if string(r.Key) != value.DeviceID {
+			t.Errorf("key = %q, want %q", r.Key, value.DeviceID)
+		}
+		if !r.Timestamp.Equal(value.Timestamp) {
+			t.Errorf("timestamp = %v, want %v", r.Timestamp, value.Timestamp)
+		}
+		if p, ok := partitions[value.DeviceID]; ok && p != r.Partition {
+			t.Errorf("records of %s are in partitions %d and %d", value.DeviceID, p, r.Partition)
+		}
+		partitions[value.DeviceID] = r.Partition
+		got[value.DeviceID+"/"+value.Metric] = value
+	}
+	for _, want := range samples {
+		if g := got[want.DeviceID+"/"+want.Metric]; g.Value != want.Value || g.DeviceName != want.DeviceName {
+			t.Errorf("record of %s/%s = %+v, want %+v", want.DeviceID, want.Metric, g, want)
+		}
+	}
+}
+
+func TestKafkaSinkRetryNotLeader(t *testing.T) {
+	cluster := newKafkaCluster(t)
+	brokers := cluster.ListenAddrs()
+
+	// reject the first produce request as if the leaders had moved
+	var rejected atomic.Int32
+	cluster.ControlKey(int16(kmsg.Produce), func(req kmsg.Request) (kmsg.Response, error, bool) {
+		produce := req.(*kmsg.ProduceRequest)
+		resp := produce.ResponseKind().(*kmsg.ProduceResponse)
+		resp.Version = produce.Version
+		for _, topic := range produce.Topics {
+			rt := kmsg.NewProduceResponseTopic()
+			rt.Topic = topic.Topic
+			for _, partition := range topic.Partitions {
+				rp := kmsg.NewProduceResponseTopicPartition()
+				rp.Partition = partition.Partition
+				rp.ErrorCode = kerr.NotLeaderForPartition.Code
+				rt.Partitions = append(rt.Partitions, rp)
+			}
+			resp.Topics = append(resp.Topics, rt)
+		}
+		rejected.Add(1)
+		return resp, nil, true
+	})
+
+	s, err := newKafkaSink(brokers, kafkaTestTopic, "nature-remo-exporter", false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	samples := kafkaTestSamples()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.Write(ctx, samples); err != nil {
+		t.Fatal(err)
+	}
+	if rejected.Load() != 1 {
+		t.Fatalf("rejected %d produce requests, want 1", rejected.Load())
+	}
+	if records := consumeKafka(t, brokers, len(samples)); len(records) != len(samples) {
+		t.Errorf("got %d records, want %d", len(records), len(samples))
+	}
+}
+
+func TestNewKafkaSink(t *testing.T) {
+	tests := []struct {
+		name     string
+		topic    string
+		useTLS   bool
+		username string
+		wantErr  string
+	}{
+		{name: "plaintext", topic: kafkaTestTopic},
+		{name: "sasl over tls", topic: kafkaTestTopic, useTLS: true, username: "user"},
+		{name: "no topic", wantErr: "--kafka.topic"},
+		{name: "sasl without tls", topic: kafkaTestTopic, username: "user", wantErr: "requires --kafka.tls"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newKafkaSink([]string{"localhost:9092"}, tt.topic, "nature-remo-exporter", tt.useTLS, tt.username, "password")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Close()
+		})
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	localProbeInterval time.Duration
+	localProbeTimeout  time.Duration
+)
+
+// localProber probes the reachability of the devices on the LAN by connecting to their HTTP port,
+// so that the Wi-Fi dropouts of the devices can be told from the problems of the Cloud API.
+type localProber struct {
+	mu sync.Mutex
+	// addrs is the address of each device by its ID or name in the devices config file,
+	// or by its instance name discovered by mDNS.
+	addrs     map[string]string
+	timeout   time.Duration
+	reachable *prometheus.GaugeVec
+	logger    *slog.Logger
+
+	// last is the last result of each device, to log only the changes.
+	last map[string]bool
+}
+
+func newLocalProber(addrs map[string]string, timeout time.Duration, reachable *prometheus.GaugeVec, logger *slog.Logger) *localProber {
+	return &localProber{
+		addrs:     addrs,
+		timeout:   timeout,
+		reachable: reachable,
+		logger:    logger,
+		last:      make(map[string]bool),
+	}
+}
+
+// Run probes the devices at the interval until the context is canceled.
+func (p *localProber) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		p.ProbeAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// SetAddresses replaces the addresses of the devices, which are probed from the next round.
+func (p *localProber) SetAddresses(addrs map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.addrs = addrs
+}
+
+// ProbeAll probes all devices concurrently and records the results.
+func (p *localProber) ProbeAll(ctx context.Context) {
+	type result struct {
+		device string
+		err    error
+	}
+	p.mu.Lock()
+	addrs := p.addrs
+	p.mu.Unlock()
+
+	results := make(chan result, len(addrs))
+	var wg sync.WaitGroup
+	for device, addr := range addrs {
+		wg.Add(1)
+		go func(device, addr string) {
+			defer wg.Done()
+			results <- result{device, p.probe(ctx, addr)}
+		}(device, addr)
+	}
+	wg.Wait()
+	close(results)
+
+	for r := range results {
+		v := 0.0
+		if r.err == nil {
+			v = 1
+		}
+		p.reachable.WithLabelValues(r.device).Set(v)
+
+		last, seen := p.last[r.device]
+		switch {
+		case r.err != nil && (!seen || last):
+			p.logger.Warn(fmt.Sprintf("device %q is not reachable on the LAN: %v", r.device, r.err))
+		case r.err == nil && seen && !last:
+			p.logger.Info(fmt.Sprintf("device %q is reachable on the LAN again", r.device))
+		}
+		p.last[r.device] = r.err == nil
+	}
+}
+
+// probe connects to the address, which is on port 80 of the local API unless the port is given.
+func (p *localProber) probe(ctx context.Context, addr string) error {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "80")
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
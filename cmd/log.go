@@ -0,0 +1,126 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	logLevel   string
+	logFormat  string
+	logBackend string
+
+	logFile           string
+	logFileMaxSize    int64
+	logFileMaxAge     time.Duration
+	logFileMaxBackups int
+)
+
+// newLogger creates a logger configured by the log flags.
+func newLogger() (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %v", logLevel, err)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	newHandler := func(w io.Writer) (slog.Handler, error) {
+		switch logFormat {
+		case "json":
+			return slog.NewJSONHandler(w, opts), nil
+		case "text":
+			return slog.NewTextHandler(w, opts), nil
+		default:
+			return nil, fmt.Errorf("invalid log format %q: must be json or text", logFormat)
+		}
+	}
+
+	switch logBackend {
+	case "stdout":
+		var w io.Writer = os.Stdout
+		if logFile != "" {
+			f, err := openRotatingFile(logFile, logFileMaxSize*1024*1024, logFileMaxAge, logFileMaxBackups)
+			if err != nil {
+				return nil, err
+			}
+			w = f
+		}
+		h, err := newHandler(w)
+		if err != nil {
+			return nil, err
+		}
+		return slog.New(h), nil
+	case "syslog", "journald", "eventlog":
+		out, err := newPriorityWriter(logBackend)
+		if err != nil {
+			return nil, err
+		}
+		buf := new(bytes.Buffer)
+		h, err := newHandler(buf)
+		if err != nil {
+			return nil, err
+		}
+		return slog.New(&priorityHandler{inner: h, mu: new(sync.Mutex), buf: buf, out: out}), nil
+	default:
+		return nil, fmt.Errorf("invalid log backend %q: must be stdout, syslog, journald or eventlog", logBackend)
+	}
+}
+
+// priorityWriter writes a formatted log record with the priority corresponding to the level.
+type priorityWriter func(level slog.Level, msg []byte) error
+
+// priorityHandler is a slog.Handler which formats records with the inner handler
+// and passes them to a priorityWriter one by one, so that the backend can know the level of each record.
+type priorityHandler struct {
+	inner slog.Handler
+
+	// mu guards buf, which is shared with the handlers derived by WithAttrs and WithGroup.
+	mu  *sync.Mutex
+	buf *bytes.Buffer
+	out priorityWriter
+}
+
+func (h *priorityHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *priorityHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.buf.Reset()
+	if err := h.inner.Handle(ctx, r); err != nil {
+		return err
+	}
+	return h.out(r.Level, bytes.TrimRight(h.buf.Bytes(), "\n"))
+}
+
+func (h *priorityHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &priorityHandler{inner: h.inner.WithAttrs(attrs), mu: h.mu, buf: h.buf, out: h.out}
+}
+
+func (h *priorityHandler) WithGroup(name string) slog.Handler {
+	return &priorityHandler{inner: h.inner.WithGroup(name), mu: h.mu, buf: h.buf, out: h.out}
+}
@@ -0,0 +1,105 @@
+//go:build !windows
+
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// journaldSocket is the socket of the native protocol of systemd-journald.
+const journaldSocket = "/run/systemd/journal/socket"
+
+func newPriorityWriter(backend string) (priorityWriter, error) {
+	tag := filepath.Base(os.Args[0])
+
+	switch backend {
+	case "syslog":
+		w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to syslog: %v", err)
+		}
+		return func(level slog.Level, msg []byte) error {
+			switch {
+			case level >= slog.LevelError:
+				return w.Err(string(msg))
+			case level >= slog.LevelWarn:
+				return w.Warning(string(msg))
+			case level >= slog.LevelInfo:
+				return w.Info(string(msg))
+			default:
+				return w.Debug(string(msg))
+			}
+		}, nil
+	case "journald":
+		conn, err := net.Dial("unixgram", journaldSocket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to journald: %v", err)
+		}
+		return func(level slog.Level, msg []byte) error {
+			var b bytes.Buffer
+			b.WriteString("PRIORITY=" + strconv.Itoa(journaldPriority(level)) + "\n")
+			b.WriteString("SYSLOG_IDENTIFIER=" + tag + "\n")
+			writeJournaldField(&b, "MESSAGE", msg)
+			_, err := conn.Write(b.Bytes())
+			return err
+		}, nil
+	default:
+		return nil, fmt.Errorf("log backend %q is only supported on Windows", backend)
+	}
+}
+
+// journaldPriority converts the level to the syslog priority used by journald.
+func journaldPriority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// writeJournaldField writes a field in the native protocol of journald.
+// A value containing newlines is written in the binary form with its length.
+func writeJournaldField(b *bytes.Buffer, key string, value []byte) {
+	if !bytes.ContainsRune(value, '\n') {
+		b.WriteString(key + "=")
+		b.Write(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteString(key + "\n")
+	var size [8]byte
+	for i := range size {
+		size[i] = byte(uint64(len(value)) >> (8 * i))
+	}
+	b.Write(size[:])
+	b.Write(value)
+	b.WriteByte('\n')
+}
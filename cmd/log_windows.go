@@ -0,0 +1,51 @@
+//go:build windows
+
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// eventID is the event ID of the logs written to the event log.
+const eventID = 1
+
+func newPriorityWriter(backend string) (priorityWriter, error) {
+	switch backend {
+	case "eventlog":
+		l, err := eventlog.Open(serviceName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open event log: %v", err)
+		}
+		return func(level slog.Level, msg []byte) error {
+			switch {
+			case level >= slog.LevelError:
+				return l.Error(eventID, string(msg))
+			case level >= slog.LevelWarn:
+				return l.Warning(eventID, string(msg))
+			default:
+				return l.Info(eventID, string(msg))
+			}
+		}, nil
+	default:
+		return nil, fmt.Errorf("log backend %q is not supported on Windows", backend)
+	}
+}
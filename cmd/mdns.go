@@ -0,0 +1,205 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var (
+	localMDNS         bool
+	localMDNSInterval time.Duration
+)
+
+// remoService is the DNS-SD service type which Remo advertises its local API with.
+const remoService = "_remo._tcp.local."
+
+// mdnsBrowseTimeout is how long the answers of a query are collected,
+// as the responders delay their answers up to 500ms (RFC 6762 section 6).
+const mdnsBrowseTimeout = 2 * time.Second
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// mdnsDiscovery keeps the addresses of the prober up to date with the devices discovered by mDNS,
+// as the addresses leased by DHCP can change.
+type mdnsDiscovery struct {
+	// static are the addresses in the devices config file, which take precedence over the discovered ones.
+	static map[string]string
+	prober *localProber
+	browse func(ctx context.Context) (map[string]string, error)
+	logger *slog.Logger
+
+	// found is the last discovered address of each instance, which is kept while the instance does not answer
+	// since mDNS is lossy, and the probes tell whether it is still reachable.
+	found map[string]string
+}
+
+func newMDNSDiscovery(static map[string]string, prober *localProber, logger *slog.Logger) *mdnsDiscovery {
+	return &mdnsDiscovery{
+		static: static,
+		prober: prober,
+		browse: browseRemo,
+		logger: logger,
+		found:  make(map[string]string),
+	}
+}
+
+// Run refreshes the addresses at the interval until the context is canceled.
+func (d *mdnsDiscovery) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		d.Refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Refresh browses the devices and passes their addresses to the prober.
+func (d *mdnsDiscovery) Refresh(ctx context.Context) {
+	found, err := d.browse(ctx)
+	if err != nil {
+		d.logger.Warn(fmt.Sprintf("failed to discover devices by mDNS: %v", err))
+		return
+	}
+	for name, addr := range found {
+		last, seen := d.found[name]
+		switch {
+		case !seen:
+			d.logger.Info(fmt.Sprintf("discovered device %q at %s", name, addr))
+		case last != addr:
+			d.logger.Info(fmt.Sprintf("address of device %q changed from %s to %s", name, last, addr))
+		}
+		d.found[name] = addr
+	}
+
+	addrs := maps.Clone(d.found)
+	maps.Copy(addrs, d.static)
+	d.prober.SetAddresses(addrs)
+}
+
+// browseRemo discovers the devices on the LAN.
+func browseRemo(ctx context.Context) (map[string]string, error) {
+	// a query from a port other than 5353 is answered with unicast (RFC 6762 section 5.1),
+	// so the multicast group does not need to be joined.
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return browseMDNS(ctx, conn, mdnsGroup, remoService, mdnsBrowseTimeout)
+}
+
+// browseMDNS queries the instances of the service, and collects the answers until the timeout.
+// It returns the address of each instance by its name, which is the host of its SRV record
+// if no A record of the host is answered.
+func browseMDNS(ctx context.Context, conn net.PacketConn, dst net.Addr, service string, timeout time.Duration) (map[string]string, error) {
+	query, err := mdnsQuery(service)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteTo(query, dst); err != nil {
+		return nil, fmt.Errorf("failed to send mDNS query: %v", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	var (
+		instances []string
+		srvs      = make(map[string]dnsmessage.SRVResource)
+		ips       = make(map[string]net.IP)
+	)
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mDNS answer: %v", err)
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil || !msg.Response {
+			// not an answer, ignored as others on the LAN may send anything
+			continue
+		}
+		for _, rr := range append(msg.Answers, msg.Additionals...) {
+			name := strings.ToLower(rr.Header.Name.String())
+			switch body := rr.Body.(type) {
+			case *dnsmessage.PTRResource:
+				if name == service {
+					instances = append(instances, body.PTR.String())
+				}
+			case *dnsmessage.SRVResource:
+				srvs[name] = *body
+			case *dnsmessage.AResource:
+				ips[name] = net.IP(body.A[:])
+			}
+		}
+	}
+
+	addrs := make(map[string]string)
+	for _, instance := range instances {
+		srv, ok := srvs[strings.ToLower(instance)]
+		if !ok {
+			continue
+		}
+		host := strings.ToLower(srv.Target.String())
+		port := strconv.Itoa(int(srv.Port))
+		name := strings.TrimSuffix(instance, "."+service)
+		if ip, ok := ips[host]; ok {
+			addrs[name] = net.JoinHostPort(ip.String(), port)
+		} else {
+			addrs[name] = net.JoinHostPort(strings.TrimSuffix(host, "."), port)
+		}
+	}
+	return addrs, nil
+}
+
+// mdnsQuery builds a query of the PTR records of the service.
+func mdnsQuery(service string) ([]byte, error) {
+	name, err := dnsmessage.NewName(service)
+	if err != nil {
+		return nil, err
+	}
+	msg := dnsmessage.Message{
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET},
+		},
+	}
+	return msg.Pack()
+}
@@ -0,0 +1,186 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func mustName(t *testing.T, s string) dnsmessage.Name {
+	t.Helper()
+	name, err := dnsmessage.NewName(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// serveMDNS answers a query of the service with each of the messages, as the devices answer separately.
+func serveMDNS(t *testing.T, service string, answers ...dnsmessage.Message) net.Addr {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 9000)
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var query dnsmessage.Message
+		if err := query.Unpack(buf[:n]); err != nil {
+			t.Errorf("failed to unpack query: %v", err)
+			return
+		}
+		if len(query.Questions) != 1 || query.Questions[0].Name.String() != service || query.Questions[0].Type != dnsmessage.TypePTR {
+			t.Errorf("query = %+v, want PTR of %s", query.Questions, service)
+			return
+		}
+		// noise of another service on the LAN
+		conn.WriteTo([]byte("not a DNS message"), from)
+		for _, answer := range answers {
+			answer.Header.Response = true
+			b, err := answer.Pack()
+			if err != nil {
+				t.Errorf("failed to pack answer: %v", err)
+				return
+			}
+			conn.WriteTo(b, from)
+		}
+	}()
+	return conn.LocalAddr()
+}
+
+func TestBrowseMDNS(t *testing.T) {
+	header := func(name string, typ dnsmessage.Type) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: mustName(t, name), Type: typ, Class: dnsmessage.ClassINET, TTL: 120}
+	}
+	ptr := func(instance string) dnsmessage.Resource {
+		return dnsmessage.Resource{Header: header(remoService, dnsmessage.TypePTR), Body: &dnsmessage.PTRResource{PTR: mustName(t, instance)}}
+	}
+	srv := func(instance, target string, port uint16) dnsmessage.Resource {
+		return dnsmessage.Resource{Header: header(instance, dnsmessage.TypeSRV), Body: &dnsmessage.SRVResource{Target: mustName(t, target), Port: port}}
+	}
+	a := func(host string, ip [4]byte) dnsmessage.Resource {
+		return dnsmessage.Resource{Header: header(host, dnsmessage.TypeA), Body: &dnsmessage.AResource{A: ip}}
+	}
+
+	addr := serveMDNS(t, remoService,
+		dnsmessage.Message{
+			Answers: []dnsmessage.Resource{ptr("Remo-1A2B3C." + remoService)},
+			Additionals: []dnsmessage.Resource{
+				srv("Remo-1A2B3C."+remoService, "Remo-1A2B3C.local.", 80),
+				a("Remo-1A2B3C.local.", [4]byte{192, 168, 1, 20}),
+			},
+		},
+		// without the A record of the host
+		dnsmessage.Message{
+			Answers: []dnsmessage.Resource{
+				ptr("Remo-4D5E6F." + remoService),
+				srv("Remo-4D5E6F."+remoService, "remo-bedroom.local.", 8080),
+			},
+		},
+		// without the SRV record of the instance
+		dnsmessage.Message{
+			Answers: []dnsmessage.Resource{ptr("Remo-000000." + remoService)},
+		},
+		// another service
+		dnsmessage.Message{
+			Answers: []dnsmessage.Resource{
+				{Header: header("_http._tcp.local.", dnsmessage.TypePTR), Body: &dnsmessage.PTRResource{PTR: mustName(t, "printer._http._tcp.local.")}},
+				srv("printer._http._tcp.local.", "printer.local.", 80),
+			},
+		},
+	)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	got, err := browseMDNS(context.Background(), conn, addr, remoService, 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"Remo-1A2B3C": "192.168.1.20:80",
+		"Remo-4D5E6F": "remo-bedroom.local:8080",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("browseMDNS() = %v, want %v", got, want)
+	}
+}
+
+func TestMDNSDiscoveryRefresh(t *testing.T) {
+	reachable := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "reachable"}, []string{"device"})
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	static := map[string]string{"Living": "192.168.1.10", "Remo-1A2B3C": "remo-living.local"}
+	prober := newLocalProber(static, time.Second, reachable, logger)
+	d := newMDNSDiscovery(static, prober, logger)
+
+	tests := []struct {
+		name  string
+		found map[string]string
+		want  map[string]string
+	}{
+		{
+			name:  "discovered",
+			found: map[string]string{"Remo-1A2B3C": "192.168.1.20:80", "Remo-4D5E6F": "192.168.1.21:80"},
+			want:  map[string]string{"Living": "192.168.1.10", "Remo-1A2B3C": "remo-living.local", "Remo-4D5E6F": "192.168.1.21:80"},
+		},
+		{
+			name:  "lease changed",
+			found: map[string]string{"Remo-4D5E6F": "192.168.1.30:80"},
+			want:  map[string]string{"Living": "192.168.1.10", "Remo-1A2B3C": "remo-living.local", "Remo-4D5E6F": "192.168.1.30:80"},
+		},
+		{
+			name:  "not answered",
+			found: map[string]string{},
+			want:  map[string]string{"Living": "192.168.1.10", "Remo-1A2B3C": "remo-living.local", "Remo-4D5E6F": "192.168.1.30:80"},
+		},
+		{
+			name: "failed",
+			want: map[string]string{"Living": "192.168.1.10", "Remo-1A2B3C": "remo-living.local", "Remo-4D5E6F": "192.168.1.30:80"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d.browse = func(context.Context) (map[string]string, error) {
+				if tt.found == nil {
+					return nil, io.ErrUnexpectedEOF
+				}
+				return tt.found, nil
+			}
+			d.Refresh(context.Background())
+			if !maps.Equal(prober.addrs, tt.want) {
+				t.Errorf("addresses = %v, want %v", prober.addrs, tt.want)
+			}
+		})
+	}
+}
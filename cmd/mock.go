@@ -0,0 +1,197 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/tenntenn/natureremo"
+)
+
+// mockMode serves synthetic devices instead of calling the Nature Remo API.
+var mockMode bool
+
+// mockDevice is a synthetic device with the sensors and the climate of its room.
+type mockDevice struct {
+	core    natureremo.DeviceCore
+	sensors []natureremo.SensorType
+	// temperature is the daily mean, and swing is the amplitude of the daily curve peaking at 15:00.
+	temperature float64
+	swing       float64
+	humidity    float64
+	// movementRate is the probability of a movement per minute in the daytime.
+	movementRate float64
+}
+
+// mockFetcher is a collector.DeviceFetcher which returns synthetic but realistic devices:
+// the temperature and the humidity follow the daily curves, the illumination follows the sun,
+// and the movements are random and frequent in the daytime.
+type mockFetcher struct {
+	now     func() time.Time
+	devices []mockDevice
+	// meter is Nature Remo E, which only appears as the appliance of the smart meter.
+	meter natureremo.DeviceCore
+
+	mu        sync.Mutex
+	rand      *rand.Rand
+	fetchedAt time.Time
+	movements map[string]time.Time
+}
+
+func newMockFetcher(now func() time.Time, r *rand.Rand) *mockFetcher {
+	all := []natureremo.SensorType{natureremo.SensorTypeTemperature, natureremo.SensorTypeHumidity, natureremo.SensorTypeIllumination, natureremo.SensorTypeMovement}
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	core := func(id, name, firmware, mac string) natureremo.DeviceCore {
+		return natureremo.DeviceCore{
+			ID:              id,
+			Name:            name,
+			CreatedAt:       created,
+			UpdatedAt:       created,
+			FirmwareVersion: firmware,
+			MacAddress:      mac,
+			BtMacAddress:    mac,
+			SerialNumber:    "MOCK" + id[len(id)-4:],
+		}
+	}
+	return &mockFetcher{
+		now: now,
+		devices: []mockDevice{
+			{
+				core:         core("00000000-0000-0000-0000-000000000001", "Living", "Remo/1.14.6", "02:00:00:00:00:01"),
+				sensors:      all,
+				temperature:  23,
+				swing:        2,
+				humidity:     50,
+				movementRate: 0.3,
+			},
+			{
+				core:         core("00000000-0000-0000-0000-000000000002", "Bedroom", "Remo/1.14.6", "02:00:00:00:00:02"),
+				sensors:      all,
+				temperature:  20,
+				swing:        1.5,
+				humidity:     55,
+				movementRate: 0.05,
+			},
+			{
+				core:        core("00000000-0000-0000-0000-000000000003", "Entrance", "Remo-mini/1.14.6", "02:00:00:00:00:03"),
+				sensors:     []natureremo.SensorType{natureremo.SensorTypeTemperature},
+				temperature: 17,
+				swing:       4,
+			},
+		},
+		meter:     core("00000000-0000-0000-0000-000000000004", "Remo E", "Remo-E-lite/1.10.0", "02:00:00:00:00:04"),
+		rand:      r,
+		movements: make(map[string]time.Time),
+	}
+}
+
+func (f *mockFetcher) FetchDevices(ctx context.Context) ([]*natureremo.Device, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now()
+	// the hours of the day in the local time, which the curves are based on
+	hour := float64(now.Hour()) + float64(now.Minute())/60
+	daily := math.Sin(2 * math.Pi * (hour - 9) / 24)
+	daylight := max(0, math.Sin(2*math.Pi*(hour-6)/24))
+	minutes := 1.0
+	if !f.fetchedAt.IsZero() {
+		minutes = now.Sub(f.fetchedAt).Minutes()
+	}
+	f.fetchedAt = now
+
+	var devices []*natureremo.Device
+	for _, d := range f.devices {
+		if _, ok := f.movements[d.core.ID]; !ok {
+			f.movements[d.core.ID] = now.Add(-time.Duration(f.rand.Intn(3600)) * time.Second)
+		}
+		// the movements are less frequent at night
+		if rate := d.movementRate * (0.2 + 0.8*daylight); f.rand.Float64() < 1-math.Pow(1-rate, minutes) {
+			f.movements[d.core.ID] = now
+		}
+
+		values := map[natureremo.SensorType]natureremo.SensorValue{
+			natureremo.SensorTypeTemperature:  {Value: round(d.temperature+d.swing*daily+f.rand.NormFloat64()*0.1, 1), CreatedAt: now},
+			natureremo.SensorTypeHumidity:     {Value: math.Round(d.humidity - 8*daily + f.rand.NormFloat64()), CreatedAt: now},
+			natureremo.SensorTypeIllumination: {Value: math.Round(5 + 195*daylight + f.rand.Float64()*5), CreatedAt: now},
+			natureremo.SensorTypeMovement:     {Value: 1, CreatedAt: f.movements[d.core.ID]},
+		}
+		device := &natureremo.Device{DeviceCore: d.core, NewestEvents: make(map[natureremo.SensorType]natureremo.SensorValue)}
+		for _, sensor := range d.sensors {
+			device.NewestEvents[sensor] = values[sensor]
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+func (f *mockFetcher) FetchAppliances(ctx context.Context) ([]*natureremo.Appliance, error) {
+	recordSmartMeters(ctx, f.smartMeterSamples())
+	living := &f.devices[0].core
+	return []*natureremo.Appliance{
+		{
+			ID:       "00000000-0000-0000-0000-000000000101",
+			Device:   living,
+			Model:    &natureremo.ApplianceModel{ID: "mock-ac", Manufacturer: "mock", Name: "Air conditioner"},
+			Type:     natureremo.ApplianceTypeAirCon,
+			Nickname: "Air conditioner",
+			Image:    "ico_ac_1",
+		},
+		{
+			ID:       "00000000-0000-0000-0000-000000000102",
+			Device:   living,
+			Model:    &natureremo.ApplianceModel{ID: "mock-light", Manufacturer: "mock", Name: "Ceiling light"},
+			Type:     natureremo.ApplianceTypeLight,
+			Nickname: "Ceiling light",
+			Image:    "ico_light",
+		},
+		{
+			ID:       "00000000-0000-0000-0000-000000000103",
+			Device:   &f.meter,
+			Model:    &natureremo.ApplianceModel{ID: "mock-smart-meter", Manufacturer: "mock", Name: "Smart meter"},
+			Type:     natureremo.ApplianceType("EL_SMART_METER"),
+			Nickname: "Smart meter",
+			Image:    "ico_smartmeter",
+		},
+	}, nil
+}
+
+// smartMeterSamples returns the instantaneous power of the smart meter, which is higher in the daytime.
+func (f *mockFetcher) smartMeterSamples() []sample {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.now()
+	hour := float64(now.Hour()) + float64(now.Minute())/60
+	daily := math.Sin(2 * math.Pi * (hour - 9) / 24)
+	return []sample{{
+		Metric:     "power",
+		DeviceID:   f.meter.ID,
+		DeviceName: f.meter.Name,
+		Value:      math.Round(300 + 400*max(0, daily) + f.rand.NormFloat64()*20),
+		Timestamp:  now,
+	}}
+}
+
+// round rounds x to the digits after the decimal point.
+func round(x float64, digits int) float64 {
+	p := math.Pow(10, float64(digits))
+	return math.Round(x*p) / p
+}
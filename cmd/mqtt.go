@@ -0,0 +1,225 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttPasswordEnv is the environment variable to set the password of the MQTT broker instead of the flag.
+const mqttPasswordEnv = "MQTT_PASSWORD"
+
+var (
+	mqttBroker        string
+	mqttClientID      string
+	mqttUsername      string
+	mqttPassword      string
+	mqttTopicTemplate string
+	mqttQoS           int
+	mqttRetain        bool
+
+	mqttHomeAssistantDiscovery bool
+	mqttHomeAssistantPrefix    string
+)
+
+// mqttTopicReplacer replaces the characters which break the topic levels.
+var mqttTopicReplacer = strings.NewReplacer("/", "_", "+", "_", "#", "_")
+
+// mqttSink publishes each sample to a topic of the MQTT broker.
+type mqttSink struct {
+	client mqtt.Client
+	topic  *template.Template
+	qos    byte
+	retain bool
+
+	// discovery is the topic prefix of the Home Assistant MQTT discovery, or empty if disabled.
+	discovery string
+	mu        sync.Mutex
+	// announced holds the unique IDs of the entities whose discovery messages have been published.
+	announced map[string]bool
+}
+
+func newMQTTSink(broker, clientID, username, password, topicTemplate string, qos int, retain bool, discovery string) (*mqttSink, error) {
+	if qos < 0 || qos > 2 {
+		return nil, fmt.Errorf("invalid MQTT QoS %d: must be 0, 1 or 2", qos)
+	}
+	t, err := template.New("topic").Option("missingkey=error").Parse(topicTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MQTT topic template: %v", err)
+	}
+	if password == "" {
+		password = os.Getenv(mqttPasswordEnv)
+	}
+	s := &mqttSink{
+		topic:     t,
+		qos:       byte(qos),
+		retain:    retain,
+		discovery: discovery,
+		announced: make(map[string]bool),
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(username).
+		SetPassword(password).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(s.onConnect)
+	s.client = mqtt.NewClient(opts)
+	return s, nil
+}
+
+// onConnect subscribes to the status of Home Assistant to announce the entities again when it restarts.
+func (s *mqttSink) onConnect(client mqtt.Client) {
+	if s.discovery == "" {
+		return
+	}
+	s.resetAnnounced()
+	client.Subscribe(s.discovery+"/status", 0, func(client mqtt.Client, msg mqtt.Message) {
+		if string(msg.Payload()) == "online" {
+			s.resetAnnounced()
+		}
+	})
+}
+
+func (s *mqttSink) resetAnnounced() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.announced)
+}
+
+func (s *mqttSink) Name() string {
+	return "mqtt"
+}
+
+func (s *mqttSink) Write(ctx context.Context, samples []sample) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(pushTimeout)
+	}
+	// the client reconnects by itself once connected, so only the first connection is made here
+	if !s.client.IsConnectionOpen() {
+		if err := waitToken(s.client.Connect(), deadline); err != nil {
+			return fmt.Errorf("failed to connect to MQTT broker: %v", err)
+		}
+	}
+
+	var tokens []mqtt.Token
+	for _, sample := range samples {
+		sanitized := sample
+		sanitized.Metric = mqttTopicReplacer.Replace(sample.Metric)
+		sanitized.DeviceID = mqttTopicReplacer.Replace(sample.DeviceID)
+		sanitized.DeviceName = mqttTopicReplacer.Replace(sample.DeviceName)
+		var topic bytes.Buffer
+		if err := s.topic.Execute(&topic, sanitized); err != nil {
+			return fmt.Errorf("failed to execute MQTT topic template: %v", err)
+		}
+		if token := s.announce(sample, topic.String()); token != nil {
+			tokens = append(tokens, token)
+		}
+		payload := strconv.FormatFloat(sample.Value, 'f', -1, 64)
+		tokens = append(tokens, s.client.Publish(topic.String(), s.qos, s.retain, payload))
+	}
+	for _, token := range tokens {
+		if err := waitToken(token, deadline); err != nil {
+			return fmt.Errorf("failed to publish to MQTT broker: %v", err)
+		}
+	}
+	return nil
+}
+
+// homeAssistantSensors are the properties of the sensor entities of Home Assistant per metric.
+var homeAssistantSensors = map[string]struct {
+	Name        string
+	DeviceClass string
+	Unit        string
+}{
+	"temperature":  {"Temperature", "temperature", "°C"},
+	"humidity":     {"Humidity", "humidity", "%"},
+	"illumination": {"Illumination", "", ""},
+	"movement":     {"Movement", "", ""},
+	"power":        {"Power", "power", "W"},
+}
+
+// announce publishes the discovery message of the entity of the sample for Home Assistant, if not yet.
+// It returns nil if the discovery is disabled or the entity has been announced.
+func (s *mqttSink) announce(sample sample, stateTopic string) mqtt.Token {
+	if s.discovery == "" {
+		return nil
+	}
+	uniqueID := mqttDiscoveryIDReplacer.Replace("nature_remo_" + sample.DeviceID + "_" + sample.Metric)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.announced[uniqueID] {
+		return nil
+	}
+	s.announced[uniqueID] = true
+
+	props := homeAssistantSensors[sample.Metric]
+	model := "Nature Remo"
+	if sample.Metric == "power" {
+		model = "Nature Remo E"
+	}
+	config := map[string]any{
+		"name":        props.Name,
+		"unique_id":   uniqueID,
+		"state_topic": stateTopic,
+		"state_class": "measurement",
+		"device": map[string]any{
+			"identifiers":  []string{mqttDiscoveryIDReplacer.Replace("nature_remo_" + sample.DeviceID)},
+			"name":         sample.DeviceName,
+			"manufacturer": "Nature",
+			"model":        model,
+		},
+	}
+	if props.DeviceClass != "" {
+		config["device_class"] = props.DeviceClass
+	}
+	if props.Unit != "" {
+		config["unit_of_measurement"] = props.Unit
+	}
+	payload, _ := json.Marshal(config)
+	// the discovery messages are retained so that Home Assistant finds them whenever it starts
+	return s.client.Publish(s.discovery+"/sensor/"+uniqueID+"/config", 1, true, payload)
+}
+
+// mqttDiscoveryIDReplacer replaces the characters not allowed in the IDs of the Home Assistant MQTT discovery.
+var mqttDiscoveryIDReplacer = strings.NewReplacer("/", "_", "+", "_", "#", "_", " ", "_", "-", "_", ".", "_")
+
+// Close disconnects from the broker after sending the queued messages.
+func (s *mqttSink) Close() error {
+	s.client.Disconnect(250)
+	return nil
+}
+
+// waitToken waits for the token to complete until the deadline.
+func waitToken(token mqtt.Token, deadline time.Time) error {
+	if !token.WaitTimeout(time.Until(deadline)) {
+		return fmt.Errorf("timed out")
+	}
+	return token.Error()
+}
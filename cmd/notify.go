@@ -0,0 +1,582 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var notifyConfigFile string
+
+// notifyConfig is the configuration of the notifications.
+// The receivers are configured in the same manner as Alertmanager.
+type notifyConfig struct {
+	Receivers     []notifyReceiver     `yaml:"receivers"`
+	Rules         []*notifyRule        `yaml:"rules"`
+	DeviceOffline *deviceOfflineConfig `yaml:"device_offline"`
+	TokenInvalid  *tokenInvalidConfig  `yaml:"token_invalid"`
+}
+
+type notifyReceiver struct {
+	Name           string          `yaml:"name"`
+	WebhookConfigs []webhookConfig `yaml:"webhook_configs"`
+	SlackConfigs   []slackConfig   `yaml:"slack_configs"`
+	DiscordConfigs []discordConfig `yaml:"discord_configs"`
+	LINEConfigs    []lineConfig    `yaml:"line_configs"`
+}
+
+// deviceOfflineConfig notifies when a device has not reported any new events for After.
+type deviceOfflineConfig struct {
+	After     time.Duration `yaml:"after"`
+	Receivers []string      `yaml:"receivers"`
+}
+
+// tokenInvalidConfig notifies when the Nature Remo API rejects the access token.
+type tokenInvalidConfig struct {
+	Receivers []string `yaml:"receivers"`
+}
+
+type webhookConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// notifyRule is a condition of the readings to notify.
+// A rule without a condition notifies each new event of the metric, e.g. each movement.
+type notifyRule struct {
+	Name      string `yaml:"name"`
+	Device    string `yaml:"device"`
+	Metric    string `yaml:"metric"`
+	Condition string `yaml:"condition"`
+	// For is how long the condition must keep true before notifying.
+	For time.Duration `yaml:"for"`
+	// Between restricts the rule to a time of day in the local time zone, e.g. 00:00-06:00.
+	Between   string   `yaml:"between"`
+	Receivers []string `yaml:"receivers"`
+
+	op        string
+	threshold float64
+	window    *timeWindow
+}
+
+// Names of the built-in rules.
+const (
+	deviceOfflineRule = "device_offline"
+	tokenInvalidRule  = "token_invalid"
+)
+
+// notification is the payload sent to the receivers.
+// The fields of the device and the reading are omitted if the notification is not about a reading.
+type notification struct {
+	Rule string `json:"rule"`
+	// Status is firing or resolved.
+	Status     string    `json:"status"`
+	Message    string    `json:"message"`
+	DeviceID   string    `json:"device_id,omitempty"`
+	DeviceName string    `json:"device_name,omitempty"`
+	Metric     string    `json:"metric,omitempty"`
+	Value      *float64  `json:"value,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// notifier sends notifications to a receiver.
+type notifier interface {
+	Notify(ctx context.Context, n notification) error
+}
+
+// loadNotifyConfig loads and validates the notifications configuration file.
+func loadNotifyConfig(path string) (*notifyConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notify config file: %v", err)
+	}
+	cfg := &notifyConfig{}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse notify config file: %v", err)
+	}
+
+	receivers := make(map[string]bool)
+	for _, r := range cfg.Receivers {
+		if r.Name == "" {
+			return nil, fmt.Errorf("receiver without a name in notify config file")
+		}
+		if receivers[r.Name] {
+			return nil, fmt.Errorf("duplicate receiver %q in notify config file", r.Name)
+		}
+		receivers[r.Name] = true
+		for _, c := range r.WebhookConfigs {
+			if c.URL == "" {
+				return nil, fmt.Errorf("webhook of receiver %q has no url", r.Name)
+			}
+		}
+		for _, c := range r.SlackConfigs {
+			if c.APIURL == "" {
+				return nil, fmt.Errorf("slack config of receiver %q has no api_url", r.Name)
+			}
+		}
+		for _, c := range r.DiscordConfigs {
+			if c.WebhookURL == "" {
+				return nil, fmt.Errorf("discord config of receiver %q has no webhook_url", r.Name)
+			}
+		}
+		for _, c := range r.LINEConfigs {
+			if c.ChannelAccessToken == "" || c.To == "" {
+				return nil, fmt.Errorf("line config of receiver %q requires channel_access_token and to", r.Name)
+			}
+		}
+	}
+	checkReceivers := func(rule string, names []string) error {
+		for _, name := range names {
+			if !receivers[name] {
+				return fmt.Errorf("invalid rule %q: unknown receiver %q", rule, name)
+			}
+		}
+		return nil
+	}
+	if len(receivers) == 0 {
+		return nil, fmt.Errorf("no receivers in notify config file")
+	}
+	rules := map[string]bool{deviceOfflineRule: true, tokenInvalidRule: true}
+	for _, rule := range cfg.Rules {
+		if rules[rule.Name] {
+			return nil, fmt.Errorf("duplicate or reserved rule %q in notify config file", rule.Name)
+		}
+		rules[rule.Name] = true
+		if err := rule.parse(); err != nil {
+			return nil, fmt.Errorf("invalid rule %q: %v", rule.Name, err)
+		}
+		if err := checkReceivers(rule.Name, rule.Receivers); err != nil {
+			return nil, err
+		}
+	}
+	if c := cfg.DeviceOffline; c != nil {
+		if c.After <= 0 {
+			return nil, fmt.Errorf("invalid rule %q: after must be positive", deviceOfflineRule)
+		}
+		if err := checkReceivers(deviceOfflineRule, c.Receivers); err != nil {
+			return nil, err
+		}
+	}
+	if c := cfg.TokenInvalid; c != nil {
+		if err := checkReceivers(tokenInvalidRule, c.Receivers); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// parse validates the rule and parses its condition and time window.
+func (r *notifyRule) parse() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if _, ok := sensorTypes[r.Metric]; !ok {
+		return fmt.Errorf("metric must be one of %s", strings.Join(sensorNames(), ", "))
+	}
+	if r.Condition != "" {
+		op, threshold, err := parseCondition(r.Condition)
+		if err != nil {
+			return err
+		}
+		r.op, r.threshold = op, threshold
+	} else if r.For != 0 {
+		return fmt.Errorf("for requires a condition")
+	}
+	if r.Between != "" {
+		w, err := parseTimeWindow(r.Between)
+		if err != nil {
+			return err
+		}
+		r.window = w
+	}
+	return nil
+}
+
+// parseCondition parses a comparison with a threshold, such as "> 30".
+func parseCondition(s string) (string, float64, error) {
+	s = strings.TrimSpace(s)
+	for _, op := range []string{">=", "<=", "==", "!=", ">", "<"} {
+		if !strings.HasPrefix(s, op) {
+			continue
+		}
+		threshold, err := strconv.ParseFloat(strings.TrimSpace(s[len(op):]), 64)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid threshold in condition %q: %v", s, err)
+		}
+		return op, threshold, nil
+	}
+	return "", 0, fmt.Errorf("condition %q must start with one of >, >=, <, <=, == and !=", s)
+}
+
+func (r *notifyRule) compare(v float64) bool {
+	switch r.op {
+	case ">":
+		return v > r.threshold
+	case ">=":
+		return v >= r.threshold
+	case "<":
+		return v < r.threshold
+	case "<=":
+		return v <= r.threshold
+	case "==":
+		return v == r.threshold
+	default:
+		return v != r.threshold
+	}
+}
+
+func (r *notifyRule) matches(s sample) bool {
+	return s.Metric == r.Metric && (r.Device == "" || r.Device == s.DeviceID || r.Device == s.DeviceName)
+}
+
+// timeWindow is a range of the time of day in minutes.
+// It wraps around midnight if the start is after the end.
+type timeWindow struct {
+	start, end int
+}
+
+// parseTimeWindow parses a range such as 22:00-06:00.
+func parseTimeWindow(s string) (*timeWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("between %q must be in the format of HH:MM-HH:MM", s)
+	}
+	var minutes [2]int
+	for i, v := range []string{from, to} {
+		t, err := time.Parse("15:04", strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("between %q must be in the format of HH:MM-HH:MM", s)
+		}
+		minutes[i] = t.Hour()*60 + t.Minute()
+	}
+	return &timeWindow{start: minutes[0], end: minutes[1]}, nil
+}
+
+// contains reports whether the time is in the window. A nil window contains any time.
+func (w *timeWindow) contains(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	t = t.Local()
+	m := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return w.start <= m && m < w.end
+	}
+	return m >= w.start || m < w.end
+}
+
+// alertState is the state of a rule for a device.
+type alertState struct {
+	// since is when the condition became true, or zero if it is false.
+	since  time.Time
+	firing bool
+	// last is the time of the last event notified by a rule without a condition.
+	last time.Time
+}
+
+type notifyKey struct {
+	rule   string
+	device string
+}
+
+// notifySink evaluates the rules on the readings of each poll and notifies the receivers.
+// It also notifies the failures of the polls by the built-in rules.
+type notifySink struct {
+	rules         []*notifyRule
+	deviceOffline *deviceOfflineConfig
+	tokenInvalid  *tokenInvalidConfig
+
+	receivers map[string][]notifier
+	// order is the names of the receivers in the order of the file, which a rule without receivers notifies.
+	order  []string
+	states map[notifyKey]*alertState
+	now    func() time.Time
+}
+
+// newNotifySink creates a sink with the rules in the configuration file.
+func newNotifySink(path string) (*notifySink, error) {
+	cfg, err := loadNotifyConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &notifySink{
+		rules:         cfg.Rules,
+		deviceOffline: cfg.DeviceOffline,
+		tokenInvalid:  cfg.TokenInvalid,
+		receivers:     make(map[string][]notifier),
+		states:        make(map[notifyKey]*alertState),
+		now:           time.Now,
+	}
+	client := &http.Client{}
+	for _, r := range cfg.Receivers {
+		s.order = append(s.order, r.Name)
+		var notifiers []notifier
+		for _, c := range r.WebhookConfigs {
+			notifiers = append(notifiers, &webhookNotifier{client: client, url: c.URL, headers: c.Headers})
+		}
+		for _, c := range r.SlackConfigs {
+			notifiers = append(notifiers, &slackNotifier{client: client, config: c})
+		}
+		for _, c := range r.DiscordConfigs {
+			notifiers = append(notifiers, &discordNotifier{client: client, config: c})
+		}
+		for _, c := range r.LINEConfigs {
+			notifiers = append(notifiers, &lineNotifier{client: client, url: lineAPIURL, config: c})
+		}
+		s.receivers[r.Name] = notifiers
+	}
+	return s, nil
+}
+
+func (s *notifySink) Name() string {
+	return "notify"
+}
+
+func (s *notifySink) Write(ctx context.Context, samples []sample) error {
+	now := s.now()
+	var errs []error
+	for _, rule := range s.rules {
+		for _, sample := range samples {
+			if !rule.matches(sample) {
+				continue
+			}
+			key := notifyKey{rule: rule.Name, device: sample.DeviceID}
+			state, ok := s.states[key]
+			if !ok {
+				state = &alertState{}
+				s.states[key] = state
+			}
+
+			var n *notification
+			if rule.op == "" {
+				// the first event is the one before the start, which has been seen already
+				if !ok || sample.Timestamp.Equal(state.last) {
+					state.last = sample.Timestamp
+					continue
+				}
+				state.last = sample.Timestamp
+				if rule.window.contains(sample.Timestamp) {
+					n = rule.notification("firing", sample)
+				}
+			} else if !rule.compare(sample.Value) || !rule.window.contains(now) {
+				state.since = time.Time{}
+				if state.firing {
+					state.firing = false
+					n = rule.notification("resolved", sample)
+				}
+			} else if !state.firing {
+				if state.since.IsZero() {
+					state.since = now
+				}
+				if now.Sub(state.since) >= rule.For {
+					state.firing = true
+					n = rule.notification("firing", sample)
+				}
+			}
+			if n != nil {
+				errs = append(errs, s.notify(ctx, rule.Receivers, *n))
+			}
+		}
+	}
+	if s.deviceOffline != nil {
+		errs = append(errs, s.checkOffline(ctx, samples, now))
+	}
+	// the poll has succeeded, so the token is valid
+	if state := s.states[notifyKey{rule: tokenInvalidRule}]; state != nil && state.firing {
+		state.firing = false
+		errs = append(errs, s.notify(ctx, s.tokenInvalid.Receivers, notification{
+			Rule:      tokenInvalidRule,
+			Status:    "resolved",
+			Message:   "the access token of the Nature Remo API is accepted again",
+			Timestamp: now,
+		}))
+	}
+	return errors.Join(errs...)
+}
+
+// checkOffline notifies the devices whose newest events are older than the threshold.
+func (s *notifySink) checkOffline(ctx context.Context, samples []sample, now time.Time) error {
+	newest := make(map[string]sample)
+	var ids []string
+	for _, sample := range samples {
+		n, ok := newest[sample.DeviceID]
+		if !ok {
+			ids = append(ids, sample.DeviceID)
+		}
+		if !ok || sample.Timestamp.After(n.Timestamp) {
+			newest[sample.DeviceID] = sample
+		}
+	}
+
+	var errs []error
+	for _, id := range ids {
+		sample := newest[id]
+		key := notifyKey{rule: deviceOfflineRule, device: id}
+		state, ok := s.states[key]
+		if !ok {
+			state = &alertState{}
+			s.states[key] = state
+		}
+		n := notification{
+			Rule:       deviceOfflineRule,
+			DeviceID:   sample.DeviceID,
+			DeviceName: sample.DeviceName,
+			Timestamp:  sample.Timestamp,
+		}
+		silence := now.Sub(sample.Timestamp)
+		switch {
+		case silence >= s.deviceOffline.After && !state.firing:
+			state.firing = true
+			n.Status = "firing"
+			n.Message = fmt.Sprintf("%s has not reported any events for %s", sample.DeviceName, silence.Truncate(time.Minute))
+		case silence < s.deviceOffline.After && state.firing:
+			state.firing = false
+			n.Status = "resolved"
+			n.Message = fmt.Sprintf("%s is reporting events again", sample.DeviceName)
+		default:
+			continue
+		}
+		errs = append(errs, s.notify(ctx, s.deviceOffline.Receivers, n))
+	}
+	return errors.Join(errs...)
+}
+
+// Failure notifies the failure of a poll if the Nature Remo API has rejected the access token.
+// The errors of the receivers are logged.
+func (s *notifySink) Failure(ctx context.Context, logger *slog.Logger, err error) {
+	var apiErr *apiError
+	if s.tokenInvalid == nil || !errors.As(err, &apiErr) || apiErr.Class != apiErrorAuth {
+		return
+	}
+	key := notifyKey{rule: tokenInvalidRule}
+	state, ok := s.states[key]
+	if !ok {
+		state = &alertState{}
+		s.states[key] = state
+	}
+	if state.firing {
+		return
+	}
+	state.firing = true
+
+	ctx, cancel := context.WithTimeout(ctx, pushTimeout)
+	defer cancel()
+	if err := s.notify(ctx, s.tokenInvalid.Receivers, notification{
+		Rule:      tokenInvalidRule,
+		Status:    "firing",
+		Message:   fmt.Sprintf("the access token of the Nature Remo API is rejected: %v", err),
+		Timestamp: s.now(),
+	}); err != nil {
+		logger.Error(err.Error(), slog.String("sink", s.Name()))
+	}
+}
+
+// notify sends the notification to the receivers, or all receivers if none is given.
+func (s *notifySink) notify(ctx context.Context, receivers []string, n notification) error {
+	if len(receivers) == 0 {
+		receivers = s.order
+	}
+	var errs []error
+	for _, name := range receivers {
+		for _, r := range s.receivers[name] {
+			if err := r.Notify(ctx, n); err != nil {
+				errs = append(errs, fmt.Errorf("failed to notify %s of %s: %v", name, n.Rule, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *notifyRule) notification(status string, s sample) *notification {
+	value := strconv.FormatFloat(s.Value, 'f', -1, 64)
+	var msg string
+	switch {
+	case r.op == "" && s.Metric == "movement":
+		msg = fmt.Sprintf("movement detected by %s", s.DeviceName)
+	case r.op == "":
+		msg = fmt.Sprintf("new %s of %s: %s", s.Metric, s.DeviceName, value)
+	case status == "resolved":
+		msg = fmt.Sprintf("%s of %s is back to %s", s.Metric, s.DeviceName, value)
+	default:
+		msg = fmt.Sprintf("%s of %s is %s (%s %s)", s.Metric, s.DeviceName, value, r.op, strconv.FormatFloat(r.threshold, 'f', -1, 64))
+	}
+	return &notification{
+		Rule:       r.Name,
+		Status:     status,
+		Message:    msg,
+		DeviceID:   s.DeviceID,
+		DeviceName: s.DeviceName,
+		Metric:     s.Metric,
+		Value:      &s.Value,
+		Timestamp:  s.Timestamp,
+	}
+}
+
+// webhookNotifier posts the notification as JSON to a URL.
+type webhookNotifier struct {
+	client  *http.Client
+	url     string
+	headers map[string]string
+}
+
+func (w *webhookNotifier) Notify(ctx context.Context, n notification) error {
+	return postJSON(ctx, w.client, w.url, w.headers, n)
+}
+
+// postJSON posts the value as JSON, and fails unless the response is successful.
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, v any) error {
+	// the messages contain the operators of the conditions, which should not be escaped
+	body := new(bytes.Buffer)
+	enc := json.NewEncoder(body)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s returned %s: %s", req.URL.Host, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
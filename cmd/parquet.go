@@ -0,0 +1,260 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// the values of the enums of the Parquet format
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired = 0
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetPlain = 0
+	parquetRLE   = 3
+
+	parquetGzip = 2
+
+	parquetDataPage = 0
+)
+
+// parquetColumn is a column of the readings in a Parquet file.
+type parquetColumn struct {
+	name string
+	// typ is the physical type, and converted is the converted type or -1 if none.
+	typ       int32
+	converted int32
+	// encode appends the value of the sample in the plain encoding.
+	encode func(b []byte, s sample) []byte
+}
+
+var parquetColumns = []parquetColumn{
+	{"timestamp", parquetInt64, parquetTimestampMillis, func(b []byte, s sample) []byte {
+		return binary.LittleEndian.AppendUint64(b, uint64(s.Timestamp.UnixMilli()))
+	}},
+	{"device_id", parquetByteArray, parquetUTF8, func(b []byte, s sample) []byte {
+		return appendParquetString(b, s.DeviceID)
+	}},
+	{"device_name", parquetByteArray, parquetUTF8, func(b []byte, s sample) []byte {
+		return appendParquetString(b, s.DeviceName)
+	}},
+	{"metric", parquetByteArray, parquetUTF8, func(b []byte, s sample) []byte {
+		return appendParquetString(b, s.Metric)
+	}},
+	{"value", parquetDouble, -1, func(b []byte, s sample) []byte {
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(s.Value))
+	}},
+}
+
+func appendParquetString(b []byte, s string) []byte {
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// writeParquet writes the samples as a Parquet file of a row group,
+// with a gzip-compressed data page of the plain encoding per column.
+func writeParquet(w io.Writer, samples []sample) error {
+	var file bytes.Buffer
+	file.WriteString("PAR1")
+
+	// ColumnChunk of each column
+	var chunks [][]byte
+	var size int64
+	for _, column := range parquetColumns {
+		var values []byte
+		for _, s := range samples {
+			values = column.encode(values, s)
+		}
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		zw.Write(values)
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		var header thriftWriter
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(values)))
+		header.i32(3, int32(compressed.Len()))
+		header.beginStruct(5)
+		header.i32(1, int32(len(samples)))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.end()
+		header.end()
+
+		offset := int64(file.Len())
+		file.Write(header.Bytes())
+		file.Write(compressed.Bytes())
+
+		var chunk thriftWriter
+		chunk.i64(2, offset)
+		chunk.beginStruct(3)
+		chunk.i32(1, column.typ)
+		chunk.list(2, thriftI32, 1)
+		chunk.varint(parquetPlain)
+		chunk.list(3, thriftBinary, 1)
+		chunk.binary(column.name)
+		chunk.i32(4, parquetGzip)
+		chunk.i64(5, int64(len(samples)))
+		chunk.i64(6, int64(header.Len()+len(values)))
+		chunk.i64(7, int64(header.Len()+compressed.Len()))
+		chunk.i64(9, offset)
+		chunk.end()
+		chunk.end()
+		chunks = append(chunks, chunk.Bytes())
+		size += int64(header.Len() + len(values))
+	}
+
+	// FileMetaData
+	var meta thriftWriter
+	meta.i32(1, 1)
+	meta.list(2, thriftStruct, len(parquetColumns)+1)
+	meta.beginElement()
+	meta.string(4, "schema")
+	meta.i32(5, int32(len(parquetColumns)))
+	meta.end()
+	for _, column := range parquetColumns {
+		meta.beginElement()
+		meta.i32(1, column.typ)
+		meta.i32(3, parquetRequired)
+		meta.string(4, column.name)
+		if column.converted >= 0 {
+			meta.i32(6, column.converted)
+		}
+		meta.end()
+	}
+	meta.i64(3, int64(len(samples)))
+	meta.list(4, thriftStruct, 1)
+	meta.beginElement()
+	meta.list(1, thriftStruct, len(chunks))
+	for _, chunk := range chunks {
+		meta.Write(chunk)
+	}
+	meta.i64(2, size)
+	meta.i64(3, int64(len(samples)))
+	meta.end()
+	meta.string(6, "nature-remo-exporter")
+	meta.end()
+
+	file.Write(meta.Bytes())
+	file.Write(binary.LittleEndian.AppendUint32(nil, uint32(meta.Len())))
+	file.WriteString("PAR1")
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// the types of the Thrift compact protocol
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the structs of the Parquet metadata in the Thrift compact protocol.
+type thriftWriter struct {
+	bytes.Buffer
+	// last is the ID of the last field of each nested struct, from the outermost one.
+	last []int16
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	if len(w.last) == 0 {
+		w.last = []int16{0}
+	}
+	n := len(w.last)
+	delta := id - w.last[n-1]
+	w.last[n-1] = id
+	if delta > 0 && delta <= 15 {
+		w.WriteByte(byte(delta)<<4 | typ)
+		return
+	}
+	w.WriteByte(typ)
+	w.varint(int64(id))
+}
+
+// varint writes v in the zigzag encoding.
+func (w *thriftWriter) varint(v int64) {
+	w.Buffer.Write(binary.AppendVarint(nil, v))
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.varint(int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.varint(v)
+}
+
+func (w *thriftWriter) string(id int16, s string) {
+	w.field(id, thriftBinary)
+	w.binary(s)
+}
+
+func (w *thriftWriter) binary(s string) {
+	w.Buffer.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	w.WriteString(s)
+}
+
+// list writes the header of a list field, which is followed by n elements of the type.
+func (w *thriftWriter) list(id int16, typ byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.WriteByte(byte(n)<<4 | typ)
+		return
+	}
+	w.WriteByte(0xf0 | typ)
+	w.Buffer.Write(binary.AppendUvarint(nil, uint64(n)))
+}
+
+// beginStruct starts a struct field, which is ended by end.
+func (w *thriftWriter) beginStruct(id int16) {
+	w.field(id, thriftStruct)
+	w.last = append(w.last, 0)
+}
+
+// beginElement starts a struct element of a list, which is ended by end.
+func (w *thriftWriter) beginElement() {
+	if len(w.last) == 0 {
+		w.last = []int16{0}
+	}
+	w.last = append(w.last, 0)
+}
+
+// end ends the current struct with the stop field.
+func (w *thriftWriter) end() {
+	w.WriteByte(0)
+	if n := len(w.last); n > 0 {
+		w.last = w.last[:n-1]
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/deprecated"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// parquetRow is a row of the archive files, read by parquet-go independently of writeParquet.
+type parquetRow struct {
+	Timestamp  int64   `parquet:"timestamp"`
+	DeviceID   string  `parquet:"device_id"`
+	DeviceName string  `parquet:"device_name"`
+	Metric     string  `parquet:"metric"`
+	Value      float64 `parquet:"value"`
+}
+
+func TestWriteParquetRoundTrip(t *testing.T) {
+	ts := time.UnixMilli(1704067200123)
+	tests := []struct {
+		name    string
+		samples []sample
+	}{
+		{name: "no samples"},
+		{
+			name: "samples",
+			samples: []sample{
+				{Metric: "temperature", DeviceID: "d1", DeviceName: "Living", Value: 25.3, Timestamp: ts},
+				{Metric: "humidity", DeviceID: "d1", DeviceName: "Living", Value: 40, Timestamp: ts},
+				{Metric: "illumination", DeviceID: "d2", DeviceName: "", Value: -0.5, Timestamp: ts.Add(time.Hour)},
+				{Metric: "movement", DeviceID: "d3", DeviceName: "寝室", Value: 1, Timestamp: ts.Add(-time.Hour)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeParquet(&buf, tt.samples); err != nil {
+				t.Fatalf("writeParquet() error = %v", err)
+			}
+			r := bytes.NewReader(buf.Bytes())
+
+			f, err := parquet.OpenFile(r, r.Size())
+			if err != nil {
+				t.Fatalf("OpenFile() error = %v", err)
+			}
+			if f.NumRows() != int64(len(tt.samples)) {
+				t.Errorf("NumRows() = %d, want %d", f.NumRows(), len(tt.samples))
+			}
+			// the converted types are what DuckDB and pandas read the timestamps and the strings by
+			want := []struct {
+				name      string
+				typ       format.Type
+				converted *deprecated.ConvertedType
+			}{
+				{"timestamp", format.Int64, ptr(deprecated.TimestampMillis)},
+				{"device_id", format.ByteArray, ptr(deprecated.UTF8)},
+				{"device_name", format.ByteArray, ptr(deprecated.UTF8)},
+				{"metric", format.ByteArray, ptr(deprecated.UTF8)},
+				{"value", format.Double, nil},
+			}
+			elements := f.Metadata().Schema
+			if len(elements) != len(want)+1 {
+				t.Fatalf("schema has %d elements, want %d", len(elements), len(want)+1)
+			}
+			for i, w := range want {
+				e := elements[i+1]
+				if e.Name != w.name || e.Type == nil || *e.Type != w.typ || !reflect.DeepEqual(e.ConvertedType, w.converted) {
+					t.Errorf("column %d = %s %v %v, want %s %v %v", i, e.Name, e.Type, e.ConvertedType, w.name, w.typ, w.converted)
+				}
+				if e.RepetitionType == nil || *e.RepetitionType != format.Required {
+					t.Errorf("column %s is not required", e.Name)
+				}
+			}
+
+			rows, err := parquet.Read[parquetRow](r, r.Size())
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			var wantRows []parquetRow
+			for _, s := range tt.samples {
+				wantRows = append(wantRows, parquetRow{
+					Timestamp:  s.Timestamp.UnixMilli(),
+					DeviceID:   s.DeviceID,
+					DeviceName: s.DeviceName,
+					Metric:     s.Metric,
+					Value:      s.Value,
+				})
+			}
+			if len(rows) != len(wantRows) || len(rows) > 0 && !reflect.DeepEqual(rows, wantRows) {
+				t.Errorf("rows = %v, want %v", rows, wantRows)
+			}
+		})
+	}
+}
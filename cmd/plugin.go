@@ -0,0 +1,250 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/imishinist/nature-remo-exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/tenntenn/natureremo"
+)
+
+var (
+	pluginExec    []string
+	pluginTimeout time.Duration
+)
+
+// newPlugins creates the plugins enabled by the flags.
+func newPlugins() ([]collector.Plugin, error) {
+	var plugins []collector.Plugin
+	for _, command := range pluginExec {
+		args, err := splitArgs(command)
+		if err != nil {
+			return nil, fmt.Errorf("invalid plugin command %q: %v", command, err)
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("empty plugin command")
+		}
+		plugins = append(plugins, &execPlugin{args: args, timeout: pluginTimeout})
+	}
+	return plugins, nil
+}
+
+// splitArgs splits the command into the arguments by the spaces as a shell does,
+// except that the arguments can be quoted by single or double quotes, and a backslash escapes the next character.
+func splitArgs(command string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range command {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inArg = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote %c", quote)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// reservedMetricPrefixes are the prefixes of the metrics of the exporter and the Go client,
+// which the plugins must not export not to break the scrapes by the conflicts.
+var reservedMetricPrefixes = []string{"nature_remo_", "go_", "process_", "promhttp_"}
+
+// execPlugin is a plugin which runs a command after each poll with the devices in JSON on its stdin,
+// and exports the metrics printed by the command in the Prometheus text exposition format.
+// The metrics of the last successful run are kept until the next one succeeds.
+type execPlugin struct {
+	args    []string
+	timeout time.Duration
+
+	mu      sync.Mutex
+	metrics []prometheus.Metric
+}
+
+func (p *execPlugin) String() string {
+	return strings.Join(p.args, " ")
+}
+
+// Describe sends nothing, since the metrics are unknown until the command runs.
+func (p *execPlugin) Describe(ch chan<- *prometheus.Desc) {}
+
+func (p *execPlugin) Collect(ch chan<- prometheus.Metric) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range p.metrics {
+		ch <- m
+	}
+}
+
+func (p *execPlugin) Update(ctx context.Context, devices []*natureremo.Device) error {
+	in, err := json.Marshal(devices)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	c := exec.CommandContext(ctx, p.args[0], p.args[1:]...)
+	c.Stdin = bytes.NewReader(in)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("plugin %q failed: %v: %s", p, err, msg)
+		}
+		return fmt.Errorf("plugin %q failed: %v", p, err)
+	}
+
+	var parser expfmt.TextParser
+	mfs, err := parser.TextToMetricFamilies(&stdout)
+	if err != nil {
+		return fmt.Errorf("failed to parse the output of plugin %q: %v", p, err)
+	}
+	var metrics []prometheus.Metric
+	for _, mf := range mfs {
+		for _, prefix := range reservedMetricPrefixes {
+			if strings.HasPrefix(mf.GetName(), prefix) {
+				return fmt.Errorf("metric %s of plugin %q has the prefix %s reserved by the exporter", mf.GetName(), p, prefix)
+			}
+		}
+		for _, m := range mf.GetMetric() {
+			metric, err := constMetric(mf, m)
+			if err != nil {
+				return fmt.Errorf("invalid metric %s of plugin %q: %v", mf.GetName(), p, err)
+			}
+			metrics = append(metrics, metric)
+		}
+	}
+
+	// the duplicate series would fail the scrapes of all metrics, so the output is rejected as a whole
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(constMetrics(metrics)); err != nil {
+		return fmt.Errorf("invalid output of plugin %q: %v", p, err)
+	}
+	if _, err := reg.Gather(); err != nil {
+		return fmt.Errorf("invalid output of plugin %q: %v", p, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.metrics = metrics
+	return nil
+}
+
+// constMetrics is a collector of the fixed metrics.
+type constMetrics []prometheus.Metric
+
+func (c constMetrics) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c constMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c {
+		ch <- m
+	}
+}
+
+// constMetric converts a parsed metric to a constant metric to collect.
+func constMetric(mf *dto.MetricFamily, m *dto.Metric) (prometheus.Metric, error) {
+	var names, values []string
+	for _, l := range m.GetLabel() {
+		names = append(names, l.GetName())
+		values = append(values, l.GetValue())
+	}
+	desc := prometheus.NewDesc(mf.GetName(), mf.GetHelp(), names, nil)
+
+	var metric prometheus.Metric
+	var err error
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		metric, err = prometheus.NewConstMetric(desc, prometheus.CounterValue, m.GetCounter().GetValue(), values...)
+	case dto.MetricType_GAUGE:
+		metric, err = prometheus.NewConstMetric(desc, prometheus.GaugeValue, m.GetGauge().GetValue(), values...)
+	case dto.MetricType_UNTYPED:
+		metric, err = prometheus.NewConstMetric(desc, prometheus.UntypedValue, m.GetUntyped().GetValue(), values...)
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		buckets := make(map[float64]uint64)
+		for _, b := range h.GetBucket() {
+			buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+		}
+		metric, err = prometheus.NewConstHistogram(desc, h.GetSampleCount(), h.GetSampleSum(), buckets, values...)
+	case dto.MetricType_SUMMARY:
+		s := m.GetSummary()
+		quantiles := make(map[float64]float64)
+		for _, q := range s.GetQuantile() {
+			quantiles[q.GetQuantile()] = q.GetValue()
+		}
+		metric, err = prometheus.NewConstSummary(desc, s.GetSampleCount(), s.GetSampleSum(), quantiles, values...)
+	default:
+		return nil, fmt.Errorf("unsupported type %s", mf.GetType())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.TimestampMs != nil {
+		metric = prometheus.NewMetricWithTimestamp(time.UnixMilli(m.GetTimestampMs()), metric)
+	}
+	return metric, nil
+}
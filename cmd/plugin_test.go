@@ -0,0 +1,83 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+		wantErr bool
+	}{
+		{command: "plugin --format=prometheus", want: []string{"plugin", "--format=prometheus"}},
+		{command: "  plugin \t a  b ", want: []string{"plugin", "a", "b"}},
+		{command: `plugin "/path with/spaces" 'it''s'`, want: []string{"plugin", "/path with/spaces", "its"}},
+		{command: `plugin "say \"hi\"" 'no \escape'`, want: []string{"plugin", `say "hi"`, `no \escape`}},
+		{command: `plugin a\ b ""`, want: []string{"plugin", "a b", ""}},
+		{command: "", want: nil},
+		{command: `plugin "unterminated`, wantErr: true},
+		{command: `plugin trailing\`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.command)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitArgs(%q) error = %v, wantErr %v", tt.command, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestExecPluginUpdate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the plugins are run by sh")
+	}
+	tests := []struct {
+		name    string
+		output  string
+		want    int
+		wantErr bool
+	}{
+		{name: "valid", output: "discomfort_index{id=\"d1\"} 70\ndiscomfort_index{id=\"d2\"} 72\n", want: 2},
+		{name: "reserved prefix", output: "nature_remo_temperature{id=\"d1\"} 20\n", wantErr: true},
+		{name: "go prefix", output: "go_goroutines 1\n", wantErr: true},
+		{name: "duplicate series", output: "discomfort_index{id=\"d1\"} 70\ndiscomfort_index{id=\"d1\"} 71\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &execPlugin{args: []string{"sh", "-c", "printf '" + tt.output + "'"}, timeout: 10 * time.Second}
+			// the metrics of the last successful run are kept when the output is rejected
+			p.metrics = constMetrics{}
+			err := p.Update(context.Background(), nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Update() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(p.metrics) != tt.want {
+				t.Errorf("Update() kept %d metrics, want %d", len(p.metrics), tt.want)
+			}
+		})
+	}
+}
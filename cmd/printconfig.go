@@ -0,0 +1,125 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// secretFlags is the flags of the secrets masked by print-config, with the environment variables set instead of them.
+var secretFlags = map[string]string{
+	"token":               "",
+	"influxdb.token":      influxDBTokenEnv,
+	"mqtt.password":       mqttPasswordEnv,
+	"kafka.sasl-password": kafkaSASLPasswordEnv,
+	"datadog.api-key":     datadogAPIKeyEnv,
+}
+
+// printConfigCmd represents the print-config command
+var printConfigCmd = &cobra.Command{
+	Use:   "print-config",
+	Short: "Print the effective configuration",
+	Long: `Print-config prints the values of all flags resolved from the command line, --config.file,
+--profile and the environment variables as YAML in the format of --config.file, with the secrets masked.
+Each value not by default is commented with where it comes from.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := &yaml.Node{Kind: yaml.MappingNode}
+		cmd.Flags().VisitAll(func(f *pflag.Flag) {
+			// the flags selecting the file cannot be set in it
+			if f.Name == "config.file" || f.Name == "profile" || f.Name == "help" || f.Deprecated != "" {
+				return
+			}
+			value, source := effectiveFlag(cmd.Flags(), f)
+			flags.Content = append(flags.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: f.Name}, value)
+			value.LineComment = source
+		})
+		doc := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{{Kind: yaml.ScalarNode, Value: "flags"}, flags}}
+
+		enc := yaml.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent(2)
+		if err := enc.Encode(doc); err != nil {
+			return fmt.Errorf("failed to write configuration: %v", err)
+		}
+		return enc.Close()
+	},
+}
+
+// effectiveFlag returns the value of the flag as a YAML node with the secrets masked, and where it comes from.
+func effectiveFlag(flags *pflag.FlagSet, f *pflag.Flag) (*yaml.Node, string) {
+	source := ""
+	switch {
+	case configSources[f.Name] != "":
+		source = configSources[f.Name]
+	case f.Changed:
+		source = "command line"
+	}
+
+	if sv, ok := f.Value.(pflag.SliceValue); ok {
+		node := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for _, v := range sv.GetSlice() {
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v})
+		}
+		return node, source
+	}
+	if f.Value.Type() == "stringToString" {
+		m, _ := flags.GetStringToString(f.Name)
+		node := &yaml.Node{Kind: yaml.MappingNode, Style: yaml.FlowStyle}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: m[k]})
+		}
+		return node, source
+	}
+
+	value := f.Value.String()
+	if env, ok := secretFlags[f.Name]; ok {
+		if value == "" && env != "" && os.Getenv(env) != "" {
+			value, source = os.Getenv(env), env
+		}
+		if value != "" {
+			value = "<redacted>"
+		}
+	} else if u, err := url.Parse(value); err == nil && u.User != nil {
+		// the URLs may have the passwords, e.g. of Redis or the proxy
+		value = u.Redacted()
+	}
+
+	// the booleans and the numbers are left to be resolved, and the others are quoted if they look like them
+	tag := "!!str"
+	if t := f.Value.Type(); t == "bool" || strings.HasPrefix(t, "int") || strings.HasPrefix(t, "uint") || strings.HasPrefix(t, "float") {
+		tag = ""
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}, source
+}
+
+func init() {
+	rootCmd.AddCommand(printConfigCmd)
+}
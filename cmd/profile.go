@@ -0,0 +1,158 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	configFile string
+	profile    string
+
+	// configSources is where the flags set by applyConfig come from, to tell it by print-config.
+	configSources = make(map[string]string)
+)
+
+// config is the configuration file of the flags. The keys are the names of the flags without the leading --,
+// and the values are scalars, lists for the flags which can be repeated, or maps for the flags of key=value pairs.
+type config struct {
+	// Flags are applied with any profile.
+	Flags map[string]any `yaml:"flags"`
+	// Profiles are the named sets of the flags selected by --profile, which override Flags.
+	Profiles map[string]map[string]any `yaml:"profiles"`
+}
+
+func loadConfig(path string) (*config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	cfg := &config{}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse config file: %v", err)
+	}
+	return cfg, nil
+}
+
+// applyConfig sets the flags by --config.file and --profile, except the ones given on the command line,
+// which take precedence over the file.
+func applyConfig(flags *pflag.FlagSet) error {
+	if configFile == "" {
+		if profile != "" {
+			return fmt.Errorf("--profile requires --config.file")
+		}
+		return nil
+	}
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	values := make(map[string]any)
+	maps.Copy(values, cfg.Flags)
+	if profile != "" {
+		p, ok := cfg.Profiles[profile]
+		if !ok {
+			return fmt.Errorf("profile %q not found in config file %s", profile, configFile)
+		}
+		maps.Copy(values, p)
+	}
+
+	changed := make(map[string]bool)
+	flags.Visit(func(f *pflag.Flag) {
+		changed[f.Name] = true
+	})
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if name == "config.file" || name == "profile" {
+			return fmt.Errorf("--%s cannot be set in config file %s", name, configFile)
+		}
+		f := flags.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("unknown flag %q in config file %s", name, configFile)
+		}
+		if changed[name] {
+			continue
+		}
+		if err := setFlag(flags, f, values[name]); err != nil {
+			return fmt.Errorf("invalid value of %q in config file %s: %v", name, configFile, err)
+		}
+		configSources[name] = "config file"
+		if _, ok := cfg.Profiles[profile][name]; ok {
+			configSources[name] = "profile " + profile
+		}
+	}
+	return nil
+}
+
+// setFlag sets the value of the config file to the flag. A list replaces the values of a flag which can be repeated.
+func setFlag(flags *pflag.FlagSet, f *pflag.Flag, value any) error {
+	switch v := value.(type) {
+	case nil:
+		return fmt.Errorf("no value")
+	case map[string]any:
+		if f.Value.Type() != "stringToString" {
+			return fmt.Errorf("a map is given to a flag which does not take key=value pairs")
+		}
+		// the flags cannot be set to empty maps, which are their defaults
+		if len(v) == 0 {
+			return nil
+		}
+		pairs := make([]string, 0, len(v))
+		for k, e := range v {
+			pairs = append(pairs, k+"="+fmt.Sprint(e))
+		}
+		slices.Sort(pairs)
+		return flags.Set(f.Name, strings.Join(pairs, ","))
+	case []any:
+		sv, ok := f.Value.(pflag.SliceValue)
+		if !ok {
+			return fmt.Errorf("a list is given to a flag which takes a single value")
+		}
+		values := make([]string, len(v))
+		for i, e := range v {
+			values[i] = fmt.Sprint(e)
+		}
+		if err := sv.Replace(values); err != nil {
+			return err
+		}
+		f.Changed = true
+		return nil
+	default:
+		return flags.Set(f.Name, fmt.Sprint(v))
+	}
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&configFile, "config.file", "", "Path to the YAML file of the flags, with the named profiles of them selected by --profile")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "Name of the profile in --config.file to apply, e.g. home")
+}
@@ -0,0 +1,72 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+var (
+	pushgatewayURL      string
+	pushgatewayJob      string
+	pushgatewayInstance string
+	pushgatewayGrouping map[string]string
+)
+
+// pushgatewaySink pushes all metrics of the gatherer to the Pushgateway, replacing the previous ones of the group.
+type pushgatewaySink struct {
+	pusher *push.Pusher
+}
+
+func newPushgatewaySink(rawURL, job, instance string, grouping map[string]string, g prometheus.Gatherer) (*pushgatewaySink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Pushgateway URL: %v", err)
+	}
+	if instance == "" {
+		if instance, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("failed to get hostname for the Pushgateway instance: %v", err)
+		}
+	}
+
+	// the credentials are sent by basic auth instead of in the URL
+	var user *url.Userinfo
+	user, u.User = u.User, nil
+	pusher := push.New(u.String(), job).Gatherer(g).Grouping("instance", instance)
+	for name, value := range grouping {
+		pusher = pusher.Grouping(name, value)
+	}
+	if user != nil {
+		password, _ := user.Password()
+		pusher = pusher.BasicAuth(user.Username(), password)
+	}
+	return &pushgatewaySink{pusher: pusher}, nil
+}
+
+func (s *pushgatewaySink) Name() string {
+	return "pushgateway"
+}
+
+func (s *pushgatewaySink) Write(ctx context.Context, samples []sample) error {
+	return s.pusher.PushContext(ctx)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// clientBucketTTL is the idle time after which the token bucket of a client is discarded.
+const clientBucketTTL = 10 * time.Minute
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a middleware which limits the request rate per client with token buckets,
+// and the number of concurrent requests. It responds 429 beyond the limits.
+type rateLimiter struct {
+	next  http.Handler
+	rate  float64
+	burst int
+	sem   chan struct{}
+	// now returns the current time, which is replaced in tests.
+	now func() time.Time
+
+	mu          sync.Mutex
+	clients     map[string]*tokenBucket
+	lastCleanup time.Time
+}
+
+// newRateLimiter creates a rateLimiter. rate is the number of requests per second per client,
+// and maxConcurrent is the number of concurrent requests. Zero disables each limit.
+func newRateLimiter(next http.Handler, rate float64, burst, maxConcurrent int) *rateLimiter {
+	l := &rateLimiter{
+		next:    next,
+		rate:    rate,
+		burst:   max(burst, 1),
+		now:     time.Now,
+		clients: make(map[string]*tokenBucket),
+	}
+	if maxConcurrent > 0 {
+		l.sem = make(chan struct{}, maxConcurrent)
+	}
+	return l
+}
+
+// allow takes a token from the bucket of the client. If there are no tokens,
+// it returns false and the duration until the next token is available.
+func (l *rateLimiter) allow(client string) (bool, time.Duration) {
+	if l.rate <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastCleanup) > clientBucketTTL {
+		for k, b := range l.clients {
+			if now.Sub(b.last) > clientBucketTTL {
+				delete(l.clients, k)
+			}
+		}
+		l.lastCleanup = now
+	}
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.burst), last: now}
+		l.clients[client] = b
+	}
+	b.tokens = math.Min(float64(l.burst), b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+func (l *rateLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// e.g. unix domain sockets
+		client = r.RemoteAddr
+	}
+	if ok, wait := l.allow(client); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	if l.sem != nil {
+		select {
+		case l.sem <- struct{}{}:
+			defer func() { <-l.sem }()
+		default:
+			http.Error(w, "too many concurrent requests", http.StatusTooManyRequests)
+			return
+		}
+	}
+	l.next.ServeHTTP(w, r)
+}
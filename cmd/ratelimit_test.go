@@ -0,0 +1,124 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock which moves only when it is advanced.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.t }
+
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestRateLimiterPerClient(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := newRateLimiter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), 0.5, 2, 0)
+	l.now = clock.Now
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		l.ServeHTTP(rec, r)
+		return rec
+	}
+
+	// the burst is allowed, and then the client is limited
+	for i := 0; i < 2; i++ {
+		if rec := request("192.0.2.1:50000"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want %d", i+1, rec.Code, http.StatusOK)
+		}
+	}
+	rec := request("192.0.2.1:50001")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status beyond the burst = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want %q", got, "2")
+	}
+
+	// the other clients have their own buckets
+	if rec := request("192.0.2.2:50000"); rec.Code != http.StatusOK {
+		t.Errorf("status of another client = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	// a token is added every 2s
+	clock.Advance(2 * time.Second)
+	if rec := request("192.0.2.1:50002"); rec.Code != http.StatusOK {
+		t.Errorf("status after the refill = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := request("192.0.2.1:50003"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status after the refill is used = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+
+	// the buckets idle for the TTL are evicted by the next request
+	clock.Advance(clientBucketTTL / 2)
+	request("192.0.2.2:50001")
+	clock.Advance(clientBucketTTL/2 + time.Second)
+	request("192.0.2.3:50000")
+	if _, ok := l.clients["192.0.2.1"]; ok {
+		t.Error("the idle bucket is not evicted")
+	}
+	if _, ok := l.clients["192.0.2.2"]; !ok {
+		t.Error("the bucket used within the TTL is evicted")
+	}
+	if rec := request("192.0.2.1:50004"); rec.Code != http.StatusOK {
+		t.Errorf("status after the eviction = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestRateLimiterConcurrency(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	l := newRateLimiter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}), 0, 0, 1)
+
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		l.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		done <- rec.Code
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	l.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status of the concurrent request = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("status of the first request = %d, want %d", code, http.StatusOK)
+	}
+	// the slot is released after the request
+	go func() { <-started }()
+	rec = httptest.NewRecorder()
+	l.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status after the release = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
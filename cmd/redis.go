@@ -0,0 +1,295 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	apiRateLimitRedisURL string
+	apiRateLimitRedisKey string
+	apiRateLimitRequests int
+	apiRateLimitPeriod   time.Duration
+)
+
+// tokenBucketScript takes a token from the bucket in the hash of the key, refilling it by the elapsed time since the last call.
+// It returns 0 if a token is taken, or the milliseconds until the next token otherwise.
+// The time of the Redis server is used, so that the clocks of the clients sharing the bucket do not matter.
+const tokenBucketScript = `
+if redis.replicate_commands then redis.replicate_commands() end
+local capacity = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(b[1]) or capacity
+local ts = tonumber(b[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * capacity / period)
+local wait = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+else
+  wait = math.ceil((1 - tokens) * period / capacity)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], period)
+return wait
+`
+
+// redisBucket is a token bucket in Redis shared by the clients calling the API with the same token.
+type redisBucket struct {
+	addr     string
+	tls      *tls.Config
+	username string
+	password string
+	db       int
+	key      string
+
+	capacity int
+	period   time.Duration
+
+	// mu serializes the commands on the connection, which is reused by the calls and reopened after it is broken.
+	mu   sync.Mutex
+	conn net.Conn
+	rw   *bufio.ReadWriter
+}
+
+// newRedisBucket creates a bucket in the Redis of the URL, redis://[[user]:password@]host[:port][/db] or rediss:// for TLS.
+func newRedisBucket(rawURL, key string, capacity int, period time.Duration) (*redisBucket, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %v", err)
+	}
+	b := &redisBucket{key: key, capacity: capacity, period: period}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		b.tls = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, fmt.Errorf("invalid Redis URL %q: scheme must be redis or rediss", u.Redacted())
+	}
+	b.addr = u.Host
+	if u.Port() == "" {
+		b.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		b.username = u.User.Username()
+		b.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if b.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid Redis URL %q: invalid database %q", u.Redacted(), db)
+		}
+	}
+	if capacity <= 0 || period <= 0 {
+		return nil, fmt.Errorf("the requests and the period of the rate limit must be positive")
+	}
+	return b, nil
+}
+
+// Take takes a token, and returns the duration until the next token if the bucket is empty.
+func (b *redisBucket) Take(ctx context.Context) (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	reused := b.conn != nil
+	reply, err := b.eval(ctx)
+	var replyErr redisError
+	if err != nil && reused && !errors.As(err, &replyErr) {
+		// the idle connection may have been closed by the server
+		reply, err = b.eval(ctx)
+	}
+	if err != nil {
+		return 0, err
+	}
+	wait, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("failed to take a token from Redis: unexpected reply %v", reply)
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+// eval runs the script on the connection, connecting first if there is none.
+// The connection is closed if it fails other than by an error reply, which leaves it usable.
+func (b *redisBucket) eval(ctx context.Context) (any, error) {
+	if b.conn == nil {
+		if err := b.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	deadline, _ := ctx.Deadline()
+	b.conn.SetDeadline(deadline)
+	reply, err := redisDo(b.rw, "EVAL", tokenBucketScript, "1", b.key, strconv.Itoa(b.capacity), strconv.FormatInt(b.period.Milliseconds(), 10))
+	if err != nil {
+		var replyErr redisError
+		if !errors.As(err, &replyErr) {
+			b.conn.Close()
+			b.conn, b.rw = nil, nil
+		}
+		return nil, fmt.Errorf("failed to take a token from Redis: %w", err)
+	}
+	return reply, nil
+}
+
+// connect opens the connection, and authenticates and selects the database on it.
+func (b *redisBucket) connect(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", b.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Redis: %v", err)
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	if b.tls != nil {
+		tc := tls.Client(conn, b.tls)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to connect to Redis: %v", err)
+		}
+		conn = tc
+	}
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	if b.password != "" {
+		args := []string{"AUTH", b.password}
+		if b.username != "" {
+			args = []string{"AUTH", b.username, b.password}
+		}
+		if _, err := redisDo(rw, args...); err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to authenticate to Redis: %v", err)
+		}
+	}
+	if b.db != 0 {
+		if _, err := redisDo(rw, "SELECT", strconv.Itoa(b.db)); err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to select Redis database %d: %v", b.db, err)
+		}
+	}
+	b.conn, b.rw = conn, rw
+	return nil
+}
+
+// redisError is an error reply of Redis, after which the connection is still usable.
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// redisDo sends the command and reads its reply, which is a string, an int64, nil or a []any.
+func redisDo(rw *bufio.ReadWriter, args ...string) (any, error) {
+	fmt.Fprintf(rw, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(rw, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := rw.Flush(); err != nil {
+		return nil, err
+	}
+	return redisReply(rw.Reader)
+}
+
+func redisReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]any, n)
+		for i := range values {
+			if values[i], err = redisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unexpected reply %q", line)
+	}
+}
+
+// defaultRedisKey returns the key of the bucket of the token, which is shared by the exporters with the same token
+// without putting the token itself in Redis.
+func defaultRedisKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "nature-remo-exporter:rate-limit:" + hex.EncodeToString(sum[:8])
+}
+
+// budgetError is returned by budgetTransport when the shared budget of the calls is run out.
+type budgetError struct {
+	RetryAt time.Time
+}
+
+func (e *budgetError) Error() string {
+	return fmt.Sprintf("the shared rate limit budget is exhausted until %s", e.RetryAt.Format(time.RFC3339))
+}
+
+// budgetTransport is an http.RoundTripper which takes a token from the shared bucket before each call,
+// and fails the call without sending it if the bucket is empty.
+type budgetTransport struct {
+	next   http.RoundTripper
+	bucket *redisBucket
+}
+
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	wait, err := t.bucket.Take(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 {
+		return nil, &budgetError{RetryAt: time.Now().Add(wait)}
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,233 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a RESP server which records the commands, and replies to EVAL by the function called with its lock held.
+type fakeRedis struct {
+	ln   net.Listener
+	eval func(args []string) string
+
+	mu       sync.Mutex
+	conns    []net.Conn
+	commands []string
+}
+
+func newFakeRedis(t *testing.T, eval func(args []string) string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeRedis{ln: ln, eval: eval}
+	t.Cleanup(func() {
+		ln.Close()
+		s.closeConns()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		req, err := redisReply(r)
+		if err != nil {
+			return
+		}
+		values, _ := req.([]any)
+		args := make([]string, len(values))
+		for i, v := range values {
+			args[i], _ = v.(string)
+		}
+		if len(args) == 0 {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, args[0])
+		reply := "+OK\r\n"
+		if args[0] == "EVAL" {
+			reply = s.eval(args)
+		}
+		s.mu.Unlock()
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+// closeConns closes the connections from the server side, like the idle timeout of Redis.
+func (s *fakeRedis) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *fakeRedis) stats() (conns int, commands string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns), strings.Join(s.commands, " ")
+}
+
+func TestRedisBucketReusesConnection(t *testing.T) {
+	var evalArgs []string
+	server := newFakeRedis(t, func(args []string) string {
+		evalArgs = args
+		return ":0\r\n"
+	})
+	b, err := newRedisBucket(fmt.Sprintf("redis://user:secret@%s/2", server.ln.Addr()), "bucket", 30, 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		wait, err := b.Take(context.Background())
+		if err != nil {
+			t.Fatalf("Take() error = %v", err)
+		}
+		if wait != 0 {
+			t.Errorf("Take() = %s, want 0", wait)
+		}
+	}
+
+	conns, commands := server.stats()
+	if conns != 1 {
+		t.Errorf("connections = %d, want 1", conns)
+	}
+	if want := "AUTH SELECT EVAL EVAL EVAL"; commands != want {
+		t.Errorf("commands = %q, want %q", commands, want)
+	}
+	if want := []string{"EVAL", tokenBucketScript, "1", "bucket", "30", "300000"}; strings.Join(evalArgs, "|") != strings.Join(want, "|") {
+		t.Errorf("EVAL args = %q, want %q", evalArgs, want)
+	}
+}
+
+func TestRedisBucketEmpty(t *testing.T) {
+	server := newFakeRedis(t, func(args []string) string { return ":1500\r\n" })
+	b, err := newRedisBucket("redis://"+server.ln.Addr().String(), "bucket", 30, 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait, err := b.Take(context.Background())
+	if err != nil {
+		t.Fatalf("Take() error = %v", err)
+	}
+	if wait != 1500*time.Millisecond {
+		t.Errorf("Take() = %s, want 1.5s", wait)
+	}
+}
+
+func TestRedisBucketErrorReply(t *testing.T) {
+	fail := true
+	server := newFakeRedis(t, func(args []string) string {
+		if fail {
+			fail = false
+			return "-BUSY Redis is busy running a script\r\n"
+		}
+		return ":0\r\n"
+	})
+	b, err := newRedisBucket("redis://"+server.ln.Addr().String(), "bucket", 30, 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Take(context.Background()); err == nil || !strings.Contains(err.Error(), "BUSY") {
+		t.Errorf("Take() error = %v, want the error reply", err)
+	}
+	if _, err := b.Take(context.Background()); err != nil {
+		t.Errorf("Take() error = %v after the error reply", err)
+	}
+	// an error reply does not break the connection
+	if conns, _ := server.stats(); conns != 1 {
+		t.Errorf("connections = %d, want 1", conns)
+	}
+}
+
+func TestRedisBucketReconnects(t *testing.T) {
+	server := newFakeRedis(t, func(args []string) string { return ":0\r\n" })
+	b, err := newRedisBucket("redis://:secret@"+server.ln.Addr().String(), "bucket", 30, 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Take(context.Background()); err != nil {
+		t.Fatalf("Take() error = %v", err)
+	}
+	server.closeConns()
+	// the closed idle connection is replaced without failing the call
+	if _, err := b.Take(context.Background()); err != nil {
+		t.Fatalf("Take() error = %v after the server closed the connection", err)
+	}
+	conns, commands := server.stats()
+	if conns != 2 {
+		t.Errorf("connections = %d, want 2", conns)
+	}
+	if want := "AUTH EVAL AUTH EVAL"; commands != want {
+		t.Errorf("commands = %q, want %q", commands, want)
+	}
+}
+
+func TestNewRedisBucket(t *testing.T) {
+	tests := []struct {
+		url      string
+		wantAddr string
+		wantDB   int
+		wantTLS  bool
+		wantErr  bool
+	}{
+		{url: "redis://localhost", wantAddr: "localhost:6379"},
+		{url: "redis://localhost:6380/3", wantAddr: "localhost:6380", wantDB: 3},
+		{url: "rediss://redis.example.com", wantAddr: "redis.example.com:6379", wantTLS: true},
+		{url: "http://localhost", wantErr: true},
+		{url: "redis://localhost/db", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			b, err := newRedisBucket(tt.url, "bucket", 30, 5*time.Minute)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newRedisBucket() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if b.addr != tt.wantAddr || b.db != tt.wantDB || (b.tls != nil) != tt.wantTLS {
+				t.Errorf("newRedisBucket() = addr %s, db %d, TLS %v, want %s, %d, %v", b.addr, b.db, b.tls != nil, tt.wantAddr, tt.wantDB, tt.wantTLS)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// apiRetry is the retry policy of the Nature Remo API calls configured by the flags.
+var apiRetry retryPolicy
+
+// retryPolicy retries the API calls failed by retryable errors with exponential backoff.
+type retryPolicy struct {
+	// Attempts is the maximum number of calls including the first one.
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter randomizes each delay by the fraction, e.g. 0.2 for ±20%.
+	Jitter float64
+}
+
+// resendCountKey is the key of the context value which counts the retries of the call, for the spans of the requests.
+type resendCountKey struct{}
+
+// resendCount returns the number of the retries before the call of the context.
+func resendCount(ctx context.Context) int {
+	n, _ := ctx.Value(resendCountKey{}).(int)
+	return n
+}
+
+// Do calls the function until it succeeds, fails with an error which is not retryable, or runs out of the attempts.
+// It returns the last error, without waiting for the next attempt if the context is done.
+// The retried calls are given the count of the retries by the context, which is recorded to their spans.
+func (p retryPolicy) Do(ctx context.Context, call func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		callCtx := ctx
+		if attempt > 1 {
+			callCtx = context.WithValue(ctx, resendCountKey{}, attempt-1)
+		}
+		err := call(callCtx)
+		if err == nil || attempt >= p.Attempts || !retryable(err) {
+			return err
+		}
+		t := time.NewTimer(p.delay(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// delay returns the delay after the failed attempt, which doubles from BaseDelay up to MaxDelay.
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return jitter(d, p.Jitter)
+}
+
+// jitter randomizes the duration by the fraction, e.g. 0.2 for ±20%.
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return d
+	}
+	return d + time.Duration(float64(d)*fraction*(2*rand.Float64()-1))
+}
+
+// retryable reports whether the error of the API call is worth retrying.
+func retryable(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.Retryable
+}
@@ -0,0 +1,125 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestJitter(t *testing.T) {
+	const d = 10 * time.Second
+	if got := jitter(d, 0); got != d {
+		t.Errorf("jitter(%s, 0) = %s, want %s", d, got, d)
+	}
+	for i := 0; i < 1000; i++ {
+		if got := jitter(d, 0.2); got < 8*time.Second || got > 12*time.Second {
+			t.Fatalf("jitter(%s, 0.2) = %s, want within ±20%%", d, got)
+		}
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  retryPolicy
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{name: "first", policy: retryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute}, attempt: 1, min: time.Second, max: time.Second},
+		{name: "doubled", policy: retryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute}, attempt: 4, min: 8 * time.Second, max: 8 * time.Second},
+		{name: "capped", policy: retryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}, attempt: 5, min: 10 * time.Second, max: 10 * time.Second},
+		{name: "capped after many attempts", policy: retryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}, attempt: 100, min: 10 * time.Second, max: 10 * time.Second},
+		{name: "uncapped", policy: retryPolicy{BaseDelay: time.Second}, attempt: 6, min: 32 * time.Second, max: 32 * time.Second},
+		{name: "jitter", policy: retryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute, Jitter: 0.5}, attempt: 2, min: time.Second, max: 3 * time.Second},
+		{name: "jitter of the cap", policy: retryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second, Jitter: 0.2}, attempt: 10, min: 8 * time.Second, max: 12 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				if got := tt.policy.delay(tt.attempt); got < tt.min || got > tt.max {
+					t.Fatalf("delay(%d) = %s, want within [%s, %s]", tt.attempt, got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "network", err: &apiError{Class: apiErrorNetwork, Retryable: true}, want: true},
+		{name: "server", err: &apiError{Class: apiErrorServer, Retryable: true}, want: true},
+		{name: "wrapped server", err: fmt.Errorf("poll: %w", &apiError{Class: apiErrorServer, Retryable: true}), want: true},
+		{name: "auth", err: &apiError{Class: apiErrorAuth}},
+		{name: "client", err: &apiError{Class: apiErrorClient}},
+		{name: "rate limited", err: newAPIError(errors.New("too many requests"), &apiResponse{StatusCode: 429})},
+		{name: "shared budget exhausted", err: newAPIError(&budgetError{RetryAt: time.Now().Add(time.Minute)}, &apiResponse{})},
+		{name: "not an API error", err: errors.New("failed")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	policy := retryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+	}{
+		{name: "success", errs: []error{nil}, wantCalls: 1},
+		{name: "retried", errs: []error{&apiError{Class: apiErrorServer, Retryable: true}, nil}, wantCalls: 2},
+		{name: "out of attempts", errs: []error{
+			&apiError{Class: apiErrorServer, Retryable: true},
+			&apiError{Class: apiErrorServer, Retryable: true},
+			&apiError{Class: apiErrorServer, Retryable: true},
+		}, wantCalls: 3},
+		{name: "rate limited is not retried", errs: []error{&apiError{Class: apiErrorRateLimit}}, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := policy.Do(context.Background(), func(ctx context.Context) error {
+				if n := resendCount(ctx); n != calls {
+					t.Errorf("resendCount() = %d, want %d", n, calls)
+				}
+				err := tt.errs[calls]
+				calls++
+				return err
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if want := tt.errs[len(tt.errs)-1]; err != want {
+				t.Errorf("Do() error = %v, want %v", err, want)
+			}
+		})
+	}
+}
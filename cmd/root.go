@@ -20,133 +20,117 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/imishinist/nature-remo-exporter/pkg/collector"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 	"github.com/tenntenn/natureremo"
+	"google.golang.org/grpc"
 )
 
-type Metrics struct {
-	APICallsTotal *prometheus.CounterVec
-
-	Temperature  *prometheus.GaugeVec
-	Humidity     *prometheus.GaugeVec
-	Illumination *prometheus.GaugeVec
-	Movement     *prometheus.GaugeVec
-
-	MovementsTotal *prometheus.CounterVec
-
-	lastMovements map[string]time.Time
-}
-
-func NewMetrics() *Metrics {
-	namespace := "nature_remo"
-	deviceLabels := []string{
-		"id",
-		"name",
-		"firmware_version",
-		"bt_mac_address",
-		"mac_address",
-		"serial_number",
+// metricsOptions returns the options of the collector by the flags.
+func metricsOptions(plugins []collector.Plugin) collector.Options {
+	opts := collector.Options{
+		SensorTimestamps:       metricsSensorTimestamps,
+		SensorTimestampsMaxAge: metricsSensorTimestampsMaxAge,
+		Conformance:            metricsConformance,
+		ConformanceLegacyNames: metricsConformanceLegacyNames,
+		MaxSampleAge:           maxSampleAge,
+		MaxSampleAgeNaN:        maxSampleAgeNaN,
+		StaleAfter:             metricsStaleAfter,
+		Window:                 metricsWindow,
+		TrendWindow:            metricsTrendWindow,
+		MovementDedupWindow:    metricsMovementDedupWindow,
+		Plugins:                plugins,
 	}
-
-	apiCallsTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Namespace: namespace,
-		Name:      "api_calls_total",
-		Help:      "Total number of API calls",
-	}, []string{})
-
-	temperature := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: namespace,
-		Name:      "temperature",
-		Help:      "current temperature",
-	}, deviceLabels)
-	humidity := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: namespace,
-		Name:      "humidity",
-		Help:      "current humidity",
-	}, deviceLabels)
-	illumination := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: namespace,
-		Name:      "illumination",
-		Help:      "current illumination",
-	}, deviceLabels)
-	movement := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: namespace,
-		Name:      "movement",
-		Help:      "current movement",
-	}, deviceLabels)
-
-	movementsTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Namespace: namespace,
-		Name:      "movements_total",
-	}, deviceLabels)
-	return &Metrics{
-		APICallsTotal:  apiCallsTotal,
-		Temperature:    temperature,
-		Humidity:       humidity,
-		Illumination:   illumination,
-		Movement:       movement,
-		MovementsTotal: movementsTotal,
-
-		lastMovements: make(map[string]time.Time),
+	if metricsNativeHistograms {
+		opts.NativeHistogramBucketFactor = 1.1
 	}
+	return opts
 }
 
-func (m *Metrics) IncAPICallsTotal() {
-	m.APICallsTotal.WithLabelValues().Inc()
-}
-
-func (m *Metrics) Set(devices []*natureremo.Device) error {
-	for _, device := range devices {
-		labels := prometheus.Labels{
-			"id":               device.ID,
-			"name":             device.Name,
-			"firmware_version": device.FirmwareVersion,
-			"mac_address":      device.MacAddress,
-			"bt_mac_address":   device.BtMacAddress,
-			"serial_number":    device.SerialNumber,
-		}
-		m.Temperature.With(labels).Set(device.NewestEvents[natureremo.SensorTypeTemperature].Value)
-		m.Humidity.With(labels).Set(device.NewestEvents[natureremo.SensorTypeHumidity].Value)
-		m.Illumination.With(labels).Set(device.NewestEvents[natureremo.SensorTypeIllumination].Value)
-
-		movement := device.NewestEvents[natureremo.SensorTypeMovement]
-		m.Movement.With(labels).Set(movement.Value)
-
-		inc := 0.0
-		if m.updateLastMovement(device.ID, movement.CreatedAt) {
-			inc = 1
+// fetchDevices fetches all devices from the Nature Remo API without reflecting them to the metrics.
+// It also reports whether the devices are not modified since the last call, if the conditional requests are enabled.
+func fetchDevices(ctx context.Context, fetcher collector.DeviceFetcher, metrics *collector.Metrics) ([]*natureremo.Device, bool, error) {
+	var devices []*natureremo.Device
+	notModified := false
+	err := apiRetry.Do(ctx, func(ctx context.Context) error {
+		ctx, res := withAPIResponse(ctx)
+		var err error
+		devices, err = fetcher.FetchDevices(ctx)
+		notModified = res.NotModified
+		metrics.ObserveAPIRequest(&res.APIRequest)
+		if err != nil {
+			return newAPIError(err, res)
 		}
-		m.MovementsTotal.With(labels).Add(inc)
-	}
-	return nil
-}
-
-func (m *Metrics) updateLastMovement(key string, lastMovement time.Time) bool {
-	l, ok := m.lastMovements[key]
-	if !ok {
-		m.lastMovements[key] = lastMovement
-		return false
-	}
-	if l == lastMovement {
-		return false
+		return nil
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get all devices from Nature Remo API: %w", err)
 	}
-
-	m.lastMovements[key] = lastMovement
-	return true
+	metrics.IncAPICallsTotal()
+	return devices, notModified, nil
 }
 
 var (
-	port     int
-	interval time.Duration
-
-	accessToken string
+	port            int
+	shutdownTimeout time.Duration
+	readyMaxAge     time.Duration
+
+	webReadHeaderTimeout time.Duration
+	webReadTimeout       time.Duration
+	webWriteTimeout      time.Duration
+	webIdleTimeout       time.Duration
+	webEnablePprof       bool
+	webMinScrapeInterval time.Duration
+
+	webRateLimit            float64
+	webRateLimitBurst       int
+	webMaxConcurrentScrapes int
+	listenAddress           string
+	webSocketMode           string
+	webSystemd              bool
+	webAdminListenAddress   string
+	grpcListenAddress       string
+	interval                time.Duration
+	intervalJitter          float64
+	intervalAdaptive        bool
+
+	errorSummaryInterval time.Duration
+	webAccessLog         bool
+	webTLSCert           string
+	webTLSKey            string
+	webTLSClientCA       string
+	webTLSClientCNs      []string
+	webConfigFile        string
+	webBearerTokenFile   string
+
+	accessToken           string
+	apiAppliances         bool
+	apiAppliancesInterval time.Duration
+
+	metricsSensorTimestamps       bool
+	metricsSensorTimestampsMaxAge time.Duration
+	metricsNativeHistograms       bool
+	metricsConformance            bool
+	metricsConformanceLegacyNames bool
+	metricsMovementDedupWindow    time.Duration
+	maxSampleAge                  time.Duration
+	maxSampleAgeNaN               bool
+	metricsStaleAfter             time.Duration
+	metricsWindow                 time.Duration
+	metricsTrendWindow            time.Duration
 
 	// rootCmd represents the base command when called without any subcommands
 	rootCmd = &cobra.Command{
@@ -157,55 +141,604 @@ var (
 This tool collects metrics from Nature Remo Cloud API and exposes them in a format 
 that Prometheus can scrape. It is designed to help monitor and analyze 
 the performance and data from Nature Remo devices`,
+		// the flags of the subcommands are also set by the file, and the token is read before any command uses it
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := applyConfig(cmd.Flags()); err != nil {
+				return err
+			}
+			return readTokenFromStdin(cmd.InOrStdin())
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+			logger, err := newLogger()
+			if err != nil {
+				return err
+			}
 
-			client := natureremo.NewClient(accessToken)
-			metrics := NewMetrics()
+			if tracingEnabled() {
+				shutdown, err := setupTracing(cmd.Context())
+				if err != nil {
+					return err
+				}
+				defer func() {
+					ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+					defer cancel()
+					if err := shutdown(ctx); err != nil {
+						logger.Error(fmt.Sprintf("failed to flush traces: %v", err))
+					}
+				}()
+			}
 
-			update := func(ctx context.Context) error {
-				devices, err := client.DeviceService.GetAll(ctx)
+			if err := validateInterval(interval, intervalJitter); err != nil {
+				return err
+			}
+			if localMDNS && localMDNSInterval <= 0 {
+				return fmt.Errorf("--local.mdns-interval must be positive: %v", localMDNSInterval)
+			}
+
+			var devicesCfg *devicesConfig
+			var sched *deviceScheduler
+			if devicesConfigFile != "" {
+				devicesCfg, err = loadDevicesConfig(devicesConfigFile)
 				if err != nil {
-					return fmt.Errorf("failed to get all devices from Nature Remo API: %v", err)
+					return err
 				}
-				metrics.IncAPICallsTotal()
-				if err := metrics.Set(devices); err != nil {
-					return fmt.Errorf("failed to set metrics: %v", err)
+				sched = newDeviceScheduler(devicesCfg, interval)
+				// the devices are fetched at the shortest interval of them
+				interval = sched.PollInterval()
+			}
+
+			if err := checkDebugAuth(); err != nil {
+				return err
+			}
+			if webEnableDebugAPI {
+				apiResponses = newRawResponses()
+			}
+			var auth *bearerAuth
+			if webBearerTokenFile != "" || os.Getenv(bearerTokensEnv) != "" {
+				auth, err = newBearerAuth(webBearerTokenFile, logger)
+				if err != nil {
+					return err
 				}
-				return nil
+			}
+			if cmd.Flags().Changed("port") && !cmd.Flags().Changed("web.listen-address") {
+				listenAddress = fmt.Sprintf(":%d", port)
 			}
 
-			go func() {
-				if err := update(cmd.Context()); err != nil {
+			fetcher, err := newFetcher()
+			if err != nil {
+				return err
+			}
+			// only the leader calls the API, and the others fetch the devices from it
+			var elector *leaseElector
+			if haLeaseName != "" {
+				identity := haAdvertiseURL
+				if identity == "" {
+					useTLS, err := webTLSEnabled()
+					if err != nil {
+						return err
+					}
+					identity, err = defaultAdvertiseURL(listenAddress, useTLS)
+					if err != nil {
+						return err
+					}
+				}
+				elector, err = newLeaseElector(haLeaseNamespace, haLeaseName, identity, haLeaseDuration)
+				if err != nil {
+					return err
+				}
+				client, err := newHAClient(apiTimeout, logger)
+				if err != nil {
+					return err
+				}
+				ha := &haFetcher{api: fetcher, elector: elector, client: client, username: haBasicAuthUsername}
+				if auth != nil {
+					ha.token = auth.Token()
+				}
+				if haBasicAuthPasswordFile != "" {
+					b, err := os.ReadFile(haBasicAuthPasswordFile)
+					if err != nil {
+						return fmt.Errorf("failed to read --ha.basic-auth-password-file: %v", err)
+					}
+					ha.password = strings.TrimSpace(string(b))
+				}
+				fetcher = ha
+			}
+			switch {
+			case mockMode:
+				logger.Warn("serving the synthetic devices of the mock mode instead of Nature Remo API")
+			case replayDir != "":
+				logger.Warn(fmt.Sprintf("replaying the fixtures in %s instead of calling Nature Remo API", replayDir))
+			}
+			plugins, err := newPlugins()
+			if err != nil {
+				return err
+			}
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+			c := collector.New(fetcher, metricsOptions(plugins), reg)
+			metrics := c.Metrics()
+			var state *stateStore
+			if stateFile != "" {
+				var st *collector.State
+				state, st, err = openStateStore(stateFile)
+				if err != nil {
+					return err
+				}
+				metrics.RestoreState(st)
+			}
+
+			sinks, err := newSinks(cmd.Context(), logger, reg)
+			if err != nil {
+				return err
+			}
+			var hist *history
+			if historyPath != "" {
+				hist, err = openHistory(historyPath, historyRetention)
+				if err != nil {
+					return err
+				}
+				sinks = append(sinks, hist)
+			}
+			// the rules are evaluated on the current readings, so they are not buffered
+			var notify *notifySink
+			if notifyConfigFile != "" {
+				notify, err = newNotifySink(notifyConfigFile)
+				if err != nil {
+					return err
+				}
+				sinks = append(sinks, notify)
+			}
+			defer closeSinks(sinks)
+
+			h := &health{}
+			h.Beat()
+
+			snap := &snapshot{}
+			events := newStream()
+			sampler := newErrorSampler(logger, errorSummaryInterval)
+			appliancesSampler := newErrorSampler(logger, errorSummaryInterval)
+			ready := false
+			// the polls are skipped until the rate limit is reset, not to extend it
+			var limitedUntil time.Time
+			// the appliances are fetched by the polls at --api.appliances.interval.
+			// half an interval is tolerated not to miss a poll by the delay or the jitter of its tick
+			var appliancesUpdatedAt time.Time
+			// the polls are slowed down or paused while nobody scrapes, and a scrape wakes them up
+			var scrapes *scrapeTracker
+			var wake <-chan struct{}
+			var lastPoll time.Time
+			idle := false
+			if idleAfter > 0 {
+				scrapes = newScrapeTracker(idleAfter)
+				wake = scrapes.Wake()
+			}
+			var breaker *circuitBreaker
+			if apiCircuitBreakerFailures > 0 {
+				breaker = newCircuitBreaker(apiCircuitBreakerFailures, apiCircuitBreakerCooldown, metrics.CircuitBreakerState.WithLabelValues())
+			}
+			var dstate *debugState
+			if webEnableDebugState {
+				dstate = newDebugState(snap, reg)
+			}
+			poll := func() {
+				if dstate != nil {
+					// the state is copied after any poll including the skipped ones
+					defer func() {
+						st := pollState{
+							PolledAt:            time.Now(),
+							Ready:               ready,
+							Idle:                idle,
+							Movements:           metrics.State().Devices,
+							AppliancesUpdatedAt: timeOrNil(appliancesUpdatedAt),
+							RateLimitedUntil:    timeOrNil(limitedUntil),
+						}
+						if breaker != nil {
+							st.CircuitBreaker = breaker.state.String()
+						}
+						if sched != nil {
+							st.DeviceIntervals = sched.Intervals()
+							st.DevicesUpdatedAt = sched.UpdatedAt()
+						}
+						if elector != nil {
+							st.Leader = elector.Leader()
+						}
+						dstate.Set(st)
+					}()
+				}
+				if time.Now().Before(limitedUntil) {
+					logger.Debug(fmt.Sprintf("skipping the poll until the rate limit is reset at %s", limitedUntil.Format(time.RFC3339)))
+					return
+				}
+				if breaker != nil && !breaker.Allow(time.Now()) {
+					logger.Debug("skipping the poll while the circuit breaker is open")
+					return
+				}
+				if scrapes != nil {
+					now := time.Now()
+					switch {
+					case !scrapes.Idle(now):
+						if idle {
+							idle = false
+							logger.Info("resuming the polls by a scrape")
+						}
+					case !idle:
+						idle = true
+						if idleInterval > 0 {
+							logger.Info(fmt.Sprintf("no scrapes for %s, slowing down the polls to every %s", idleAfter, idleInterval))
+						} else {
+							logger.Info(fmt.Sprintf("no scrapes for %s, pausing the polls", idleAfter))
+						}
+						return
+					}
+					if idle && (idleInterval <= 0 || now.Sub(lastPoll) < idleInterval) {
+						logger.Debug("skipping the poll while nobody scrapes")
+						return
+					}
+					lastPoll = now
+				}
+
+				// the followers only reflect the devices of the leader, not to push or notify them twice
+				leading := elector == nil || elector.IsLeader()
+				start := time.Now()
+				ctx, span := startSpan(cmd.Context(), "poll")
+				devices, notModified, err := fetchDevices(ctx, fetcher, metrics)
+				// the devices are reflected by the polls at their own intervals
+				due := devices
+				if err == nil && sched != nil {
+					due = sched.Due(devices, start)
+				}
+				// a failure of the appliances does not fail the poll, since the devices are still worth reflecting
+				var meterSamples []sample
+				if err == nil && apiAppliances && !start.Before(appliancesUpdatedAt.Add(apiAppliancesInterval-interval/2)) {
+					var appliancesErr error
+					meterSamples, appliancesErr = updateAppliances(ctx, fetcher, metrics, snap)
+					metrics.SetUpdateSuccess("appliances", appliancesErr == nil)
+					if appliancesErr != nil {
+						appliancesSampler.Error(appliancesErr)
+						if until := rateLimitedUntil(appliancesErr); time.Now().Before(until) {
+							limitedUntil = until
+							logger.Warn(fmt.Sprintf("rate limited by Nature Remo API, pausing the polls until %s", until.Format(time.RFC3339)))
+						}
+					} else {
+						appliancesUpdatedAt = start
+						appliancesSampler.Success()
+					}
+				}
+				endSpan(span, err)
+				metrics.ObservePollDuration(time.Since(start))
+				if err != nil {
+					c.Fail()
+					sampler.Error(err)
+					h.Failure(err)
+					if until := rateLimitedUntil(err); time.Now().Before(until) {
+						limitedUntil = until
+						logger.Warn(fmt.Sprintf("rate limited by Nature Remo API, pausing the polls until %s", until.Format(time.RFC3339)))
+					} else if breaker != nil && breaker.Failure(time.Now()) {
+						logger.Warn(fmt.Sprintf("opened the circuit breaker, pausing the polls for %s", apiCircuitBreakerCooldown))
+					}
+					if notify != nil && leading {
+						notify.Failure(cmd.Context(), logger, err)
+					}
+					return
+				}
+				snap.SetDevices(devices)
+				// the metrics and the events would not change by the same devices,
+				// but the devices not due or not modified are still refreshed not to go stale
+				changed := due
+				if notModified {
+					changed = nil
+				} else {
+					events.Update(due)
+				}
+				if err := c.Reflect(cmd.Context(), devices, changed); err != nil {
 					logger.Error(err.Error())
 				}
+				if breaker != nil && breaker.Success() {
+					logger.Info("closed the circuit breaker")
+				}
+				if leading {
+					pushSamples(cmd.Context(), logger, sinks, append(samplesOf(due), meterSamples...))
+				}
+				if state != nil {
+					if err := state.Save(metrics.State()); err != nil {
+						logger.Error(err.Error())
+					}
+				}
+				sampler.Success()
+				h.Success()
+				logger.Debug("metrics updated")
+
+				if !ready {
+					ready = true
+					if _, err := sdNotify("READY=1"); err != nil {
+						logger.Error(fmt.Sprintf("failed to notify systemd: %v", err))
+					}
+				}
+			}
 
-				ticker := time.NewTicker(interval)
-				defer ticker.Stop()
+			// a panic in a poll, e.g. by an unexpected response, must not stop the polling loop
+			safePoll := func() {
+				defer func() {
+					if r := recover(); r != nil {
+						metrics.IncInternalErrors()
+						logger.Error(fmt.Sprintf("panic in a poll: %v", r), slog.String("stack", string(debug.Stack())))
+					}
+				}()
+				poll()
+			}
+
+			var wg sync.WaitGroup
+			if elector != nil {
+				metrics.Leader.WithLabelValues().Set(0)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					elector.Run(cmd.Context(), logger, func(leader bool) {
+						if leader {
+							metrics.Leader.WithLabelValues().Set(1)
+							logger.Info(fmt.Sprintf("became the leader by lease %s", haLeaseName))
+						} else {
+							metrics.Leader.WithLabelValues().Set(0)
+							logger.Info(fmt.Sprintf("lost lease %s, fetching the devices from the leader", haLeaseName))
+						}
+					})
+				}()
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer h.Stop()
+
+				// the keepalives are sent from the polling loop, so that systemd can detect a hung loop
+				var watchdog <-chan time.Time
+				if d := sdWatchdogInterval(); d > 0 {
+					t := time.NewTicker(d)
+					defer t.Stop()
+					watchdog = t.C
+				}
+
+				safePoll()
+
+				ticks := &tickSchedule{tick: time.Now(), jitter: intervalJitter}
+				nextTick := func() time.Duration {
+					period := interval
+					if rl, ok := metrics.RateLimit(); ok && intervalAdaptive {
+						calls := 1.0
+						if apiAppliances {
+							calls += float64(interval) / float64(max(interval, apiAppliancesInterval))
+						}
+						period = adaptiveInterval(interval, rl, calls, time.Now())
+						if period > interval {
+							logger.Debug(fmt.Sprintf("stretching the interval to %s by the rate limit: %d/%d remaining until %s", period.Round(time.Second), rl.Remaining, rl.Limit, rl.Reset.Format(time.RFC3339)))
+						}
+					}
+					return ticks.next(period, time.Now())
+				}
+				// until the first successful poll, the failed polls are retried with backoff instead of waiting for the interval
+				startupRetry := retryPolicy{BaseDelay: max(apiRetry.BaseDelay, time.Second), MaxDelay: interval, Jitter: apiRetry.Jitter}
+				startupFailures := 0
+				next := func() time.Duration {
+					if ready {
+						return nextTick()
+					}
+					startupFailures++
+					d := startupRetry.delay(startupFailures)
+					logger.Info(fmt.Sprintf("retrying the first poll in %s", d.Round(time.Millisecond)))
+					return d
+				}
+				timer := time.NewTimer(next())
+				defer timer.Stop()
 				for {
 					select {
 					case <-cmd.Context().Done():
 						logger.Info("shutting down")
+						sdNotify("STOPPING=1")
 						return
-					case <-ticker.C:
-						if err := update(cmd.Context()); err != nil {
-							logger.Error(err.Error())
+					case <-timer.C:
+						safePoll()
+						h.Beat()
+						timer.Reset(next())
+					case <-wake:
+						safePoll()
+					case <-watchdog:
+						if _, err := sdNotify("WATCHDOG=1"); err != nil {
+							logger.Error(fmt.Sprintf("failed to notify systemd: %v", err))
 						}
-						logger.Debug("metrics updated")
 					}
 				}
 			}()
 
-			reg := prometheus.NewRegistry()
-			reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
-			reg.MustRegister(metrics.APICallsTotal)
-			reg.MustRegister(metrics.Temperature, metrics.Humidity, metrics.Illumination, metrics.Movement, metrics.MovementsTotal)
-			http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg}))
+			addrs := make(map[string]string)
+			if devicesCfg != nil {
+				addrs = devicesCfg.Addresses()
+			}
+			if len(addrs) > 0 || localMDNS {
+				prober := newLocalProber(addrs, localProbeTimeout, metrics.LocalReachable, logger)
+				if localMDNS {
+					discovery := newMDNSDiscovery(addrs, prober, logger)
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						discovery.Run(cmd.Context(), localMDNSInterval)
+					}()
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					prober.Run(cmd.Context(), localProbeInterval)
+				}()
+			}
+
+			var metricsHandler http.Handler = promhttp.HandlerFor(reg, promhttp.HandlerOpts{
+				Registry: reg,
+				// the timestamps and the exemplars are only exposed in the OpenMetrics format
+				EnableOpenMetrics: metricsSensorTimestamps || tracingEnabled(),
+			})
+			if webMinScrapeInterval > 0 {
+				metricsHandler = newScrapeCache(metricsHandler, webMinScrapeInterval)
+			}
+			if webRateLimit > 0 || webMaxConcurrentScrapes > 0 {
+				metricsHandler = newRateLimiter(metricsHandler, webRateLimit, webRateLimitBurst, webMaxConcurrentScrapes)
+			}
+			apiHandler := snap.Handler()
+			streamHandler := events.Handler()
+			sdHandler := snap.SDHandler()
+			var probe http.Handler = probeHandler(reg)
+			if scrapes != nil {
+				metricsHandler = scrapes.Handler(metricsHandler)
+				probe = scrapes.Handler(probe)
+			}
+			if auth != nil {
+				metricsHandler = auth.Handler(metricsHandler)
+				apiHandler = auth.Handler(apiHandler)
+				streamHandler = auth.Handler(streamHandler)
+				sdHandler = auth.Handler(sdHandler)
+				probe = auth.Handler(probe)
+			}
+			// use a dedicated mux so that third-party packages cannot register handlers to the server
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metricsHandler)
+			mux.Handle("/api/v1/devices", apiHandler)
+			mux.Handle("/api/v1/stream", streamHandler)
+			mux.Handle("/api/v1/sd", sdHandler)
+			mux.Handle("/probe", probe)
+			if apiResponses != nil {
+				var debugAPIHandler http.Handler = apiResponses.Handler()
+				if auth != nil {
+					debugAPIHandler = auth.Handler(debugAPIHandler)
+				}
+				mux.Handle("/debug/api", debugAPIHandler)
+			}
+			if hist != nil {
+				var historyHandler http.Handler = hist.Handler()
+				if auth != nil {
+					historyHandler = auth.Handler(historyHandler)
+				}
+				mux.Handle("/api/v1/history", historyHandler)
+			}
+
+			// the admin endpoints are served on the separate listener if configured
+			adminMux := mux
+			if webAdminListenAddress != "" {
+				adminMux = http.NewServeMux()
+			}
+			adminMux.Handle("/healthz", h.LivenessHandler(livenessMaxSilence(interval, intervalAdaptive)))
+			maxAge := readyMaxAge
+			if maxAge <= 0 {
+				maxAge = 3 * interval
+			}
+			adminMux.Handle("/readyz", h.ReadinessHandler(maxAge))
+			if webEnablePprof {
+				handlePprof(adminMux, auth)
+			}
+			if dstate != nil {
+				var debugStateHandler http.Handler = dstate.Handler()
+				if auth != nil {
+					debugStateHandler = auth.Handler(debugStateHandler)
+				}
+				adminMux.Handle("/debug/state", debugStateHandler)
+			}
+
+			server := newServer(listenAddress, mux)
+			if err := configureServer(server, logger); err != nil {
+				return err
+			}
 
-			logger.Info(fmt.Sprintf("Listening on port %d", port))
-			if err := http.ListenAndServe(fmt.Sprintf(":%d", port), nil); err != nil {
+			var ln net.Listener
+			if webSystemd {
+				ln, err = systemdListener()
+				listenAddress = "systemd socket"
+			} else {
+				ln, err = listen(listenAddress)
+			}
+			if err != nil {
 				return err
 			}
+			servers := []*http.Server{server}
+			errCh := make(chan error, 4)
+			go func() {
+				if server.TLSConfig != nil {
+					logger.Info(fmt.Sprintf("Listening on %s with TLS", listenAddress))
+					errCh <- server.ServeTLS(ln, "", "")
+					return
+				}
+				logger.Info(fmt.Sprintf("Listening on %s", listenAddress))
+				errCh <- server.Serve(ln)
+			}()
+
+			if webAdminListenAddress != "" {
+				adminServer := newServer(webAdminListenAddress, adminMux)
+				if err := configureServer(adminServer, logger); err != nil {
+					return err
+				}
+				adminLn, err := listen(webAdminListenAddress)
+				if err != nil {
+					return err
+				}
+				servers = append(servers, adminServer)
+				go func() {
+					if adminServer.TLSConfig != nil {
+						logger.Info(fmt.Sprintf("Listening on %s for admin endpoints with TLS", webAdminListenAddress))
+						errCh <- adminServer.ServeTLS(adminLn, "", "")
+						return
+					}
+					logger.Info(fmt.Sprintf("Listening on %s for admin endpoints", webAdminListenAddress))
+					errCh <- adminServer.Serve(adminLn)
+				}()
+			}
+
+			var grpcServer *grpc.Server
+			if grpcListenAddress != "" {
+				var web *webConfigLoader
+				if webConfigFile != "" {
+					web, err = newWebConfigLoader(webConfigFile, logger)
+					if err != nil {
+						return err
+					}
+				}
+				grpcServer = newGRPCServer(snap, events, auth, web, server.TLSConfig)
+				grpcLn, err := listen(grpcListenAddress)
+				if err != nil {
+					return err
+				}
+				go func() {
+					logger.Info(fmt.Sprintf("Listening on %s for gRPC", grpcListenAddress))
+					errCh <- grpcServer.Serve(grpcLn)
+				}()
+			}
+
+			if snmpListenAddress != "" {
+				agent, err := newSNMPAgent(snmpListenAddress, snmpCommunity, snmpMIBOID, snap, logger)
+				if err != nil {
+					return err
+				}
+				defer agent.Close()
+				go func() {
+					logger.Info(fmt.Sprintf("Listening on %s for SNMP", snmpListenAddress))
+					errCh <- agent.Serve()
+				}()
+			}
+
+			select {
+			case err := <-errCh:
+				return err
+			case <-cmd.Context().Done():
+			}
+
+			// wait for the in-flight scrapes to finish
+			events.Close()
+			if grpcServer != nil {
+				grpcServer.GracefulStop()
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			for _, server := range servers {
+				if err := server.Shutdown(ctx); err != nil {
+					return fmt.Errorf("failed to shut down HTTP server: %v", err)
+				}
+			}
+			wg.Wait()
 			return nil
 		},
 	}
@@ -214,7 +747,13 @@ the performance and data from Nature Remo devices`,
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	err := rootCmd.Execute()
+	if runAsService() {
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := rootCmd.ExecuteContext(ctx)
 	if err != nil {
 		os.Exit(1)
 	}
@@ -222,6 +761,157 @@ func Execute() {
 
 func init() {
 	rootCmd.PersistentFlags().IntVar(&port, "port", 9199, "Port to listen on")
+	rootCmd.PersistentFlags().MarkDeprecated("port", "use --web.listen-address instead")
+	rootCmd.PersistentFlags().StringVar(&listenAddress, "web.listen-address", ":9199", "Address to listen on, e.g. 127.0.0.1:9199, or unix:/path/to/socket for a unix domain socket")
+	rootCmd.PersistentFlags().StringVar(&webAdminListenAddress, "web.admin-listen-address", "", "Address to serve the admin endpoints (/healthz, /readyz, /debug/*) on instead of --web.listen-address, e.g. 127.0.0.1:9200")
+	rootCmd.PersistentFlags().StringVar(&grpcListenAddress, "grpc.listen-address", "", "Address to serve the gRPC API on, e.g. 127.0.0.1:9198 (disabled if empty)")
+	rootCmd.PersistentFlags().StringVar(&snmpListenAddress, "snmp.listen-address", "", "UDP address to serve the readings on by SNMPv1 and v2c, e.g. :1161 (disabled if empty)")
+	rootCmd.PersistentFlags().StringVar(&snmpCommunity, "snmp.community", "", "Community of the SNMP requests to answer (required with --snmp.listen-address)")
+	rootCmd.PersistentFlags().StringVar(&snmpMIBOID, "snmp.mib-oid", snmpDefaultMIBOID, "OID of NATURE-REMO-EXPORTER-MIB, which is under netSnmpPlaypen reserved for local use by default")
+	rootCmd.PersistentFlags().BoolVar(&webSystemd, "web.systemd-socket", false, "Use the socket passed by systemd socket activation instead of --web.listen-address")
+	rootCmd.PersistentFlags().DurationVar(&readyMaxAge, "web.ready-max-age", 0, "Maximum age of the last successful update for /readyz to return 200 (default 3 times --interval)")
+	rootCmd.PersistentFlags().DurationVar(&webReadHeaderTimeout, "web.read-header-timeout", 10*time.Second, "Maximum duration to read request headers (0 for no timeout)")
+	rootCmd.PersistentFlags().DurationVar(&webReadTimeout, "web.read-timeout", 30*time.Second, "Maximum duration to read an entire request (0 for no timeout)")
+	rootCmd.PersistentFlags().DurationVar(&webWriteTimeout, "web.write-timeout", 30*time.Second, "Maximum duration to write a response (0 for no timeout)")
+	rootCmd.PersistentFlags().DurationVar(&webIdleTimeout, "web.idle-timeout", 2*time.Minute, "Maximum duration to keep an idle keep-alive connection (0 for no timeout)")
+	rootCmd.PersistentFlags().BoolVar(&webEnableDebugAPI, "web.enable-debug-api", false, "Serve the last raw responses of Nature Remo API on /debug/api, which requires authentication")
+	rootCmd.PersistentFlags().BoolVar(&webEnableDebugState, "web.enable-debug-state", false, "Serve the internal state of the polls, such as the last movements and the circuit breaker, on /debug/state, which requires authentication")
+	rootCmd.PersistentFlags().BoolVar(&webEnablePprof, "web.enable-pprof", false, "Enable the profiling endpoints under /debug/pprof/, which requires authentication; profiles longer than --web.write-timeout are rejected")
+	rootCmd.PersistentFlags().DurationVar(&webMinScrapeInterval, "web.min-scrape-interval", 0, "Serve the cached metrics to scrapes arriving within this duration of the previous one (0 to disable)")
+	rootCmd.PersistentFlags().Float64Var(&webRateLimit, "web.rate-limit", 0, "Maximum scrape requests per second per client (0 to disable)")
+	rootCmd.PersistentFlags().IntVar(&webRateLimitBurst, "web.rate-limit-burst", 5, "Maximum burst of scrape requests per client")
+	rootCmd.PersistentFlags().IntVar(&webMaxConcurrentScrapes, "web.max-concurrent-scrapes", 0, "Maximum number of concurrent scrape requests (0 to disable)")
+	rootCmd.PersistentFlags().DurationVar(&shutdownTimeout, "web.shutdown-timeout", 10*time.Second, "Maximum time to wait for in-flight requests on shutdown")
+	rootCmd.PersistentFlags().StringVar(&webSocketMode, "web.socket-mode", "0660", "Permissions of the unix domain socket in octal")
 	rootCmd.PersistentFlags().DurationVar(&interval, "interval", time.Second*30, "Interval between metrics refresh")
-	rootCmd.PersistentFlags().StringVar(&accessToken, "token", "", "Nature Remo access token")
+	rootCmd.PersistentFlags().BoolVar(&intervalAdaptive, "interval-adaptive", false, "Stretch the interval while the remaining rate limit would run out before it is reset at --interval, e.g. when other tools share the token")
+	rootCmd.PersistentFlags().DurationVar(&idleAfter, "idle.after", 0, "Slow down the polls to --idle.interval if nobody has scraped the metrics for the duration, until the next scrape (0 to disable)")
+	rootCmd.PersistentFlags().DurationVar(&idleInterval, "idle.interval", 0, "Interval of the polls while nobody scrapes (0 to pause the polls)")
+	rootCmd.PersistentFlags().StringArrayVar(&pluginExec, "plugin.exec", nil, "Command to run after each poll with the devices in JSON on its stdin, which prints metrics to export in the Prometheus text format (can be repeated)")
+	rootCmd.PersistentFlags().DurationVar(&pluginTimeout, "plugin.timeout", 10*time.Second, "Timeout of each run of a --plugin.exec command")
+	rootCmd.PersistentFlags().StringVar(&devicesConfigFile, "devices.config.file", "", "Path to the configuration file of the intervals overriding --interval and the local addresses of each device")
+	rootCmd.PersistentFlags().DurationVar(&localProbeInterval, "local.probe-interval", 30*time.Second, "Interval to probe the reachability of the devices on the LAN at, which have their addresses in --devices.config.file")
+	rootCmd.PersistentFlags().DurationVar(&localProbeTimeout, "local.probe-timeout", 2*time.Second, "Timeout of each probe of a device on the LAN")
+	rootCmd.PersistentFlags().BoolVar(&localMDNS, "local.mdns", false, "Discover the devices on the LAN by mDNS ("+remoService+") to probe them in addition to the ones in --devices.config.file")
+	rootCmd.PersistentFlags().DurationVar(&localMDNSInterval, "local.mdns-interval", 5*time.Minute, "Interval to discover the devices by mDNS at, to follow the changes of their addresses")
+	rootCmd.PersistentFlags().StringVar(&haLeaseName, "ha.lease-name", "", "Name of the Kubernetes Lease to elect the leader of the replicas, which is the only one polling the API")
+	rootCmd.PersistentFlags().StringVar(&haLeaseNamespace, "ha.lease-namespace", "", "Namespace of the Lease (default: the namespace of the pod)")
+	rootCmd.PersistentFlags().DurationVar(&haLeaseDuration, "ha.lease-duration", 15*time.Second, "Duration of the Lease, after which another replica takes over the leader")
+	rootCmd.PersistentFlags().StringVar(&haAdvertiseURL, "ha.advertise-url", "", "URL of this replica which the others fetch the devices from while it is the leader (default: http://$POD_IP:<port of --web.listen-address>, or https with TLS)")
+	rootCmd.PersistentFlags().StringVar(&haBasicAuthUsername, "ha.basic-auth-username", "", "Username of the basic authentication to fetch the devices from the leader, for the users of --web.config.file")
+	rootCmd.PersistentFlags().StringVar(&haBasicAuthPasswordFile, "ha.basic-auth-password-file", "", "File of the password of --ha.basic-auth-username")
+	rootCmd.PersistentFlags().StringVar(&haTLSCert, "ha.tls-cert", "", "Client certificate file to fetch the devices from the leader requiring client certificates; reloaded when modified")
+	rootCmd.PersistentFlags().StringVar(&haTLSKey, "ha.tls-key", "", "Private key file of --ha.tls-cert")
+	rootCmd.PersistentFlags().StringVar(&haTLSCA, "ha.tls-ca", "", "CA certificate file to verify the leader serving HTTPS (default: the system roots)")
+	rootCmd.PersistentFlags().Float64Var(&intervalJitter, "interval-jitter", 0, "Fraction to randomize each interval by, e.g. 0.1 for ±10%, so that many exporters do not call the API at the same time")
+	rootCmd.PersistentFlags().StringVar(&apiBaseURL, "api.base-url", "", "Base URL of the Nature Remo API, e.g. of a mock server or a proxy gateway (default https://api.nature.global/)")
+	rootCmd.PersistentFlags().StringVar(&apiUserAgentSuffix, "api.user-agent-suffix", "", "Suffix of the User-Agent of the API calls after nature-remo-exporter/<version>, e.g. to identify the instance")
+	rootCmd.PersistentFlags().BoolVar(&apiConditionalRequests, "api.conditional-requests", false, "Send the ETags of the last responses by If-None-Match, to receive 304 Not Modified without the body if nothing has changed")
+	rootCmd.PersistentFlags().StringVar(&apiProxyURL, "api.proxy-url", "", "URL of the proxy to the Nature Remo API, e.g. http://proxy.example.com:3128 (default from HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	rootCmd.PersistentFlags().IntVar(&apiMaxIdleConns, "api.transport.max-idle-conns", 100, "Maximum number of idle connections to the Nature Remo API kept for reuse (0 for no limit)")
+	rootCmd.PersistentFlags().DurationVar(&apiIdleConnTimeout, "api.transport.idle-conn-timeout", 90*time.Second, "Time after which an idle connection to the Nature Remo API is closed (0 for no limit)")
+	rootCmd.PersistentFlags().DurationVar(&apiTLSHandshakeTimeout, "api.transport.tls-handshake-timeout", 10*time.Second, "Timeout of the TLS handshake with the Nature Remo API (0 for no timeout)")
+	rootCmd.PersistentFlags().BoolVar(&apiDisableKeepAlives, "api.transport.disable-keep-alives", false, "Open a new connection to the Nature Remo API for each call instead of reusing the idle ones")
+	rootCmd.PersistentFlags().StringVar(&apiAuditLogFile, "api.audit-log.file", "", "Path of the file to append a line of JSON to for each call to Nature Remo API, with its status, latency and rate limit (disabled if empty)")
+	rootCmd.PersistentFlags().StringVar(&apiRateLimitRedisURL, "api.rate-limit.redis-url", "", "URL of Redis to share the budget of the API calls with the other clients of the token by a token bucket, redis://[[user]:password@]host[:port][/db] or rediss:// (disabled if empty)")
+	rootCmd.PersistentFlags().StringVar(&apiRateLimitRedisKey, "api.rate-limit.redis-key", "", "Key of the token bucket in Redis (default: derived from the hash of the token)")
+	rootCmd.PersistentFlags().IntVar(&apiRateLimitRequests, "api.rate-limit.requests", 30, "Number of the API calls allowed per --api.rate-limit.period by the shared budget")
+	rootCmd.PersistentFlags().DurationVar(&apiRateLimitPeriod, "api.rate-limit.period", 5*time.Minute, "Period of the shared budget of the API calls")
+	rootCmd.PersistentFlags().DurationVar(&apiTimeout, "api.timeout", 10*time.Second, "Timeout of each call to the Nature Remo API (0 for no timeout)")
+	rootCmd.PersistentFlags().IntVar(&apiRetry.Attempts, "api.retry.attempts", 3, "Maximum number of calls to the Nature Remo API in a poll, retrying on network and server errors (1 to disable retries)")
+	rootCmd.PersistentFlags().DurationVar(&apiRetry.BaseDelay, "api.retry.base-delay", time.Second, "Delay before the first retry, which doubles on each retry")
+	rootCmd.PersistentFlags().DurationVar(&apiRetry.MaxDelay, "api.retry.max-delay", 10*time.Second, "Maximum delay between retries")
+	rootCmd.PersistentFlags().Float64Var(&apiRetry.Jitter, "api.retry.jitter", 0.2, "Fraction to randomize the delays between retries by, e.g. 0.2 for ±20%")
+	rootCmd.PersistentFlags().IntVar(&apiCircuitBreakerFailures, "api.circuit-breaker.failures", 5, "Number of consecutive failed polls to open the circuit breaker, which pauses the polls for --api.circuit-breaker.cooldown (0 to disable)")
+	rootCmd.PersistentFlags().DurationVar(&apiCircuitBreakerCooldown, "api.circuit-breaker.cooldown", 5*time.Minute, "Period to pause the polls for after the circuit breaker opens, before probing the API with a poll")
+	rootCmd.PersistentFlags().BoolVar(&apiAppliances, "api.appliances", false, "Also fetch the appliances to serve their states on /api/v1/devices, and push the power of the smart meters of Nature Remo E to the outputs")
+	rootCmd.PersistentFlags().DurationVar(&apiAppliancesInterval, "api.appliances.interval", 0, "Interval to fetch the appliances at, which is rounded to a multiple of --interval (default --interval)")
+	rootCmd.PersistentFlags().BoolVar(&metricsSensorTimestamps, "metrics.sensor-timestamps", false, "Export the sensor values with the time when they were measured, and enable the OpenMetrics format")
+	rootCmd.PersistentFlags().DurationVar(&metricsSensorTimestampsMaxAge, "metrics.sensor-timestamps.max-age", time.Hour, "Stop exporting the sensor values measured longer ago than this with --metrics.sensor-timestamps, as Prometheus rejects too old samples")
+	rootCmd.PersistentFlags().StringVar(&stateFile, "state.file", "", "Path of the file to save the last movements and the movement counters in, to restore them on restart (disabled if empty)")
+	rootCmd.PersistentFlags().DurationVar(&maxSampleAge, "max-sample-age", 0, "Stop exporting the temperature, the humidity and the illumination measured longer ago than this, e.g. of an unplugged device (0 to disable)")
+	rootCmd.PersistentFlags().BoolVar(&maxSampleAgeNaN, "max-sample-age.nan", false, "Export NaN instead of the values older than --max-sample-age")
+	rootCmd.PersistentFlags().DurationVar(&metricsStaleAfter, "metrics.stale-after", 0, "Stop exporting the sensor values of the devices not refreshed for this, e.g. while the API is failing, so that the series go stale (0 to keep the cached values)")
+	rootCmd.PersistentFlags().DurationVar(&metricsWindow, "metrics.window", 0, "Export the minimum, the maximum and the average of the sensor values over this window as _min, _max and _avg (0 to disable)")
+	rootCmd.PersistentFlags().DurationVar(&metricsTrendWindow, "metrics.trend-window", 0, "Export the rate of change of the temperature per hour over this window as nature_remo_temperature_trend (0 to disable)")
+	rootCmd.PersistentFlags().DurationVar(&metricsMovementDedupWindow, "metrics.movement-dedup-window", 0, "Tolerance of the timestamp of the same movement event, not to count the jittered timestamps of an event as new movements (0 to count any different timestamp)")
+	rootCmd.PersistentFlags().BoolVar(&metricsConformance, "metrics.conformance", false, "Rename the metrics of the sensors to follow the naming conventions of Prometheus, e.g. nature_remo_temperature_celsius")
+	rootCmd.PersistentFlags().BoolVar(&metricsConformanceLegacyNames, "metrics.conformance.legacy-names", false, "Also export the metrics by the old names with --metrics.conformance during the deprecation period of the names")
+	rootCmd.PersistentFlags().BoolVar(&metricsNativeHistograms, "metrics.native-histograms", false, "Expose the duration histograms also as native histograms, which Prometheus scrapes with the native histograms feature enabled")
+	rootCmd.PersistentFlags().StringVar(&accessToken, "token", "", "Nature Remo access token, or - to read it from the first line of stdin")
+	rootCmd.PersistentFlags().StringVar(&recordDir, "record", "", "Directory to write the body of each response of Nature Remo API to as a fixture for --replay, e.g. for a bug report")
+	rootCmd.PersistentFlags().IntVar(&recordMaxFiles, "record.max-files", 1000, "Maximum number of the fixtures of each endpoint kept by --record, removing the oldest ones (0 for no limit)")
+	rootCmd.PersistentFlags().StringVar(&replayDir, "replay", "", "Directory of the fixtures written by --record to serve in order instead of calling Nature Remo API")
+	rootCmd.PersistentFlags().BoolVar(&mockMode, "mock", false, "Serve synthetic devices instead of calling Nature Remo API, e.g. to try the dashboards and the alerting rules without a token")
+	rootCmd.PersistentFlags().StringVar(&webTLSCert, "web.tls-cert", "", "TLS certificate file to serve HTTPS; reloaded when modified")
+	rootCmd.PersistentFlags().StringVar(&webTLSKey, "web.tls-key", "", "TLS private key file to serve HTTPS; reloaded when modified")
+	rootCmd.PersistentFlags().StringVar(&webTLSClientCA, "web.tls-client-ca", "", "CA certificate file to require and verify client certificates")
+	rootCmd.PersistentFlags().StringSliceVar(&webTLSClientCNs, "web.tls-client-allowed-cn", nil, "Common names of the client certificates to allow (default: any verified client)")
+	rootCmd.PersistentFlags().StringVar(&webConfigFile, "web.config.file", "", "Path to the configuration file for TLS and basic authentication, in the format of prometheus/exporter-toolkit")
+	rootCmd.PersistentFlags().StringVar(&webBearerTokenFile, "web.bearer-token-file", "", "File of the bearer tokens required on scrape requests, one per line (also "+bearerTokensEnv+" separated by commas)")
+	rootCmd.PersistentFlags().BoolVar(&webAccessLog, "web.access-log", false, "Log requests to the HTTP endpoints")
+	rootCmd.PersistentFlags().DurationVar(&pushTimeout, "push.timeout", 10*time.Second, "Timeout of pushing the readings to each output")
+	rootCmd.PersistentFlags().StringVar(&pushBufferDir, "push.buffer-dir", "", "Directory to buffer the readings failed to push, to replay them when the output is reachable again (disabled if empty)")
+	rootCmd.PersistentFlags().Int64Var(&pushBufferMaxSize, "push.buffer-max-size", 100, "Maximum size of the buffer of each output in megabytes; the oldest readings are dropped beyond it (0 for no limit)")
+	rootCmd.PersistentFlags().StringVar(&historyPath, "history.path", "", "Path of the SQLite database to store the readings of each poll in, and serve them on /api/v1/history (disabled if empty)")
+	rootCmd.PersistentFlags().DurationVar(&historyRetention, "history.retention", 0, "Retention of the readings in the history database (0 to keep all)")
+	rootCmd.PersistentFlags().StringVar(&notifyConfigFile, "notify.config.file", "", "Path to the configuration file of the notifications to webhooks, Slack, Discord and LINE by rules on the readings (disabled if empty)")
+	rootCmd.PersistentFlags().StringVar(&archiveDir, "archive.dir", "", "Directory to archive the readings of each day in as a file (disabled if empty)")
+	rootCmd.PersistentFlags().StringVar(&archiveFormat, "archive.format", "parquet", "Format of the archive files (parquet, csv, json)")
+	rootCmd.PersistentFlags().DurationVar(&archiveRetention, "archive.retention", 0, "Retention of the archive files in the directory by their days (0 to keep all)")
+	rootCmd.PersistentFlags().StringVar(&archiveS3Bucket, "archive.s3.bucket", "", "S3 bucket to upload the archive files to, with the default credential chain of the AWS SDK (disabled if empty)")
+	rootCmd.PersistentFlags().StringVar(&archiveS3Prefix, "archive.s3.prefix", "", "Key prefix of the archive files in the S3 bucket, e.g. nature-remo/")
+	rootCmd.PersistentFlags().StringVar(&archiveS3Region, "archive.s3.region", "", "AWS region of the S3 bucket (default AWS_REGION)")
+	rootCmd.PersistentFlags().StringVar(&archiveS3Endpoint, "archive.s3.endpoint", "", "URL of an S3-compatible storage such as MinIO instead of Amazon S3; the bucket is addressed in the path style")
+	rootCmd.PersistentFlags().DurationVar(&archiveS3Retention, "archive.s3.retention", 0, "Retention of the archive files in the S3 bucket by their days (0 to keep all)")
+	rootCmd.PersistentFlags().DurationVar(&archiveS3Timeout, "archive.s3.timeout", 5*time.Minute, "Timeout of uploading the archive files to S3, which runs in the background")
+	rootCmd.PersistentFlags().StringVar(&pushgatewayURL, "pushgateway.url", "", "URL of the Pushgateway to push the metrics to after each poll (disabled if empty)")
+	rootCmd.PersistentFlags().StringVar(&pushgatewayJob, "pushgateway.job", "nature_remo_exporter", "Job label of the metrics pushed to the Pushgateway")
+	rootCmd.PersistentFlags().StringVar(&pushgatewayInstance, "pushgateway.instance", "", "Instance label of the metrics pushed to the Pushgateway (default the hostname)")
+	rootCmd.PersistentFlags().StringToStringVar(&pushgatewayGrouping, "pushgateway.grouping", nil, "Additional grouping labels of the metrics pushed to the Pushgateway, e.g. site=home")
+	rootCmd.PersistentFlags().StringVar(&influxDBURL, "influxdb.url", "", "URL of InfluxDB v2 to write the readings to after each poll, e.g. http://localhost:8086 (disabled if empty)")
+	rootCmd.PersistentFlags().StringVar(&influxDBOrg, "influxdb.org", "", "Organization of InfluxDB to write the readings to")
+	rootCmd.PersistentFlags().StringVar(&influxDBBucket, "influxdb.bucket", "", "Bucket of InfluxDB to write the readings to")
+	rootCmd.PersistentFlags().StringVar(&influxDBToken, "influxdb.token", "", "API token of InfluxDB (also "+influxDBTokenEnv+")")
+	rootCmd.PersistentFlags().StringVar(&influxDBMeasurement, "influxdb.measurement", "nature_remo", "Measurement of the readings written to InfluxDB")
+	rootCmd.PersistentFlags().StringVar(&victoriaMetricsURL, "victoriametrics.url", "", "URL of VictoriaMetrics to import the readings to with the timestamps of the sensors after each poll, e.g. http://localhost:8428 (disabled if empty)")
+	rootCmd.PersistentFlags().StringToStringVar(&victoriaMetricsExtraLabels, "victoriametrics.extra-labels", nil, "Additional labels of the readings imported to VictoriaMetrics, e.g. site=home")
+	rootCmd.PersistentFlags().StringVar(&graphiteAddress, "graphite.address", "", "Address of Carbon to send the readings to in the plaintext protocol after each poll, e.g. localhost:2003 (disabled if empty)")
+	rootCmd.PersistentFlags().StringVar(&graphitePathTemplate, "graphite.path-template", "nature_remo.{{.DeviceName}}.{{.Metric}}", "Go template of the Graphite path of the readings, with .Metric, .DeviceID and .DeviceName")
+	rootCmd.PersistentFlags().StringVar(&statsdAddress, "statsd.address", "", "UDP address of StatsD to send the readings to as gauges after each poll, e.g. localhost:8125, or unix:/path/to/socket (disabled if empty)")
+	rootCmd.PersistentFlags().StringVar(&statsdPrefix, "statsd.prefix", "nature_remo.", "Prefix of the StatsD metric names")
+	rootCmd.PersistentFlags().StringVar(&statsdTagsFormat, "statsd.tags-format", "dogstatsd", "Format of the StatsD tags (dogstatsd, none)")
+	rootCmd.PersistentFlags().StringSliceVar(&statsdTags, "statsd.tags", nil, "Additional tags of the StatsD metrics, e.g. env:home")
+	rootCmd.PersistentFlags().StringVar(&mqttBroker, "mqtt.broker", "", "URL of the MQTT broker to publish the readings to after each poll, e.g. tcp://localhost:1883 (disabled if empty)")
+	rootCmd.PersistentFlags().StringVar(&mqttClientID, "mqtt.client-id", "nature-remo-exporter", "Client ID of the MQTT connection")
+	rootCmd.PersistentFlags().StringVar(&mqttUsername, "mqtt.username", "", "Username of the MQTT broker")
+	rootCmd.PersistentFlags().StringVar(&mqttPassword, "mqtt.password", "", "Password of the MQTT broker (also "+mqttPasswordEnv+")")
+	rootCmd.PersistentFlags().StringVar(&mqttTopicTemplate, "mqtt.topic-template", "nature_remo/{{.DeviceName}}/{{.Metric}}", "Go template of the MQTT topic of the readings, with .Metric, .DeviceID and .DeviceName")
+	rootCmd.PersistentFlags().IntVar(&mqttQoS, "mqtt.qos", 0, "QoS of the MQTT messages (0, 1, 2)")
+	rootCmd.PersistentFlags().BoolVar(&mqttRetain, "mqtt.retain", false, "Publish the MQTT messages as retained messages, so that new subscribers get the latest readings")
+	rootCmd.PersistentFlags().BoolVar(&mqttHomeAssistantDiscovery, "mqtt.homeassistant-discovery", false, "Publish the Home Assistant MQTT discovery messages, so that the sensors appear as entities of Home Assistant")
+	rootCmd.PersistentFlags().StringVar(&mqttHomeAssistantPrefix, "mqtt.homeassistant-prefix", "homeassistant", "Topic prefix of the Home Assistant MQTT discovery")
+	rootCmd.PersistentFlags().StringSliceVar(&kafkaBrokers, "kafka.brokers", nil, "Bootstrap brokers of Kafka to produce the readings to as JSON messages after each poll, e.g. localhost:9092 (disabled if empty)")
+	rootCmd.PersistentFlags().StringVar(&kafkaTopic, "kafka.topic", "nature-remo-readings", "Kafka topic of the readings")
+	rootCmd.PersistentFlags().StringVar(&kafkaClientID, "kafka.client-id", "nature-remo-exporter", "Client ID of the Kafka producer")
+	rootCmd.PersistentFlags().BoolVar(&kafkaTLS, "kafka.tls", false, "Connect to the Kafka brokers with TLS")
+	rootCmd.PersistentFlags().StringVar(&kafkaSASLUsername, "kafka.sasl-username", "", "Username of SASL/PLAIN authentication of Kafka, which requires --kafka.tls (disabled if empty)")
+	rootCmd.PersistentFlags().StringVar(&kafkaSASLPassword, "kafka.sasl-password", "", "Password of SASL/PLAIN authentication of Kafka (also "+kafkaSASLPasswordEnv+")")
+	rootCmd.PersistentFlags().StringVar(&cloudWatchNamespace, "cloudwatch.namespace", "", "Namespace of Amazon CloudWatch to put the readings to after each poll, with the default credential chain of the AWS SDK (disabled if empty)")
+	rootCmd.PersistentFlags().StringVar(&cloudWatchRegion, "cloudwatch.region", "", "AWS region of CloudWatch (default AWS_REGION)")
+	rootCmd.PersistentFlags().StringVar(&cloudWatchEndpoint, "cloudwatch.endpoint", "", "URL of the CloudWatch API instead of the one of the region, e.g. for LocalStack")
+	rootCmd.PersistentFlags().StringVar(&datadogSite, "datadog.site", "", "Site of Datadog to submit the readings to with the metrics API after each poll, e.g. datadoghq.com or datadoghq.eu (disabled if empty)")
+	rootCmd.PersistentFlags().StringVar(&datadogAPIKey, "datadog.api-key", "", "API key of Datadog (also "+datadogAPIKeyEnv+")")
+	rootCmd.PersistentFlags().StringVar(&datadogPrefix, "datadog.prefix", "nature_remo.", "Prefix of the Datadog metric names")
+	rootCmd.PersistentFlags().StringSliceVar(&datadogTags, "datadog.tags", nil, "Additional tags of the Datadog metrics, e.g. env:home")
+	rootCmd.PersistentFlags().StringVar(&tracingEndpoint, "tracing.endpoint", "", "OTLP/HTTP endpoint to export the traces to, e.g. http://localhost:4318 (also OTEL_EXPORTER_OTLP_ENDPOINT; disabled if empty)")
+	rootCmd.PersistentFlags().Float64Var(&tracingSamplingRatio, "tracing.sampling-ratio", 1, "Ratio of the polls to trace, from 0 to 1")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log.level", "info", "Log level (debug, info, warn, error)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log.format", "json", "Log format (json, text)")
+	rootCmd.PersistentFlags().DurationVar(&errorSummaryInterval, "log.error-summary-interval", 10*time.Minute, "Interval to log a summary of repeated errors instead of each of them (0 to log all errors)")
+	rootCmd.PersistentFlags().StringVar(&logBackend, "log.backend", "stdout", "Log backend (stdout, syslog, journald, eventlog)")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log.file", "", "File to write logs to instead of stdout")
+	rootCmd.PersistentFlags().Int64Var(&logFileMaxSize, "log.file.max-size", 100, "Maximum size of the log file in megabytes before it is rotated (0 to disable)")
+	rootCmd.PersistentFlags().DurationVar(&logFileMaxAge, "log.file.max-age", 0, "Maximum age of the log file before it is rotated (0 to disable)")
+	rootCmd.PersistentFlags().IntVar(&logFileMaxBackups, "log.file.max-backups", 5, "Maximum number of rotated log files to keep (0 to keep all)")
 }
@@ -0,0 +1,158 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"text/template"
+	"time"
+
+	"github.com/imishinist/nature-remo-exporter/pkg/collector"
+	"github.com/prometheus/common/model"
+	"github.com/spf13/cobra"
+)
+
+var rulesTemplate = template.Must(template.New("rules").Funcs(template.FuncMap{
+	"duration": func(d time.Duration) string { return model.Duration(d).String() },
+}).Parse(`groups:
+  - name: nature-remo
+    rules:
+      - alert: NatureRemoExporterDown
+        expr: up{job="{{ .Job }}"} == 0
+        for: 5m
+        labels:
+          severity: critical
+        annotations:
+          summary: "Nature Remo exporter {{ "{{ $labels.instance }}" }} is down"
+      # nature_remo_up is 0 from the first failed poll, unlike the counter of the successful calls
+      - alert: NatureRemoAPIErrors
+        expr: max_over_time(nature_remo_up{job="{{ .Job }}"}[{{ duration .APIErrorsFor }}]) == 0
+        labels:
+          severity: warning
+        annotations:
+          summary: "Nature Remo exporter {{ "{{ $labels.instance }}" }} has not polled the API successfully for {{ duration .APIErrorsFor }}"
+          description: "Check the logs of the exporter for API errors, e.g. an invalid token or rate limiting."
+      # each sensor is checked on its own, since some devices such as Remo mini have no humidity sensor
+      - alert: NatureRemoDataStale
+        expr: changes(nature_remo_temperature{job="{{ .Job }}"}[{{ duration .StaleAfter }}]) == 0 or changes(nature_remo_humidity{job="{{ .Job }}"}[{{ duration .StaleAfter }}]) == 0
+        labels:
+          severity: warning
+        annotations:
+          summary: "Sensor values of {{ "{{ $labels.name }}" }} have not changed for {{ duration .StaleAfter }}"
+      # every device has the temperature sensor, and the other sensors only keep it online if they have changed
+      - alert: NatureRemoDeviceOffline
+        expr: changes(nature_remo_temperature{job="{{ .Job }}"}[{{ duration .OfflineAfter }}]) == 0 unless changes(nature_remo_humidity{job="{{ .Job }}"}[{{ duration .OfflineAfter }}]) > 0 unless changes(nature_remo_illumination{job="{{ .Job }}"}[{{ duration .OfflineAfter }}]) > 0
+        labels:
+          severity: critical
+        annotations:
+          summary: "{{ "{{ $labels.name }}" }} seems to be offline"
+          description: "No sensor values have changed for {{ duration .OfflineAfter }}. Check the power supply and Wi-Fi connection of the device."
+      - alert: NatureRemoTemperatureHigh
+        expr: nature_remo_temperature{job="{{ .Job }}"} > {{ .TemperatureHigh }}
+        for: {{ duration .TemperatureFor }}
+        labels:
+          severity: warning
+        annotations:
+          summary: "Temperature at {{ "{{ $labels.name }}" }} is {{ "{{ $value }}" }}°C"
+      - alert: NatureRemoTemperatureLow
+        expr: nature_remo_temperature{job="{{ .Job }}"} < {{ .TemperatureLow }}
+        for: {{ duration .TemperatureFor }}
+        labels:
+          severity: warning
+        annotations:
+          summary: "Temperature at {{ "{{ $labels.name }}" }} is {{ "{{ $value }}" }}°C"
+`))
+
+// legacyMetricNames matches the names of the metrics renamed by --metrics.conformance.
+var legacyMetricNames = regexp.MustCompile(`\bnature_remo_(temperature|humidity|movements_total)\b`)
+
+// conformMetricNames renames the metrics in the PromQL expressions by --metrics.conformance,
+// so that the generated rules and dashboards query the metrics exported by the same flags.
+func conformMetricNames(s string) string {
+	if !metricsConformance {
+		return s
+	}
+	return legacyMetricNames.ReplaceAllStringFunc(s, func(name string) string {
+		return collector.ConformanceNames[name]
+	})
+}
+
+// rulesConfig is the parameters of the generated alerting rules.
+type rulesConfig struct {
+	Job             string
+	APIErrorsFor    time.Duration
+	StaleAfter      time.Duration
+	OfflineAfter    time.Duration
+	TemperatureHigh float64
+	TemperatureLow  float64
+	TemperatureFor  time.Duration
+}
+
+var (
+	rules       rulesConfig
+	rulesOutput string
+
+	// rulesCmd represents the rules command
+	rulesCmd = &cobra.Command{
+		Use:   "rules",
+		Short: "Manage Prometheus alerting rules",
+	}
+
+	// rulesGenerateCmd represents the rules generate command
+	rulesGenerateCmd = &cobra.Command{
+		Use:   "generate",
+		Short: "Generate Prometheus alerting rules",
+		Long: `Generate prints example Prometheus alerting rules for the exporter:
+exporter down, API errors, data staleness, device offline and temperature thresholds.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var w io.Writer = cmd.OutOrStdout()
+			if rulesOutput != "" && rulesOutput != "-" {
+				f, err := os.Create(rulesOutput)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			var buf bytes.Buffer
+			if err := rulesTemplate.Execute(&buf, rules); err != nil {
+				return err
+			}
+			_, err := io.WriteString(w, conformMetricNames(buf.String()))
+			return err
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(rulesCmd)
+	rulesCmd.AddCommand(rulesGenerateCmd)
+
+	rulesGenerateCmd.Flags().StringVar(&rules.Job, "job", "nature-remo", "Job name of the exporter in the scrape config")
+	rulesGenerateCmd.Flags().DurationVar(&rules.APIErrorsFor, "api-errors-for", 15*time.Minute, "Period without successful polls to alert")
+	rulesGenerateCmd.Flags().DurationVar(&rules.StaleAfter, "stale-after", time.Hour, "Period without value changes of a sensor to alert as stale")
+	rulesGenerateCmd.Flags().DurationVar(&rules.OfflineAfter, "offline-after", 6*time.Hour, "Period without sensor value changes to alert as offline")
+	rulesGenerateCmd.Flags().Float64Var(&rules.TemperatureHigh, "temperature-high", 30, "Temperature threshold to alert as too high")
+	rulesGenerateCmd.Flags().Float64Var(&rules.TemperatureLow, "temperature-low", 10, "Temperature threshold to alert as too low")
+	rulesGenerateCmd.Flags().DurationVar(&rules.TemperatureFor, "temperature-for", 10*time.Minute, "Period the temperature threshold must be exceeded to alert")
+	rulesGenerateCmd.Flags().StringVarP(&rulesOutput, "output", "o", "-", "File to write the rules to, or - for stdout")
+}
@@ -0,0 +1,44 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/imishinist/nature-remo-exporter/pkg/collector"
+)
+
+func TestConformMetricNames(t *testing.T) {
+	defer func(v bool) { metricsConformance = v }(metricsConformance)
+
+	for old, renamed := range collector.ConformanceNames {
+		expr := "rate(" + old + `{job="nature-remo"}[5m])`
+		metricsConformance = false
+		if got := conformMetricNames(expr); got != expr {
+			t.Errorf("conformMetricNames(%q) = %q without conformance, want unchanged", expr, got)
+		}
+		metricsConformance = true
+		if got, want := conformMetricNames(expr), "rate("+renamed+`{job="nature-remo"}[5m])`; got != want {
+			t.Errorf("conformMetricNames(%q) = %q, want %q", expr, got, want)
+		}
+	}
+	// the metrics sharing the prefix are not renamed
+	metricsConformance = true
+	if expr := "nature_remo_temperature_trend"; conformMetricNames(expr) != expr {
+		t.Errorf("conformMetricNames(%q) = %q, want unchanged", expr, conformMetricNames(expr))
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// s3Client is a client of the objects in a bucket of Amazon S3 or an S3-compatible storage.
+type s3Client struct {
+	client *http.Client
+	// base is the URL of the bucket, to which the escaped keys are appended.
+	base   string
+	signer *awsSigner
+}
+
+// newS3Client creates a client of the bucket.
+// The bucket is addressed in the path style on the endpoint if given, as the S3-compatible storages support it,
+// or in the virtual-hosted style on Amazon S3 otherwise.
+func newS3Client(ctx context.Context, bucket, region, endpoint string) (*s3Client, error) {
+	cfg, err := loadAWSConfig(ctx, region)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials of S3: %v", err)
+	}
+	region = cfg.Region
+	if region == "" {
+		if endpoint == "" {
+			return nil, fmt.Errorf("region of S3 is required")
+		}
+		// most S3-compatible storages accept any region
+		region = "us-east-1"
+	}
+	base := "https://" + bucket + ".s3." + region + ".amazonaws.com/"
+	if endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3 endpoint: %v", err)
+		}
+		base = strings.TrimSuffix(u.String(), "/") + "/" + awsEscape(bucket) + "/"
+	}
+	// the keys are escaped by objectURL, so the path is signed as is like the SDK of S3 does
+	signer := newAWSSigner(cfg.Credentials, region, "s3", func(o *v4.SignerOptions) {
+		o.DisableURIPathEscaping = true
+	})
+	return &s3Client{client: &http.Client{}, base: base, signer: signer}, nil
+}
+
+// s3Object is an object in the listing of a bucket.
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// Put uploads the object.
+func (c *s3Client) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	_, err := c.do(ctx, http.MethodPut, c.objectURL(key), body, contentType)
+	return err
+}
+
+// Delete deletes the object.
+func (c *s3Client) Delete(ctx context.Context, key string) error {
+	_, err := c.do(ctx, http.MethodDelete, c.objectURL(key), nil, "")
+	return err
+}
+
+// List returns all objects with the prefix by ListObjectsV2.
+func (c *s3Client) List(ctx context.Context, prefix string) ([]s3Object, error) {
+	var objects []s3Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		body, err := c.do(ctx, http.MethodGet, c.base+"?"+query.Encode(), nil, "")
+		if err != nil {
+			return nil, err
+		}
+		var res struct {
+			Contents              []s3Object `xml:"Contents"`
+			IsTruncated           bool       `xml:"IsTruncated"`
+			NextContinuationToken string     `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(body, &res); err != nil {
+			return nil, fmt.Errorf("invalid response of ListObjectsV2: %v", err)
+		}
+		objects = append(objects, res.Contents...)
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = res.NextContinuationToken
+	}
+}
+
+// objectURL returns the URL of the key, whose path is escaped as required by the signature.
+func (c *s3Client) objectURL(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+	}
+	return c.base + strings.Join(segments, "/")
+}
+
+func (c *s3Client) do(ctx context.Context, method, rawURL string, body []byte, contentType string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	hash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(hash[:]))
+	if err := c.signer.Sign(req, body); err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	res, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		if len(res) > 1024 {
+			res = res[:1024]
+		}
+		return nil, fmt.Errorf("S3 returned %s: %s", resp.Status, bytes.TrimSpace(res))
+	}
+	return res, nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestS3ClientPut(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	defer srv.Close()
+
+	// the credentials of a role are refreshed, so they are retrieved for each request
+	retrieved := 0
+	credentials := aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		retrieved++
+		return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token"}, nil
+	})
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	c, err := newS3Client(context.Background(), "backup", "ap-northeast-1", srv.URL)
+	if err != nil {
+		t.Fatalf("newS3Client() error = %v", err)
+	}
+	c.signer.credentials = credentials
+
+	for i := 0; i < 2; i++ {
+		if err := c.Put(context.Background(), "nature-remo/2024-01-02 a+b.jsonl", []byte("{}\n"), "application/x-ndjson"); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	if retrieved != 2 {
+		t.Errorf("credentials retrieved %d times, want 2", retrieved)
+	}
+	if want := "/backup/nature-remo/2024-01-02%20a%2Bb.jsonl"; got.URL.EscapedPath() != want {
+		t.Errorf("path = %s, want %s", got.URL.EscapedPath(), want)
+	}
+	auth := regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=AKID/\d{8}/ap-northeast-1/s3/aws4_request, SignedHeaders=\S*content-type;\S*x-amz-content-sha256;x-amz-date;x-amz-security-token, Signature=[0-9a-f]{64}$`)
+	if !auth.MatchString(got.Header.Get("Authorization")) {
+		t.Errorf("Authorization = %s", got.Header.Get("Authorization"))
+	}
+	if got.Header.Get("X-Amz-Security-Token") != "token" {
+		t.Errorf("X-Amz-Security-Token = %q, want token", got.Header.Get("X-Amz-Security-Token"))
+	}
+}
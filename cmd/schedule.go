@@ -0,0 +1,183 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/tenntenn/natureremo"
+	"gopkg.in/yaml.v3"
+)
+
+var devicesConfigFile string
+
+// devicesConfig is the configuration of the intervals and the local addresses of each device.
+type devicesConfig struct {
+	Devices []deviceConfig `yaml:"devices"`
+}
+
+type deviceConfig struct {
+	// Device is the ID or the name of the device.
+	Device   string        `yaml:"device"`
+	Interval time.Duration `yaml:"interval"`
+	// Address is the host and the optional port of the device on the LAN to probe the reachability of.
+	Address string `yaml:"address"`
+}
+
+// loadDevicesConfig loads the devices config file.
+func loadDevicesConfig(path string) (*devicesConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read devices config file: %v", err)
+	}
+	cfg := &devicesConfig{}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse devices config file: %v", err)
+	}
+	for _, d := range cfg.Devices {
+		if d.Device == "" {
+			return nil, fmt.Errorf("device without an ID or a name in devices config file")
+		}
+		if d.Interval < 0 {
+			return nil, fmt.Errorf("interval of device %q must not be negative", d.Device)
+		}
+		if d.Interval == 0 && d.Address == "" {
+			return nil, fmt.Errorf("device %q needs an interval or an address", d.Device)
+		}
+	}
+	return cfg, nil
+}
+
+// Addresses returns the local addresses of the devices which have them, by the ID or the name in the file.
+func (c *devicesConfig) Addresses() map[string]string {
+	addrs := make(map[string]string)
+	for _, d := range c.Devices {
+		if d.Address != "" {
+			addrs[d.Device] = d.Address
+		}
+	}
+	return addrs
+}
+
+// deviceScheduler decides which devices are updated by each poll by their own intervals.
+// All devices are fetched by a call of the API, so the polls run at the shortest interval,
+// and the other devices are updated by some of them.
+type deviceScheduler struct {
+	defaultInterval time.Duration
+	pollInterval    time.Duration
+	intervals       map[string]time.Duration
+	updatedAt       map[string]time.Time
+}
+
+// newDeviceScheduler creates a scheduler of the intervals in the config.
+// The devices without their intervals are updated at the default interval.
+func newDeviceScheduler(cfg *devicesConfig, defaultInterval time.Duration) *deviceScheduler {
+	s := &deviceScheduler{
+		defaultInterval: defaultInterval,
+		pollInterval:    defaultInterval,
+		intervals:       make(map[string]time.Duration),
+		updatedAt:       make(map[string]time.Time),
+	}
+	for _, d := range cfg.Devices {
+		if d.Interval == 0 {
+			continue
+		}
+		s.intervals[d.Device] = d.Interval
+		s.pollInterval = min(s.pollInterval, d.Interval)
+	}
+	return s
+}
+
+// validateInterval returns an error if the polls cannot be scheduled by the interval and the jitter.
+func validateInterval(interval time.Duration, jitter float64) error {
+	if interval <= 0 {
+		return fmt.Errorf("--interval must be positive: %s", interval)
+	}
+	if jitter < 0 || jitter >= 1 {
+		return fmt.Errorf("--interval-jitter must be at least 0 and less than 1: %v", jitter)
+	}
+	return nil
+}
+
+// tickSchedule schedules the polls at the cadence of the period, randomizing each tick around it
+// so that the jitter does not accumulate. The ticks missed by a slow poll are skipped like time.Ticker.
+type tickSchedule struct {
+	tick   time.Time
+	jitter float64
+}
+
+// next returns the delay from now until the next tick after the period, which must be positive.
+func (s *tickSchedule) next(period time.Duration, now time.Time) time.Duration {
+	s.tick = s.tick.Add(period)
+	for s.tick.Before(now) {
+		s.tick = s.tick.Add(period)
+	}
+	return s.tick.Sub(now) + jitter(period, s.jitter) - period
+}
+
+// PollInterval returns the interval to fetch the devices at.
+func (s *deviceScheduler) PollInterval() time.Duration {
+	return s.pollInterval
+}
+
+func (s *deviceScheduler) intervalOf(device *natureremo.Device) time.Duration {
+	if d, ok := s.intervals[device.ID]; ok {
+		return d
+	}
+	if d, ok := s.intervals[device.Name]; ok {
+		return d
+	}
+	return s.defaultInterval
+}
+
+// Intervals returns the interval of each device in the config.
+func (s *deviceScheduler) Intervals() map[string]string {
+	intervals := make(map[string]string, len(s.intervals))
+	for device, d := range s.intervals {
+		intervals[device] = d.String()
+	}
+	return intervals
+}
+
+// UpdatedAt returns a copy of the last update of each device by its ID.
+func (s *deviceScheduler) UpdatedAt() map[string]time.Time {
+	updatedAt := make(map[string]time.Time, len(s.updatedAt))
+	for id, t := range s.updatedAt {
+		updatedAt[id] = t
+	}
+	return updatedAt
+}
+
+// Due returns the devices to update by the poll at now, and records that they are updated.
+// Half a poll interval is tolerated not to miss a poll by the delay or the jitter of its tick.
+func (s *deviceScheduler) Due(devices []*natureremo.Device, now time.Time) []*natureremo.Device {
+	var due []*natureremo.Device
+	for _, device := range devices {
+		if last, ok := s.updatedAt[device.ID]; ok && now.Before(last.Add(s.intervalOf(device)-s.pollInterval/2)) {
+			continue
+		}
+		s.updatedAt[device.ID] = now
+		due = append(due, device)
+	}
+	return due
+}
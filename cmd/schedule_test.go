@@ -0,0 +1,83 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		jitter   float64
+		wantErr  bool
+	}{
+		{name: "default", interval: 30 * time.Second},
+		{name: "jitter", interval: 30 * time.Second, jitter: 0.5},
+		{name: "zero interval", interval: 0, wantErr: true},
+		{name: "negative interval", interval: -time.Second, wantErr: true},
+		{name: "negative jitter", interval: 30 * time.Second, jitter: -0.1, wantErr: true},
+		{name: "jitter of the whole interval", interval: 30 * time.Second, jitter: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateInterval(tt.interval, tt.jitter); (err != nil) != tt.wantErr {
+				t.Errorf("validateInterval() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTickSchedule(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &tickSchedule{tick: t0}
+
+	// a poll which took 2s does not delay the next tick
+	if got := s.next(30*time.Second, t0.Add(2*time.Second)); got != 28*time.Second {
+		t.Errorf("next() = %s, want 28s", got)
+	}
+	// the ticks missed by a slow poll are skipped
+	if got := s.next(30*time.Second, t0.Add(95*time.Second)); got != 25*time.Second {
+		t.Errorf("next() after a slow poll = %s, want 25s", got)
+	}
+	if !s.tick.Equal(t0.Add(2 * time.Minute)) {
+		t.Errorf("tick = %s, want %s", s.tick, t0.Add(2*time.Minute))
+	}
+	// a stretched period moves the cadence
+	if got := s.next(time.Minute, t0.Add(2*time.Minute)); got != time.Minute {
+		t.Errorf("next() of a stretched period = %s, want 1m", got)
+	}
+}
+
+func TestTickScheduleJitter(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &tickSchedule{tick: t0, jitter: 0.1}
+	now := t0
+	for i := 1; i <= 100; i++ {
+		got := s.next(30*time.Second, now)
+		if got < 27*time.Second || got > 33*time.Second {
+			t.Fatalf("next() = %s, want within 30s±10%%", got)
+		}
+		// the jitter does not accumulate
+		if want := t0.Add(time.Duration(i) * 30 * time.Second); !s.tick.Equal(want) {
+			t.Fatalf("tick = %s, want %s", s.tick, want)
+		}
+		now = s.tick
+	}
+}
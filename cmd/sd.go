@@ -0,0 +1,95 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// sdTargetGroup is a target group of the Prometheus HTTP service discovery.
+type sdTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// SDHandler serves one target per device in the format of the Prometheus HTTP service discovery.
+// The target is the exporter itself as requested, and the device is passed to /probe by __param_target.
+func (s *snapshot) SDHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if s.updatedAt.IsZero() {
+			http.Error(w, "no successful update yet", http.StatusServiceUnavailable)
+			return
+		}
+
+		groups := make([]sdTargetGroup, 0, len(s.devices))
+		for _, device := range s.devices {
+			groups = append(groups, sdTargetGroup{
+				Targets: []string{r.Host},
+				Labels: map[string]string{
+					"__param_target":                             device.ID,
+					"__meta_nature_remo_device_id":               device.ID,
+					"__meta_nature_remo_device_name":             device.Name,
+					"__meta_nature_remo_device_firmware_version": device.FirmwareVersion,
+					"__meta_nature_remo_device_serial_number":    device.SerialNumber,
+				},
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(groups)
+	})
+}
+
+// probeHandler serves the metrics of the device given by the target parameter.
+// The metrics without the id label, such as the process metrics, are excluded.
+func probeHandler(g prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+
+		filtered := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			mfs, err := g.Gather()
+			var out []*dto.MetricFamily
+			for _, mf := range mfs {
+				var metrics []*dto.Metric
+				for _, m := range mf.GetMetric() {
+					for _, l := range m.GetLabel() {
+						if l.GetName() == "id" && l.GetValue() == target {
+							metrics = append(metrics, m)
+							break
+						}
+					}
+				}
+				if len(metrics) > 0 {
+					mf.Metric = metrics
+					out = append(out, mf)
+				}
+			}
+			return out, err
+		})
+		promhttp.HandlerFor(filtered, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,180 @@
+//go:build windows
+
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceName is the name of the Windows service and the source of the event log.
+const serviceName = "nature-remo-exporter"
+
+// exporterService runs the root command as a Windows service.
+type exporterService struct{}
+
+func (s *exporterService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- rootCmd.ExecuteContext(ctx)
+	}()
+
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case err := <-errCh:
+			if err != nil {
+				return false, 1
+			}
+			return false, 0
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				// shut down gracefully in the same way as SIGTERM
+				cancel()
+				if err := <-errCh; err != nil {
+					return false, 1
+				}
+				return false, 0
+			}
+		}
+	}
+}
+
+// runAsService runs the exporter as a Windows service if the process is started by the service control manager.
+// It returns false if the process is not a service.
+func runAsService() bool {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false
+	}
+
+	// log to the event log unless --log.backend is given
+	rootCmd.PersistentFlags().Set("log.backend", "eventlog")
+	if err := svc.Run(serviceName, &exporterService{}); err != nil {
+		os.Exit(1)
+	}
+	return true
+}
+
+var (
+	// serviceCmd represents the service command
+	serviceCmd = &cobra.Command{
+		Use:   "service",
+		Short: "Manage the Windows service",
+	}
+
+	// serviceInstallCmd represents the service install command
+	serviceInstallCmd = &cobra.Command{
+		Use:   "install [flags for the exporter]",
+		Short: "Install the exporter as a Windows service",
+		Long: `Install registers the exporter as a Windows service which starts automatically,
+and registers the event log source for its logs.
+
+The arguments after -- are passed to the exporter when the service starts.`,
+		Example:      `  nature-remo-exporter service install -- --token <access token> --web.listen-address :9199`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			exe, err := os.Executable()
+			if err != nil {
+				return err
+			}
+			exe, err = filepath.Abs(exe)
+			if err != nil {
+				return err
+			}
+
+			m, err := mgr.Connect()
+			if err != nil {
+				return fmt.Errorf("failed to connect to the service control manager: %v", err)
+			}
+			defer m.Disconnect()
+
+			if s, err := m.OpenService(serviceName); err == nil {
+				s.Close()
+				return fmt.Errorf("service %s already exists", serviceName)
+			}
+			s, err := m.CreateService(serviceName, exe, mgr.Config{
+				DisplayName: "Nature Remo Exporter",
+				Description: "Prometheus exporter for Nature Remo",
+				StartType:   mgr.StartAutomatic,
+			}, args...)
+			if err != nil {
+				return fmt.Errorf("failed to create service: %v", err)
+			}
+			defer s.Close()
+
+			if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+				s.Delete()
+				return fmt.Errorf("failed to install event log source: %v", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "service %s installed\n", serviceName)
+			return nil
+		},
+	}
+
+	// serviceUninstallCmd represents the service uninstall command
+	serviceUninstallCmd = &cobra.Command{
+		Use:          "uninstall",
+		Short:        "Uninstall the Windows service",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := mgr.Connect()
+			if err != nil {
+				return fmt.Errorf("failed to connect to the service control manager: %v", err)
+			}
+			defer m.Disconnect()
+
+			s, err := m.OpenService(serviceName)
+			if err != nil {
+				return fmt.Errorf("service %s is not installed", serviceName)
+			}
+			defer s.Close()
+			if err := s.Delete(); err != nil {
+				return fmt.Errorf("failed to delete service: %v", err)
+			}
+			if err := eventlog.Remove(serviceName); err != nil {
+				return fmt.Errorf("failed to remove event log source: %v", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "service %s uninstalled\n", serviceName)
+			return nil
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(serviceCmd)
+	serviceCmd.AddCommand(serviceInstallCmd)
+	serviceCmd.AddCommand(serviceUninstallCmd)
+}
@@ -0,0 +1,193 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tenntenn/natureremo"
+)
+
+var (
+	pushTimeout       time.Duration
+	pushBufferDir     string
+	pushBufferMaxSize int64
+)
+
+// sample is a sensor value pushed to the sinks.
+type sample struct {
+	// Metric is the name of the sensor, such as temperature.
+	Metric     string    `json:"metric"`
+	DeviceID   string    `json:"device_id"`
+	DeviceName string    `json:"device_name"`
+	Value      float64   `json:"value"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// samplesOf returns the newest sensor values of the devices.
+func samplesOf(devices []*natureremo.Device) []sample {
+	var samples []sample
+	for _, device := range devices {
+		for _, name := range sensorNames() {
+			value, ok := device.NewestEvents[sensorTypes[name]]
+			if !ok {
+				continue
+			}
+			samples = append(samples, sample{
+				Metric:     name,
+				DeviceID:   device.ID,
+				DeviceName: device.Name,
+				Value:      value.Value,
+				Timestamp:  value.CreatedAt,
+			})
+		}
+	}
+	return samples
+}
+
+// sink is an output which the samples are pushed to after each poll.
+type sink interface {
+	// Name identifies the sink in logs and in the buffer directory.
+	Name() string
+	Write(ctx context.Context, samples []sample) error
+}
+
+// newSinks creates the sinks enabled by the flags.
+// The sinks of the samples are buffered on disk while their backends are unreachable if --push.buffer-dir is set.
+func newSinks(ctx context.Context, logger *slog.Logger, g prometheus.Gatherer) ([]sink, error) {
+	var sinks []sink
+	if influxDBURL != "" {
+		s, err := newInfluxDBSink(influxDBURL, influxDBOrg, influxDBBucket, influxDBToken, influxDBMeasurement)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if victoriaMetricsURL != "" {
+		s, err := newVictoriaMetricsSink(victoriaMetricsURL, victoriaMetricsExtraLabels)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if graphiteAddress != "" {
+		s, err := newGraphiteSink(graphiteAddress, graphitePathTemplate)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if statsdAddress != "" {
+		s, err := newStatsDSink(statsdAddress, statsdPrefix, statsdTagsFormat, statsdTags)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if mqttBroker != "" {
+		discovery := ""
+		if mqttHomeAssistantDiscovery {
+			discovery = mqttHomeAssistantPrefix
+		}
+		s, err := newMQTTSink(mqttBroker, mqttClientID, mqttUsername, mqttPassword, mqttTopicTemplate, mqttQoS, mqttRetain, discovery)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if len(kafkaBrokers) > 0 {
+		s, err := newKafkaSink(kafkaBrokers, kafkaTopic, kafkaClientID, kafkaTLS, kafkaSASLUsername, kafkaSASLPassword)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cloudWatchNamespace != "" {
+		s, err := newCloudWatchSink(ctx, cloudWatchNamespace, cloudWatchRegion, cloudWatchEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if datadogSite != "" {
+		s, err := newDatadogSink(datadogSite, datadogAPIKey, datadogPrefix, datadogTags)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+
+	if pushBufferDir != "" {
+		for i, s := range sinks {
+			b, err := newBufferedSink(s, pushBufferDir, pushBufferMaxSize*1024*1024, logger)
+			if err != nil {
+				return nil, err
+			}
+			sinks[i] = b
+		}
+	}
+
+	// the archive is written to the local disk, so it is not buffered
+	if archiveDir != "" {
+		s, err := newArchiveSink(archiveDir, archiveFormat, archiveRetention)
+		if err != nil {
+			return nil, err
+		}
+		if archiveS3Bucket != "" {
+			client, err := newS3Client(ctx, archiveS3Bucket, archiveS3Region, archiveS3Endpoint)
+			if err != nil {
+				return nil, err
+			}
+			s.uploadTo(client, archiveS3Prefix, archiveS3Retention, archiveS3Timeout, logger)
+		}
+		sinks = append(sinks, s)
+	}
+	// the Pushgateway only keeps the latest metrics, so buffering them makes no sense
+	if pushgatewayURL != "" {
+		s, err := newPushgatewaySink(pushgatewayURL, pushgatewayJob, pushgatewayInstance, pushgatewayGrouping, g)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
+// closeSinks closes the sinks which hold connections.
+func closeSinks(sinks []sink) {
+	for _, s := range sinks {
+		if c, ok := s.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+// pushSamples writes the samples to all sinks. The errors are logged and do not affect the others.
+func pushSamples(ctx context.Context, logger *slog.Logger, sinks []sink, samples []sample) {
+	for _, s := range sinks {
+		ctx, cancel := context.WithTimeout(ctx, pushTimeout)
+		if err := s.Write(ctx, samples); err != nil {
+			logger.Error(fmt.Sprintf("failed to push to %s: %v", s.Name(), err), slog.String("sink", s.Name()))
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tenntenn/natureremo"
+)
+
+// epcInstantaneousPower is the ECHONET Lite property code of the instantaneous electric power in watts.
+const epcInstantaneousPower = 0xE7
+
+// echonetLiteProperty is a property of the smart meter read by Nature Remo E.
+type echonetLiteProperty struct {
+	Name      string    `json:"name"`
+	EPC       int       `json:"epc"`
+	Val       string    `json:"val"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// smartMeterAppliance is an appliance with the smart meter of Nature Remo E, which natureremo.Appliance does not decode.
+type smartMeterAppliance struct {
+	Device     *natureremo.DeviceCore `json:"device"`
+	SmartMeter *struct {
+		Properties []echonetLiteProperty `json:"echonetlite_properties"`
+	} `json:"smart_meter"`
+}
+
+// smartMeterSamples returns the instantaneous power of the smart meters in the appliances as the power samples.
+func smartMeterSamples(appliances []smartMeterAppliance) []sample {
+	var samples []sample
+	for _, a := range appliances {
+		if a.SmartMeter == nil || a.Device == nil {
+			continue
+		}
+		for _, p := range a.SmartMeter.Properties {
+			if p.EPC != epcInstantaneousPower {
+				continue
+			}
+			value, err := strconv.ParseFloat(p.Val, 64)
+			if err != nil {
+				continue
+			}
+			samples = append(samples, sample{
+				Metric:     "power",
+				DeviceID:   a.Device.ID,
+				DeviceName: a.Device.Name,
+				Value:      value,
+				Timestamp:  p.UpdatedAt,
+			})
+		}
+	}
+	return samples
+}
+
+type smartMetersKey struct{}
+
+// smartMeters holds the samples of the smart meters in the appliances fetched with the context returned by withSmartMeters.
+type smartMeters struct {
+	Samples []sample
+}
+
+// withSmartMeters returns a context which records the smart meters in the appliances fetched with it,
+// since the client decodes the appliances without them.
+func withSmartMeters(ctx context.Context) (context.Context, *smartMeters) {
+	m := &smartMeters{}
+	return context.WithValue(ctx, smartMetersKey{}, m), m
+}
+
+// recordSmartMeters records the samples of the smart meters to the context, if it is returned by withSmartMeters.
+func recordSmartMeters(ctx context.Context, samples []sample) {
+	if m, ok := ctx.Value(smartMetersKey{}).(*smartMeters); ok {
+		m.Samples = samples
+	}
+}
+
+// smartMeterRecorder is an http.RoundTripper which records the smart meters in the responses of the appliances
+// to the context returned by withSmartMeters.
+type smartMeterRecorder struct {
+	next http.RoundTripper
+}
+
+func (t *smartMeterRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	_, ok := req.Context().Value(smartMetersKey{}).(*smartMeters)
+	if !ok || req.Method != http.MethodGet || !strings.HasSuffix(req.URL.Path, "/appliances") {
+		return t.next.RoundTrip(req)
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	// a malformed body is reported by the client decoding the appliances
+	var appliances []smartMeterAppliance
+	if json.Unmarshal(body, &appliances) == nil {
+		recordSmartMeters(req.Context(), smartMeterSamples(appliances))
+	}
+	return resp, nil
+}
@@ -0,0 +1,64 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tenntenn/natureremo"
+)
+
+const smartMeterAppliancesJSON = `[
+  {"id": "ac", "type": "AC", "device": {"id": "remo", "name": "Living"}, "nickname": "Air conditioner"},
+  {
+    "id": "meter", "type": "EL_SMART_METER", "device": {"id": "remo-e", "name": "Remo E"}, "nickname": "Smart meter",
+    "smart_meter": {"echonetlite_properties": [
+      {"name": "coefficient", "epc": 211, "val": "1", "updated_at": "2024-01-01T00:00:00Z"},
+      {"name": "measured_instantaneous", "epc": 231, "val": "452", "updated_at": "2024-01-01T00:00:30Z"}
+    ]}
+  }
+]`
+
+func TestSmartMeterRecorder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Rate-Limit-Limit", "30")
+		w.Header().Set("X-Rate-Limit-Remaining", "29")
+		w.Header().Set("X-Rate-Limit-Reset", "1704067200")
+		w.Write([]byte(smartMeterAppliancesJSON))
+	}))
+	defer srv.Close()
+	client := natureremo.NewClient("token")
+	client.BaseURL = srv.URL + "/1"
+	client.HTTPClient = &http.Client{Transport: &smartMeterRecorder{next: http.DefaultTransport}}
+
+	ctx, meters := withSmartMeters(context.Background())
+	appliances, err := client.ApplianceService.GetAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(appliances) != 2 {
+		t.Errorf("got %d appliances, want 2", len(appliances))
+	}
+	want := sample{Metric: "power", DeviceID: "remo-e", DeviceName: "Remo E", Value: 452, Timestamp: time.Date(2024, 1, 1, 0, 0, 30, 0, time.UTC)}
+	if len(meters.Samples) != 1 || meters.Samples[0] != want {
+		t.Errorf("got samples %+v, want %+v", meters.Samples, want)
+	}
+}
@@ -0,0 +1,419 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tenntenn/natureremo"
+)
+
+var (
+	snmpListenAddress string
+	snmpCommunity     string
+	snmpMIBOID        string
+)
+
+// snmpDefaultMIBOID is natureRemoExporterMIB under netSnmpPlaypen of NET-SNMP-MIB,
+// which is reserved for experiments and local use, so it can be moved under the enterprise of the user.
+const snmpDefaultMIBOID = "1.3.6.1.4.1.8072.9999.9999.1"
+
+// snmpDeviceEntry is the OID of remoDeviceEntry under natureRemoExporterMIB.
+var snmpDeviceEntry = asn1.ObjectIdentifier{1, 1, 1}
+
+// the versions and the PDU types of SNMP
+const (
+	snmpV1  = 0
+	snmpV2c = 1
+
+	snmpGetRequest     = 0
+	snmpGetNextRequest = 1
+	snmpResponse       = 2
+	snmpSetRequest     = 3
+	snmpGetBulkRequest = 5
+)
+
+// the error statuses of SNMP
+const (
+	snmpTooBig      = 1
+	snmpNoSuchName  = 2
+	snmpNotWritable = 17
+)
+
+// snmpMaxRepetitions limits the repetitions of a GetBulk request.
+const snmpMaxRepetitions = 64
+
+// snmpMaxMessageSize is the maximum size of the responses, which fits in an Ethernet frame
+// so that the responses are not fragmented, as Net-SNMP does by default.
+const snmpMaxMessageSize = 1472
+
+// the exceptions of SNMPv2c in place of the values
+var (
+	snmpNoSuchObject   = asn1.RawValue{FullBytes: []byte{0x80, 0x00}}
+	snmpNoSuchInstance = asn1.RawValue{FullBytes: []byte{0x81, 0x00}}
+	snmpEndOfMIBView   = asn1.RawValue{FullBytes: []byte{0x82, 0x00}}
+)
+
+type snmpMessage struct {
+	Version   int
+	Community []byte
+	PDU       asn1.RawValue
+}
+
+type snmpPDU struct {
+	RequestID   int
+	ErrorStatus int
+	ErrorIndex  int
+	VarBinds    []snmpVarBind
+}
+
+type snmpVarBind struct {
+	Name  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// snmpColumn is a column of remoDeviceTable.
+type snmpColumn struct {
+	// value returns the value of the device, or false if it has none.
+	value func(device *natureremo.Device) (asn1.RawValue, bool)
+}
+
+// snmpColumns are the columns of remoDeviceTable from remoDeviceId (2).
+// remoDeviceIndex (1) is not accessible as the index of the table.
+var snmpColumns = []snmpColumn{
+	{func(device *natureremo.Device) (asn1.RawValue, bool) {
+		return snmpOctetString(device.ID), true
+	}},
+	{func(device *natureremo.Device) (asn1.RawValue, bool) {
+		return snmpOctetString(device.Name), true
+	}},
+	// the temperature is in hundredths of degrees Celsius, as SNMP has no floating point numbers
+	{snmpSensor(natureremo.SensorTypeTemperature, func(v float64) asn1.RawValue {
+		return snmpInteger(math.Round(v * 100))
+	})},
+	{snmpSensor(natureremo.SensorTypeHumidity, func(v float64) asn1.RawValue {
+		return snmpInteger(math.Round(v))
+	})},
+	{snmpSensor(natureremo.SensorTypeIllumination, func(v float64) asn1.RawValue {
+		return snmpInteger(math.Round(v))
+	})},
+	// the value of the movement sensor is always 1, so the time of the last movement is served in Unix time
+	{func(device *natureremo.Device) (asn1.RawValue, bool) {
+		value, ok := device.NewestEvents[natureremo.SensorTypeMovement]
+		if !ok {
+			return asn1.RawValue{}, false
+		}
+		return snmpUnsigned32(value.CreatedAt.Unix()), true
+	}},
+}
+
+func snmpSensor(sensor natureremo.SensorType, f func(float64) asn1.RawValue) func(*natureremo.Device) (asn1.RawValue, bool) {
+	return func(device *natureremo.Device) (asn1.RawValue, bool) {
+		value, ok := device.NewestEvents[sensor]
+		if !ok {
+			return asn1.RawValue{}, false
+		}
+		return f(value.Value), true
+	}
+}
+
+func snmpInteger(v float64) asn1.RawValue {
+	v = max(math.MinInt32, min(math.MaxInt32, v))
+	b, _ := asn1.Marshal(int(v))
+	return asn1.RawValue{FullBytes: b}
+}
+
+func snmpUnsigned32(v int64) asn1.RawValue {
+	b, _ := asn1.MarshalWithParams(max(0, min(math.MaxUint32, v)), "application,tag:2")
+	return asn1.RawValue{FullBytes: b}
+}
+
+func snmpOctetString(s string) asn1.RawValue {
+	b, _ := asn1.Marshal([]byte(s))
+	return asn1.RawValue{FullBytes: b}
+}
+
+// snmpAgent is a read-only SNMPv1 and v2c agent serving the devices of the snapshot as remoDeviceTable.
+type snmpAgent struct {
+	conn      net.PacketConn
+	community []byte
+	// entry is the OID of remoDeviceEntry.
+	entry  asn1.ObjectIdentifier
+	snap   *snapshot
+	logger *slog.Logger
+}
+
+// newSNMPAgent listens on the address for the requests of the community,
+// serving NATURE-REMO-EXPORTER-MIB at the OID in the dotted notation.
+func newSNMPAgent(address, community, mibOID string, snap *snapshot, logger *slog.Logger) (*snmpAgent, error) {
+	if community == "" {
+		return nil, fmt.Errorf("--snmp.community is required with --snmp.listen-address")
+	}
+	mib, err := parseOID(mibOID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --snmp.mib-oid: %v", err)
+	}
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	entry := append(append(asn1.ObjectIdentifier{}, mib...), snmpDeviceEntry...)
+	return &snmpAgent{conn: conn, community: []byte(community), entry: entry, snap: snap, logger: logger}, nil
+}
+
+// parseOID parses the OID in the dotted notation, e.g. 1.3.6.1.4.1.8072.
+func parseOID(s string) (asn1.ObjectIdentifier, error) {
+	var oid asn1.ObjectIdentifier
+	for _, part := range strings.Split(strings.TrimPrefix(s, "."), ".") {
+		n, err := strconv.ParseUint(part, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		oid = append(oid, int(n))
+	}
+	if len(oid) < 2 || oid[0] > 2 {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	return oid, nil
+}
+
+// Serve answers the requests until the agent is closed.
+func (a *snmpAgent) Serve() error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := a.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		res, err := a.handle(buf[:n])
+		if err != nil {
+			a.logger.Debug(fmt.Sprintf("invalid SNMP request from %s: %v", addr, err))
+			continue
+		}
+		if res != nil {
+			if _, err := a.conn.WriteTo(res, addr); err != nil {
+				a.logger.Error(fmt.Sprintf("failed to send SNMP response to %s: %v", addr, err))
+			}
+		}
+	}
+}
+
+func (a *snmpAgent) Close() error {
+	return a.conn.Close()
+}
+
+// handle returns the response to the request, or nil if it should be ignored.
+func (a *snmpAgent) handle(packet []byte) ([]byte, error) {
+	var msg snmpMessage
+	if _, err := asn1.Unmarshal(packet, &msg); err != nil {
+		return nil, err
+	}
+	if msg.Version != snmpV1 && msg.Version != snmpV2c {
+		return nil, fmt.Errorf("unsupported version %d", msg.Version)
+	}
+	// the requests of wrong communities are dropped silently like other agents
+	if !bytes.Equal(msg.Community, a.community) {
+		return nil, nil
+	}
+	if msg.PDU.Class != asn1.ClassContextSpecific {
+		return nil, fmt.Errorf("unexpected PDU class %d", msg.PDU.Class)
+	}
+	var req snmpPDU
+	if _, err := asn1.UnmarshalWithParams(msg.PDU.FullBytes, &req, fmt.Sprintf("tag:%d", msg.PDU.Tag)); err != nil {
+		return nil, err
+	}
+
+	vars := a.vars()
+	res := snmpPDU{RequestID: req.RequestID, VarBinds: []snmpVarBind{}}
+	nonRepeaters := 0
+	switch msg.PDU.Tag {
+	case snmpGetRequest:
+		for _, vb := range req.VarBinds {
+			res.VarBinds = append(res.VarBinds, snmpVarBind{Name: vb.Name, Value: a.get(vars, vb.Name)})
+		}
+	case snmpGetNextRequest:
+		for _, vb := range req.VarBinds {
+			res.VarBinds = append(res.VarBinds, snmpGetNext(vars, vb.Name))
+		}
+	case snmpGetBulkRequest:
+		if msg.Version == snmpV1 {
+			return nil, fmt.Errorf("GetBulk is not supported by SNMPv1")
+		}
+		nonRepeaters = max(0, min(req.ErrorStatus, len(req.VarBinds)))
+		for _, vb := range req.VarBinds[:nonRepeaters] {
+			res.VarBinds = append(res.VarBinds, snmpGetNext(vars, vb.Name))
+		}
+		repeaters := make([]asn1.ObjectIdentifier, 0, len(req.VarBinds)-nonRepeaters)
+		for _, vb := range req.VarBinds[nonRepeaters:] {
+			repeaters = append(repeaters, vb.Name)
+		}
+		for i := 0; i < min(req.ErrorIndex, snmpMaxRepetitions) && len(repeaters) > 0; i++ {
+			end := true
+			for j, name := range repeaters {
+				next := snmpGetNext(vars, name)
+				res.VarBinds = append(res.VarBinds, next)
+				repeaters[j] = next.Name
+				end = end && isSNMPException(next.Value)
+			}
+			if end {
+				break
+			}
+		}
+	case snmpSetRequest:
+		res.VarBinds = req.VarBinds
+		res.ErrorStatus, res.ErrorIndex = snmpNotWritable, 1
+		if msg.Version == snmpV1 {
+			res.ErrorStatus = snmpNoSuchName
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PDU type %d", msg.PDU.Tag)
+	}
+
+	// SNMPv1 has no exceptions in the variable bindings, so the error status is set instead
+	if msg.Version == snmpV1 {
+		for i, vb := range res.VarBinds {
+			if isSNMPException(vb.Value) {
+				res.VarBinds = req.VarBinds
+				res.ErrorStatus, res.ErrorIndex = snmpNoSuchName, i+1
+				break
+			}
+		}
+	}
+
+	b, err := marshalSNMPResponse(msg, res)
+	if err != nil || len(b) <= snmpMaxMessageSize {
+		return b, err
+	}
+	// a GetBulk response is truncated to fit, and the others fail with tooBig
+	if msg.PDU.Tag == snmpGetBulkRequest && len(res.VarBinds) > nonRepeaters {
+		base, err := marshalSNMPResponse(msg, snmpPDU{RequestID: res.RequestID, VarBinds: []snmpVarBind{}})
+		if err != nil {
+			return nil, err
+		}
+		// the lengths of the sequences enclosing the variable bindings may grow by 3 bytes each
+		size := len(base) + 3*3
+		n := 0
+		for _, vb := range res.VarBinds {
+			vbb, err := asn1.Marshal(vb)
+			if err != nil {
+				return nil, err
+			}
+			if size+len(vbb) > snmpMaxMessageSize {
+				break
+			}
+			size += len(vbb)
+			n++
+		}
+		if n >= nonRepeaters {
+			res.VarBinds = res.VarBinds[:n]
+			return marshalSNMPResponse(msg, res)
+		}
+	}
+	res = snmpPDU{RequestID: req.RequestID, ErrorStatus: snmpTooBig, VarBinds: []snmpVarBind{}}
+	// SNMPv1 responds with the same variable bindings as the request
+	if msg.Version == snmpV1 {
+		res.VarBinds = req.VarBinds
+	}
+	return marshalSNMPResponse(msg, res)
+}
+
+// marshalSNMPResponse encodes the response PDU in a message of the version and the community of the request.
+func marshalSNMPResponse(req snmpMessage, res snmpPDU) ([]byte, error) {
+	pdu, err := asn1.MarshalWithParams(res, fmt.Sprintf("tag:%d", snmpResponse))
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(snmpMessage{Version: req.Version, Community: req.Community, PDU: asn1.RawValue{FullBytes: pdu}})
+}
+
+// vars returns the variables of remoDeviceTable in the lexicographical order of the OIDs.
+// The devices are indexed from 1 in the order of their IDs.
+func (a *snmpAgent) vars() []snmpVarBind {
+	a.snap.mu.RLock()
+	devices := make([]*natureremo.Device, len(a.snap.devices))
+	copy(devices, a.snap.devices)
+	a.snap.mu.RUnlock()
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+
+	var vars []snmpVarBind
+	for i, column := range snmpColumns {
+		for j, device := range devices {
+			value, ok := column.value(device)
+			if !ok {
+				continue
+			}
+			name := append(append(asn1.ObjectIdentifier{}, a.entry...), i+2, j+1)
+			vars = append(vars, snmpVarBind{Name: name, Value: value})
+		}
+	}
+	return vars
+}
+
+// get returns the value of the OID, or the exception if it does not exist.
+func (a *snmpAgent) get(vars []snmpVarBind, name asn1.ObjectIdentifier) asn1.RawValue {
+	for _, v := range vars {
+		if v.Name.Equal(name) {
+			return v.Value
+		}
+	}
+	for i := range snmpColumns {
+		column := append(append(asn1.ObjectIdentifier{}, a.entry...), i+2)
+		if len(name) > len(column) && column.Equal(name[:len(column)]) {
+			return snmpNoSuchInstance
+		}
+	}
+	return snmpNoSuchObject
+}
+
+// snmpGetNext returns the variable following the OID, or endOfMibView if there is none.
+func snmpGetNext(vars []snmpVarBind, name asn1.ObjectIdentifier) snmpVarBind {
+	for _, v := range vars {
+		if compareOIDs(v.Name, name) > 0 {
+			return v
+		}
+	}
+	return snmpVarBind{Name: name, Value: snmpEndOfMIBView}
+}
+
+func isSNMPException(v asn1.RawValue) bool {
+	return len(v.FullBytes) > 0 && v.FullBytes[0]&0xc0 == 0x80
+}
+
+// compareOIDs compares the OIDs in the lexicographical order.
+func compareOIDs(a, b asn1.ObjectIdentifier) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
@@ -0,0 +1,224 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imishinist/nature-remo-exporter/pkg/collector/collectortest"
+	"github.com/tenntenn/natureremo"
+)
+
+// hexBytes decodes the hex string, ignoring the spaces and the line breaks which separate the fields.
+func hexBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func newTestSNMPAgent(t *testing.T, devices ...*natureremo.Device) *snmpAgent {
+	t.Helper()
+	mib, err := parseOID(snmpDefaultMIBOID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap := &snapshot{}
+	snap.SetDevices(devices)
+	return &snmpAgent{
+		community: []byte("public"),
+		entry:     append(mib, snmpDeviceEntry...),
+		snap:      snap,
+		logger:    slog.Default(),
+	}
+}
+
+func TestSNMPAgentHandle(t *testing.T) {
+	a := newTestSNMPAgent(t,
+		collectortest.Device("a", "Living", map[natureremo.SensorType]float64{
+			natureremo.SensorTypeTemperature: 25.3,
+			natureremo.SensorTypeHumidity:    40,
+		}, time.Now()),
+		collectortest.Device("b", "Bedroom", map[natureremo.SensorType]float64{
+			natureremo.SensorTypeTemperature: 20,
+		}, time.Now()),
+	)
+
+	// the messages are encoded independently of encoding/asn1, with the OIDs under remoDeviceEntry (1.3.6.1.4.1.8072.9999.9999.1.1.1.1)
+	tests := []struct {
+		name string
+		req  string
+		res  string
+	}{
+		{
+			name: "v2c get of the temperature",
+			req:  "303002010104067075626c6963a023020204d20201000201003017301506112b06010401bf08ce0fce0f0101010104010500",
+			res:  "303202010104067075626c6963a225020204d20201000201003019301706112b06010401bf08ce0fce0f010101010401020209e2",
+		},
+		{
+			name: "v2c get of a missing sensor",
+			req:  "302f02010104067075626c6963a0220201010201000201003017301506112b06010401bf08ce0fce0f0101010105020500",
+			res:  "302f02010104067075626c6963a2220201010201000201003017301506112b06010401bf08ce0fce0f0101010105028100",
+		},
+		{
+			name: "v2c get-next of a column",
+			req:  "302e02010104067075626c6963a1210201070201000201003016301406102b06010401bf08ce0fce0f01010101030500",
+			res:  "303502010104067075626c6963a228020107020100020100301d301b06112b06010401bf08ce0fce0f01010101030104064c6976696e67",
+		},
+		{
+			name: "v1 get-next beyond the table",
+			req:  "302f02010004067075626c6963a1220201090201000201003017301506112b06010401bf08ce0fce0f0101010107010500",
+			res:  "302f02010004067075626c6963a2220201090201020201013017301506112b06010401bf08ce0fce0f0101010107010500",
+		},
+		{
+			name: "v2c set is not writable",
+			req:  "303002010104067075626c6963a3230201030201000201003018301606112b06010401bf08ce0fce0f010101010301040178",
+			res:  "303002010104067075626c6963a2230201030201110201013018301606112b06010401bf08ce0fce0f010101010301040178",
+		},
+		{
+			name: "wrong community is ignored",
+			req:  "303002010104067075626c696ba023020204d20201000201003017301506112b06010401bf08ce0fce0f0101010104010500",
+			res:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := a.handle(hexBytes(t, tt.req))
+			if err != nil {
+				t.Fatalf("handle() error = %v", err)
+			}
+			if want := hexBytes(t, tt.res); !bytes.Equal(got, want) {
+				t.Errorf("handle() =\n%x\nwant\n%x", got, want)
+			}
+		})
+	}
+
+	if _, err := a.handle(hexBytes(t, "3003020101")); err == nil {
+		t.Error("handle() succeeded with a truncated message")
+	}
+}
+
+func TestSNMPAgentGetBulkIsLimited(t *testing.T) {
+	var devices []*natureremo.Device
+	for i := 0; i < 100; i++ {
+		devices = append(devices, collectortest.Device(fmt.Sprintf("device-%03d", i), fmt.Sprintf("Room %d", i), map[natureremo.SensorType]float64{
+			natureremo.SensorTypeTemperature: 20,
+		}, time.Now()))
+	}
+	a := newTestSNMPAgent(t, devices...)
+
+	request := func(tag int, nonRepeaters, maxRepetitions int, names ...asn1.ObjectIdentifier) []byte {
+		t.Helper()
+		pdu := snmpPDU{RequestID: 1, ErrorStatus: nonRepeaters, ErrorIndex: maxRepetitions}
+		for _, name := range names {
+			pdu.VarBinds = append(pdu.VarBinds, snmpVarBind{Name: name, Value: asn1.NullRawValue})
+		}
+		b, err := asn1.MarshalWithParams(pdu, fmt.Sprintf("tag:%d", tag))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err = asn1.Marshal(snmpMessage{Version: snmpV2c, Community: []byte("public"), PDU: asn1.RawValue{FullBytes: b}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	decode := func(b []byte) snmpPDU {
+		t.Helper()
+		var msg snmpMessage
+		if _, err := asn1.Unmarshal(b, &msg); err != nil {
+			t.Fatal(err)
+		}
+		var pdu snmpPDU
+		if _, err := asn1.UnmarshalWithParams(msg.PDU.FullBytes, &pdu, fmt.Sprintf("tag:%d", snmpResponse)); err != nil {
+			t.Fatal(err)
+		}
+		return pdu
+	}
+
+	res, err := a.handle(request(snmpGetBulkRequest, 0, 64, a.entry, a.entry))
+	if err != nil {
+		t.Fatalf("handle() error = %v", err)
+	}
+	if len(res) > snmpMaxMessageSize {
+		t.Errorf("GetBulk response has %d bytes, want at most %d", len(res), snmpMaxMessageSize)
+	}
+	pdu := decode(res)
+	if pdu.ErrorStatus != 0 || len(pdu.VarBinds) == 0 || len(pdu.VarBinds) >= 2*64 {
+		t.Errorf("GetBulk response has error status %d and %d variable bindings, want a truncated response", pdu.ErrorStatus, len(pdu.VarBinds))
+	}
+	// the repetitions are truncated from the end, so the walk continues from the last one
+	if !pdu.VarBinds[0].Name.Equal(append(append(asn1.ObjectIdentifier{}, a.entry...), 2, 1)) {
+		t.Errorf("GetBulk response starts with %v", pdu.VarBinds[0].Name)
+	}
+
+	// the non-repeaters which do not fit fail the request
+	names := make([]asn1.ObjectIdentifier, 200)
+	for i := range names {
+		names[i] = a.entry
+	}
+	res, err = a.handle(request(snmpGetBulkRequest, len(names), 0, names...))
+	if err != nil {
+		t.Fatalf("handle() error = %v", err)
+	}
+	if pdu := decode(res); pdu.ErrorStatus != snmpTooBig || len(pdu.VarBinds) != 0 {
+		t.Errorf("GetBulk response has error status %d and %d variable bindings, want tooBig", pdu.ErrorStatus, len(pdu.VarBinds))
+	}
+
+	res, err = a.handle(request(snmpGetNextRequest, 0, 0, names...))
+	if err != nil {
+		t.Fatalf("handle() error = %v", err)
+	}
+	if pdu := decode(res); pdu.ErrorStatus != snmpTooBig {
+		t.Errorf("GetNext response has error status %d, want tooBig", pdu.ErrorStatus)
+	}
+}
+
+func TestParseOID(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    asn1.ObjectIdentifier
+		wantErr bool
+	}{
+		{s: "1.3.6.1.4.1.8072.9999.9999.1", want: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 8072, 9999, 9999, 1}},
+		{s: ".1.3.6.1.4.1.99999", want: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999}},
+		{s: "", wantErr: true},
+		{s: "1", wantErr: true},
+		{s: "1.3..6", wantErr: true},
+		{s: "1.3.-6", wantErr: true},
+		{s: "3.1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseOID(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseOID(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseOID(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
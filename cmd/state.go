@@ -0,0 +1,78 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/imishinist/nature-remo-exporter/pkg/collector"
+)
+
+var stateFile string
+
+// stateStore saves the state to a file when it changes.
+type stateStore struct {
+	path string
+	last []byte
+}
+
+// openStateStore loads the state from the file. A missing file is an empty state.
+func openStateStore(path string) (*stateStore, *collector.State, error) {
+	s := &stateStore{path: path}
+	st := &collector.State{Devices: make(map[string]collector.DeviceState)}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, st, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read state file: %v", err)
+	}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse state file %s: %v", path, err)
+	}
+	if st.Devices == nil {
+		st.Devices = make(map[string]collector.DeviceState)
+	}
+	s.last = b
+	return s, st, nil
+}
+
+// Save writes the state unless it is the same as the last one.
+func (s *stateStore) Save(st *collector.State) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(b, s.last) {
+		return nil
+	}
+	// write to a temporary file first not to leave a partial state on crash
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	s.last = b
+	return nil
+}
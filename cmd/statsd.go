@@ -0,0 +1,124 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// statsdMaxPacketSize is the maximum size of a datagram not to be fragmented on common networks.
+const statsdMaxPacketSize = 1432
+
+var (
+	statsdAddress    string
+	statsdPrefix     string
+	statsdTagsFormat string
+	statsdTags       []string
+)
+
+// statsdTagReplacer replaces the characters which break the tags of DogStatsD.
+var statsdTagReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+
+// statsdSink sends the samples to StatsD as gauges.
+type statsdSink struct {
+	network string
+	address string
+	prefix  string
+	tags    bool
+	extra   []string
+}
+
+// newStatsDSink creates a sink which sends to the UDP address, or the unix datagram socket with the unix: prefix.
+// The tags are in the DogStatsD format, or omitted for the plain StatsD.
+func newStatsDSink(address, prefix, tagsFormat string, extraTags []string) (*statsdSink, error) {
+	s := &statsdSink{network: "udp", address: address, prefix: prefix, extra: extraTags}
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		s.network, s.address = "unixgram", path
+	}
+	switch tagsFormat {
+	case "dogstatsd":
+		s.tags = true
+	case "none":
+	default:
+		return nil, fmt.Errorf("invalid StatsD tags format %q: must be dogstatsd or none", tagsFormat)
+	}
+	return s, nil
+}
+
+func (s *statsdSink) Name() string {
+	return "statsd"
+}
+
+func (s *statsdSink) Write(ctx context.Context, samples []sample) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+	}
+
+	var packet []byte
+	for _, sample := range samples {
+		line := s.line(sample)
+		if len(packet) > 0 && len(packet)+1+len(line) > statsdMaxPacketSize {
+			if _, err := conn.Write(packet); err != nil {
+				return err
+			}
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		if _, err := conn.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// line formats the sample as a gauge.
+// Without the tags, the device name is a part of the metric name to distinguish the devices.
+// A negative value is preceded by the gauge set to 0, since StatsD takes a signed value as a delta.
+func (s *statsdSink) line(sample sample) string {
+	name := s.prefix + sample.Metric
+	if !s.tags {
+		name = s.prefix + graphiteDeviceName(sample) + "." + sample.Metric
+	}
+	var suffix string
+	if s.tags {
+		tags := append([]string{
+			"device_id:" + statsdTagReplacer.Replace(sample.DeviceID),
+			"device_name:" + statsdTagReplacer.Replace(sample.DeviceName),
+		}, s.extra...)
+		suffix = "|#" + strings.Join(tags, ",")
+	}
+	line := name + ":" + strconv.FormatFloat(sample.Value, 'f', -1, 64) + "|g" + suffix
+	if sample.Value < 0 {
+		line = name + ":0|g" + suffix + "\n" + line
+	}
+	return line
+}
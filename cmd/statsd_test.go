@@ -0,0 +1,73 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"testing"
+)
+
+func TestStatsDSinkLine(t *testing.T) {
+	tests := []struct {
+		name       string
+		tagsFormat string
+		sample     sample
+		want       string
+	}{
+		{
+			name:       "tagged",
+			tagsFormat: "dogstatsd",
+			sample:     sample{Metric: "temperature", DeviceID: "id-1", DeviceName: "リビング", Value: 20.5},
+			want:       "nature_remo.temperature:20.5|g|#device_id:id-1,device_name:リビング,env:home",
+		},
+		{
+			name:       "tagged negative",
+			tagsFormat: "dogstatsd",
+			sample:     sample{Metric: "temperature", DeviceID: "id-1", DeviceName: "Outside", Value: -3.5},
+			want: "nature_remo.temperature:0|g|#device_id:id-1,device_name:Outside,env:home\n" +
+				"nature_remo.temperature:-3.5|g|#device_id:id-1,device_name:Outside,env:home",
+		},
+		{
+			name:       "untagged",
+			tagsFormat: "none",
+			sample:     sample{Metric: "temperature", DeviceID: "id-1", DeviceName: "Living room", Value: 20.5},
+			want:       "nature_remo.Living_room.temperature:20.5|g",
+		},
+		{
+			name:       "untagged negative",
+			tagsFormat: "none",
+			sample:     sample{Metric: "temperature", DeviceID: "id-1", DeviceName: "Outside", Value: -3.5},
+			want:       "nature_remo.Outside.temperature:0|g\nnature_remo.Outside.temperature:-3.5|g",
+		},
+		{
+			name:       "untagged non-ASCII name",
+			tagsFormat: "none",
+			sample:     sample{Metric: "temperature", DeviceID: "id-2", DeviceName: "リビング", Value: 21},
+			want:       "nature_remo.id-2.temperature:21|g",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newStatsDSink("localhost:8125", "nature_remo.", tt.tagsFormat, []string{"env:home"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.line(tt.sample); got != tt.want {
+				t.Errorf("line() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tenntenn/natureremo"
+)
+
+// streamKeepAliveInterval is the interval of the comments sent to keep idle streams open through proxies.
+const streamKeepAliveInterval = 30 * time.Second
+
+// reading is an event of the stream, which is a changed value of a sensor.
+type reading struct {
+	DeviceID   string                `json:"device_id"`
+	DeviceName string                `json:"device_name"`
+	Sensor     natureremo.SensorType `json:"sensor"`
+	Value      float64               `json:"value"`
+	CreatedAt  time.Time             `json:"created_at"`
+}
+
+// stream publishes the changes of the sensor values to the subscribers as Server-Sent Events.
+type stream struct {
+	mu          sync.Mutex
+	last        map[string]map[natureremo.SensorType]natureremo.SensorValue
+	subscribers map[chan reading]struct{}
+	done        chan struct{}
+	closed      bool
+}
+
+func newStream() *stream {
+	return &stream{
+		last:        make(map[string]map[natureremo.SensorType]natureremo.SensorValue),
+		subscribers: make(map[chan reading]struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Update compares the devices with the previous ones and publishes the changes.
+// A movement is published when a new event is created, and the other sensors when the value is changed.
+// Nothing is published for the devices seen for the first time.
+func (s *stream) Update(devices []*natureremo.Device) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, device := range devices {
+		last, ok := s.last[device.ID]
+		s.last[device.ID] = device.NewestEvents
+		if !ok {
+			continue
+		}
+		for sensor, value := range device.NewestEvents {
+			prev, ok := last[sensor]
+			if ok && prev.Value == value.Value && (sensor != natureremo.SensorTypeMovement || prev.CreatedAt.Equal(value.CreatedAt)) {
+				continue
+			}
+			s.publish(reading{
+				DeviceID:   device.ID,
+				DeviceName: device.Name,
+				Sensor:     sensor,
+				Value:      value.Value,
+				CreatedAt:  value.CreatedAt,
+			})
+		}
+	}
+}
+
+// publish sends the event to all subscribers. s.mu must be held.
+// The event is dropped for the subscribers which are too slow to receive it.
+func (s *stream) publish(r reading) {
+	for ch := range s.subscribers {
+		select {
+		case ch <- r:
+		default:
+		}
+	}
+}
+
+func (s *stream) subscribe() chan reading {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan reading, 64)
+	s.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (s *stream) unsubscribe(ch chan reading) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscribers, ch)
+}
+
+// Close ends all streams, so that the server can shut down without waiting for them.
+func (s *stream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+}
+
+// Handler serves the events as Server-Sent Events until the client disconnects.
+func (s *stream) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		// the stream lasts longer than --web.write-timeout
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		ch := s.subscribe()
+		defer s.unsubscribe(ch)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		keepAlive := time.NewTicker(streamKeepAliveInterval)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-s.done:
+				return
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+			case reading := <-ch:
+				b, err := json.Marshal(reading)
+				if err != nil {
+					return
+				}
+				if _, err := fmt.Fprintf(w, "event: reading\ndata: %s\n\n", b); err != nil {
+					return
+				}
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,98 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/imishinist/nature-remo-exporter/pkg/collector"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// tokenCmd represents the token command
+	tokenCmd = &cobra.Command{
+		Use:   "token",
+		Short: "Manage Nature Remo access tokens",
+	}
+
+	// tokenVerifyCmd represents the token verify command
+	tokenVerifyCmd = &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the access token",
+		Long: `Verify calls /1/users/me with the access token and prints the associated user
+and the current rate limit. It exits with a non-zero status if the token is invalid.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if accessToken == "" {
+				return fmt.Errorf("access token is not set")
+			}
+
+			client, err := newClient(accessToken)
+			if err != nil {
+				return err
+			}
+
+			ctx, res := withAPIResponse(cmd.Context())
+			user, err := client.UserService.Me(ctx)
+			if err != nil {
+				if res.StatusCode == http.StatusUnauthorized {
+					return fmt.Errorf("token is invalid: %v", err)
+				}
+				return fmt.Errorf("failed to verify token: %v", err)
+			}
+
+			w := cmd.OutOrStdout()
+			fmt.Fprintf(w, "user id:   %s\n", user.ID)
+			fmt.Fprintf(w, "nickname:  %s\n", user.Nickname)
+			if rl, ok := collector.ParseRateLimit(res.Header); ok {
+				fmt.Fprintf(w, "limit:     %d\n", rl.Limit)
+				fmt.Fprintf(w, "remaining: %d\n", rl.Remaining)
+				fmt.Fprintf(w, "reset:     %s\n", rl.Reset.Format(time.RFC3339))
+			}
+			return nil
+		},
+	}
+)
+
+// readTokenFromStdin replaces the token with the first line of stdin if it is -,
+// so that the token is passed from a password manager without putting it in the arguments or a file.
+func readTokenFromStdin(stdin io.Reader) error {
+	if accessToken != "-" {
+		return nil
+	}
+	line, err := bufio.NewReader(io.LimitReader(stdin, 4096)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read access token from stdin: %v", err)
+	}
+	accessToken = strings.TrimSpace(line)
+	if accessToken == "" {
+		return fmt.Errorf("no access token in stdin")
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(tokenCmd)
+	tokenCmd.AddCommand(tokenVerifyCmd)
+}
@@ -0,0 +1,136 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingTransport(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: &tracingTransport{next: http.DefaultTransport}}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL+"/1/devices", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "GET /1/devices" {
+		t.Errorf("span name = %q, want %q", span.Name(), "GET /1/devices")
+	}
+	var status int64
+	var path string
+	for _, kv := range span.Attributes() {
+		switch kv.Key {
+		case attribute.Key("http.response.status_code"):
+			status = kv.Value.AsInt64()
+		case attribute.Key("url.path"):
+			path = kv.Value.AsString()
+		}
+	}
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("span has http.response.status_code %d, want 503", status)
+	}
+	if path != "/1/devices" {
+		t.Errorf("span has url.path %q, want /1/devices", path)
+	}
+	if span.Status().Code != codes.Error {
+		t.Errorf("span status = %v, want Error", span.Status().Code)
+	}
+}
+
+func TestTracingTransportRecordsRetries(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: &tracingTransport{next: http.DefaultTransport}}
+
+	p := retryPolicy{Attempts: 3}
+	err := p.Do(context.Background(), func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/1/devices", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return &apiError{Class: apiErrorServer, Retryable: true, StatusCode: resp.StatusCode}
+	})
+	if err == nil {
+		t.Fatal("Do() succeeded with a failing server")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("recorded %d spans, want 3", len(spans))
+	}
+	for i, span := range spans {
+		var got, status int64 = -1, 0
+		for _, kv := range span.Attributes() {
+			switch kv.Key {
+			case attribute.Key("http.request.resend_count"):
+				got = kv.Value.AsInt64()
+			case attribute.Key("http.response.status_code"):
+				status = kv.Value.AsInt64()
+			}
+		}
+		// the first request is not a resend, so it has no count
+		want := int64(i)
+		if i == 0 {
+			want = -1
+		}
+		if got != want {
+			t.Errorf("span %d has http.request.resend_count %d, want %d", i, got, want)
+		}
+		if status != http.StatusServiceUnavailable {
+			t.Errorf("span %d has http.response.status_code %d, want 503", i, status)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"net/http"
+	"runtime/debug"
+)
+
+// version is the version of the exporter, which is set by the linker flags of the release builds.
+var version string
+
+var apiUserAgentSuffix string
+
+// exporterVersion returns the version of the exporter.
+// It falls back to the module version for `go install`, or "dev" for a build from the source.
+func exporterVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// userAgent returns the User-Agent of the API calls, such as nature-remo-exporter/1.2.3.
+func userAgent() string {
+	ua := "nature-remo-exporter/" + exporterVersion()
+	if apiUserAgentSuffix != "" {
+		ua += " " + apiUserAgentSuffix
+	}
+	return ua
+}
+
+// userAgentTransport sets the User-Agent of the requests.
+type userAgentTransport struct {
+	next      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+var (
+	victoriaMetricsURL         string
+	victoriaMetricsExtraLabels map[string]string
+)
+
+// victoriaMetricsSink imports the samples to VictoriaMetrics in the JSON line format,
+// with the timestamps of the sensor events instead of the time of the scrape.
+type victoriaMetricsSink struct {
+	client    *http.Client
+	importURL string
+	user      *url.Userinfo
+}
+
+// victoriaMetricsLine is a line of /api/v1/import.
+type victoriaMetricsLine struct {
+	Metric     map[string]string `json:"metric"`
+	Values     []float64         `json:"values"`
+	Timestamps []int64           `json:"timestamps"`
+}
+
+// newVictoriaMetricsSink creates a sink which imports to the URL of VictoriaMetrics,
+// such as http://localhost:8428 of the single node or http://vminsert:8480/insert/0/prometheus of the cluster.
+func newVictoriaMetricsSink(rawURL string, extraLabels map[string]string) (*victoriaMetricsSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VictoriaMetrics URL: %v", err)
+	}
+
+	// the credentials are sent by basic auth instead of in the URL
+	var user *url.Userinfo
+	user, u.User = u.User, nil
+	u = u.JoinPath("api", "v1", "import")
+	names := make([]string, 0, len(extraLabels))
+	for name := range extraLabels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	query := u.Query()
+	for _, name := range names {
+		query.Add("extra_label", name+"="+extraLabels[name])
+	}
+	u.RawQuery = query.Encode()
+	return &victoriaMetricsSink{
+		client:    &http.Client{},
+		importURL: u.String(),
+		user:      user,
+	}, nil
+}
+
+func (s *victoriaMetricsSink) Name() string {
+	return "victoriametrics"
+}
+
+func (s *victoriaMetricsSink) Write(ctx context.Context, samples []sample) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, sample := range samples {
+		err := enc.Encode(victoriaMetricsLine{
+			// the names of the metrics of the exporter, but only with the labels which the samples have,
+			// so the series differ from the scraped ones without the labels of the hardware
+			Metric: map[string]string{
+				"__name__": "nature_remo_" + sample.Metric,
+				"id":       sample.DeviceID,
+				"name":     sample.DeviceName,
+			},
+			Values:     []float64{sample.Value},
+			Timestamps: []int64{sample.Timestamp.UnixMilli()},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.importURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/stream+json")
+	if s.user != nil {
+		password, _ := s.user.Password()
+		req.SetBasicAuth(s.user.Username(), password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("VictoriaMetrics returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// bufferedSink is a sink which stores the batches failed to write in the segment files of a directory,
+// and replays them in order before the next batch, so that the samples survive outages of the backend.
+// The oldest segments are removed when the total size exceeds maxSize.
+// It is not safe for concurrent use.
+type bufferedSink struct {
+	next    sink
+	dir     string
+	maxSize int64
+	logger  *slog.Logger
+
+	// segments are the sequence numbers of the pending segments in ascending order.
+	segments []uint64
+	seq      uint64
+}
+
+func newBufferedSink(next sink, dir string, maxSize int64, logger *slog.Logger) (*bufferedSink, error) {
+	b := &bufferedSink{
+		next:    next,
+		dir:     filepath.Join(dir, next.Name()),
+		maxSize: maxSize,
+		logger:  logger,
+	}
+	if err := os.MkdirAll(b.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create buffer directory: %v", err)
+	}
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read buffer directory: %v", err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".json.tmp") {
+			// a partial segment left by a crash while storing it
+			os.Remove(filepath.Join(b.dir, e.Name()))
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(e.Name(), ".json"), 10, 64)
+		if err != nil || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		b.segments = append(b.segments, seq)
+		if seq > b.seq {
+			b.seq = seq
+		}
+	}
+	sort.Slice(b.segments, func(i, j int) bool { return b.segments[i] < b.segments[j] })
+	if len(b.segments) > 0 {
+		logger.Info(fmt.Sprintf("%d buffered batches of %s will be replayed", len(b.segments), next.Name()))
+	}
+	return b, nil
+}
+
+func (b *bufferedSink) Name() string {
+	return b.next.Name()
+}
+
+// Close closes the underlying sink if it holds a connection.
+func (b *bufferedSink) Close() error {
+	if c, ok := b.next.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (b *bufferedSink) Write(ctx context.Context, samples []sample) error {
+	if err := b.replay(ctx); err != nil {
+		return b.store(samples, err)
+	}
+	if err := b.next.Write(ctx, samples); err != nil {
+		return b.store(samples, err)
+	}
+	return nil
+}
+
+// replay writes the pending segments from the oldest, and removes them on success.
+func (b *bufferedSink) replay(ctx context.Context) error {
+	for len(b.segments) > 0 {
+		path := b.path(b.segments[0])
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read buffered batch: %v", err)
+		}
+		var samples []sample
+		if err := json.Unmarshal(data, &samples); err != nil {
+			// a corrupted segment can never be replayed
+			b.logger.Error(fmt.Sprintf("discarding corrupted buffered batch %s: %v", path, err))
+		} else if err := b.next.Write(ctx, samples); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove buffered batch: %v", err)
+		}
+		b.segments = b.segments[1:]
+		if len(b.segments) == 0 {
+			b.logger.Info(fmt.Sprintf("replayed all buffered batches of %s", b.Name()))
+		}
+	}
+	return nil
+}
+
+// store appends the samples as a new segment, and returns the cause with the number of the pending batches.
+func (b *bufferedSink) store(samples []sample, cause error) error {
+	data, err := json.Marshal(samples)
+	if err != nil {
+		return fmt.Errorf("%v (failed to buffer: %v)", cause, err)
+	}
+	b.seq++
+	path := b.path(b.seq)
+	// write to a temporary file first not to leave a partial segment on crash
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("%v (failed to buffer: %v)", cause, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("%v (failed to buffer: %v)", cause, err)
+	}
+	b.segments = append(b.segments, b.seq)
+	b.truncate()
+	return fmt.Errorf("%v (%d batches buffered)", cause, len(b.segments))
+}
+
+// truncate removes the oldest segments while the total size exceeds maxSize.
+func (b *bufferedSink) truncate() {
+	if b.maxSize <= 0 {
+		return
+	}
+	var total int64
+	sizes := make([]int64, len(b.segments))
+	for i, seq := range b.segments {
+		if info, err := os.Stat(b.path(seq)); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+	}
+	dropped := 0
+	for total > b.maxSize && dropped < len(b.segments)-1 {
+		os.Remove(b.path(b.segments[dropped]))
+		total -= sizes[dropped]
+		dropped++
+	}
+	if dropped > 0 {
+		b.logger.Warn(fmt.Sprintf("dropped %d oldest buffered batches of %s to keep the buffer under %d bytes", dropped, b.Name(), b.maxSize))
+		b.segments = b.segments[dropped:]
+	}
+}
+
+func (b *bufferedSink) path(seq uint64) string {
+	return filepath.Join(b.dir, fmt.Sprintf("%020d.json", seq))
+}
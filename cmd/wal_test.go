@@ -0,0 +1,180 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// flakySink records the batches written to it, and fails while failing is true.
+type flakySink struct {
+	failing bool
+	batches [][]sample
+}
+
+func (s *flakySink) Name() string { return "flaky" }
+
+func (s *flakySink) Write(ctx context.Context, samples []sample) error {
+	if s.failing {
+		return errors.New("backend is down")
+	}
+	s.batches = append(s.batches, samples)
+	return nil
+}
+
+// batchOf returns a batch of a sample whose value identifies the batch.
+func batchOf(v float64) []sample {
+	return []sample{{Metric: "temperature", DeviceID: "d1", Value: v}}
+}
+
+// values returns the identifying values of the batches in order.
+func values(batches [][]sample) []float64 {
+	var vs []float64
+	for _, b := range batches {
+		vs = append(vs, b[0].Value)
+	}
+	return vs
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestBufferedSinkReplayOrder(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	next := &flakySink{failing: true}
+	b, err := newBufferedSink(next, dir, 0, discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for v := 1.0; v <= 3; v++ {
+		if err := b.Write(ctx, batchOf(v)); err == nil {
+			t.Fatalf("Write(%v) error = nil while the backend is down", v)
+		}
+	}
+	if len(next.batches) != 0 {
+		t.Fatalf("batches written while the backend is down: %v", values(next.batches))
+	}
+
+	// the buffered batches are replayed from the oldest before the new one
+	next.failing = false
+	if err := b.Write(ctx, batchOf(4)); err != nil {
+		t.Fatalf("Write() error = %v after the recovery", err)
+	}
+	if got, want := values(next.batches), []float64{1, 2, 3, 4}; !slices.Equal(got, want) {
+		t.Errorf("written batches = %v, want %v", got, want)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, next.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("%d segments are left after the replay", len(entries))
+	}
+}
+
+func TestBufferedSinkTruncate(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	next := &flakySink{failing: true}
+	b, err := newBufferedSink(next, dir, 0, discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Write(ctx, batchOf(1)); err == nil {
+		t.Fatal("Write() error = nil while the backend is down")
+	}
+	info, err := os.Stat(b.path(b.segments[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// room for two segments of the same size
+	b.maxSize = 2*info.Size() + 1
+
+	for v := 2.0; v <= 5; v++ {
+		if err := b.Write(ctx, batchOf(v)); err == nil {
+			t.Fatalf("Write(%v) error = nil while the backend is down", v)
+		}
+	}
+	if len(b.segments) != 2 {
+		t.Fatalf("%d segments are kept, want 2", len(b.segments))
+	}
+
+	next.failing = false
+	if err := b.Write(ctx, batchOf(6)); err != nil {
+		t.Fatalf("Write() error = %v after the recovery", err)
+	}
+	if got, want := values(next.batches), []float64{4, 5, 6}; !slices.Equal(got, want) {
+		t.Errorf("written batches = %v, want the newest ones %v", got, want)
+	}
+}
+
+func TestBufferedSinkRecovery(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	next := &flakySink{failing: true}
+	b, err := newBufferedSink(next, dir, 0, discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for v := 1.0; v <= 2; v++ {
+		if err := b.Write(ctx, batchOf(v)); err == nil {
+			t.Fatalf("Write(%v) error = nil while the backend is down", v)
+		}
+	}
+
+	// the process crashed while writing the third batch, and a segment was truncated by the disk
+	if err := os.WriteFile(b.path(3)+".tmp", []byte(`[{"metric":"temp`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b.path(2), []byte(`[{"metric":"temp`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the restarted process replays the complete segments, and discards the partial ones
+	next.failing = false
+	restarted, err := newBufferedSink(next, dir, 0, discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(b.path(3) + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("the partial temporary file is left: %v", err)
+	}
+	if err := restarted.Write(ctx, batchOf(4)); err != nil {
+		t.Fatalf("Write() error = %v after the restart", err)
+	}
+	if got, want := values(next.batches), []float64{1, 4}; !slices.Equal(got, want) {
+		t.Errorf("written batches = %v, want %v", got, want)
+	}
+
+	// the new segments do not overwrite the sequence of the old ones
+	next.failing = true
+	if err := restarted.Write(ctx, batchOf(5)); err == nil {
+		t.Fatal("Write() error = nil while the backend is down")
+	}
+	if _, err := os.Stat(restarted.path(3)); err != nil {
+		t.Errorf("the new segment is not the next of the old ones: %v", err)
+	}
+}
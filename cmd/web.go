@@ -0,0 +1,272 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statusRecorder is an http.ResponseWriter which records the status code.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// accessLog is a middleware which logs the requests to the handler.
+func accessLog(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		logger.Info("access",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("user_agent", r.UserAgent()),
+		)
+	})
+}
+
+// handlePprof registers the handlers of net/http/pprof under /debug/pprof/, requiring the bearer tokens if any.
+func handlePprof(mux *http.ServeMux, auth *bearerAuth) {
+	for path, handler := range map[string]http.HandlerFunc{
+		"/debug/pprof/":        pprof.Index,
+		"/debug/pprof/cmdline": pprof.Cmdline,
+		"/debug/pprof/profile": pprof.Profile,
+		"/debug/pprof/symbol":  pprof.Symbol,
+		"/debug/pprof/trace":   pprof.Trace,
+	} {
+		var h http.Handler = handler
+		if auth != nil {
+			h = auth.Handler(h)
+		}
+		mux.Handle(path, h)
+	}
+}
+
+// webAuthConfigured reports whether the clients are authenticated by the bearer tokens,
+// the basic authentication or the client certificates of the web configuration file, or the client certificates.
+// A web configuration file with TLS only does not count, since it lets anyone in.
+func webAuthConfigured() (bool, error) {
+	if webBearerTokenFile != "" || os.Getenv(bearerTokensEnv) != "" || webTLSClientCA != "" {
+		return true, nil
+	}
+	if webConfigFile == "" {
+		return false, nil
+	}
+	cfg, err := loadWebConfig(webConfigFile)
+	if err != nil {
+		return false, err
+	}
+	return webConfigAuthenticates(cfg), nil
+}
+
+// checkDebugAuth returns an error if the debug endpoints are enabled without authentication.
+// The raw responses have the serial numbers and the MAC addresses of the devices,
+// the state has the devices and the leader, and /debug/pprof/cmdline has the token in the arguments.
+func checkDebugAuth() error {
+	for _, debug := range []struct {
+		flag    string
+		enabled bool
+	}{
+		{"web.enable-debug-api", webEnableDebugAPI},
+		{"web.enable-debug-state", webEnableDebugState},
+		{"web.enable-pprof", webEnablePprof},
+	} {
+		if !debug.enabled {
+			continue
+		}
+		ok, err := webAuthConfigured()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("--%s requires authentication by --web.bearer-token-file, basic_auth_users or client_auth_type RequireAndVerifyClientCert of --web.config.file, or --web.tls-client-ca", debug.flag)
+		}
+	}
+	return nil
+}
+
+// webTLSEnabled reports whether the web server serves HTTPS by the web flags.
+func webTLSEnabled() (bool, error) {
+	if webConfigFile != "" {
+		cfg, err := loadWebConfig(webConfigFile)
+		if err != nil {
+			return false, err
+		}
+		return webConfigTLSEnabled(cfg), nil
+	}
+	return webTLSCert != "", nil
+}
+
+// newServer creates an HTTP server with the timeouts configured by the web flags.
+func newServer(address string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: webReadHeaderTimeout,
+		ReadTimeout:       webReadTimeout,
+		WriteTimeout:      webWriteTimeout,
+		IdleTimeout:       webIdleTimeout,
+	}
+}
+
+// configureServer configures TLS, authentication and the access log of the server by the web flags.
+// The access log wraps the authentication, so that the rejected requests are logged too.
+func configureServer(server *http.Server, logger *slog.Logger) error {
+	if err := configureTLS(server, logger); err != nil {
+		return err
+	}
+	if webAccessLog {
+		server.Handler = accessLog(logger, server.Handler)
+	}
+	return nil
+}
+
+// configureTLS configures TLS and the basic authentication of the server by the web flags.
+func configureTLS(server *http.Server, logger *slog.Logger) error {
+	if webConfigFile != "" {
+		if webTLSCert != "" || webTLSKey != "" {
+			return fmt.Errorf("--web.config.file cannot be used with --web.tls-cert and --web.tls-key")
+		}
+		loader, err := newWebConfigLoader(webConfigFile, logger)
+		if err != nil {
+			return err
+		}
+		server.Handler = loader.Handler(server.Handler)
+
+		cfg, _ := loader.Get()
+		if !cfg.HTTPConfig.HTTP2 {
+			server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		}
+		if webConfigTLSEnabled(cfg) {
+			server.TLSConfig = &tls.Config{GetConfigForClient: loader.GetConfigForClient}
+		}
+		return nil
+	}
+
+	if webTLSClientCA != "" && webTLSCert == "" {
+		return fmt.Errorf("--web.tls-client-ca requires --web.tls-cert and --web.tls-key")
+	}
+	if webTLSCert != "" || webTLSKey != "" {
+		if webTLSCert == "" || webTLSKey == "" {
+			return fmt.Errorf("both --web.tls-cert and --web.tls-key are required for TLS")
+		}
+		reloader, err := newCertReloader(webTLSCert, webTLSKey, logger)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}
+		if webTLSClientCA != "" {
+			pool, err := loadCertPool(webTLSClientCA)
+			if err != nil {
+				return err
+			}
+			server.TLSConfig.ClientCAs = pool
+			server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			if len(webTLSClientCNs) > 0 {
+				server.TLSConfig.VerifyPeerCertificate = verifyClientCN(webTLSClientCNs)
+			}
+		} else if len(webTLSClientCNs) > 0 {
+			return fmt.Errorf("--web.tls-client-allowed-cn requires --web.tls-client-ca")
+		}
+	}
+	return nil
+}
+
+// listen listens on the address. An address with the "unix:" prefix is a path of a unix domain socket,
+// whose permissions are set to --web.socket-mode.
+func listen(address string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(address, "unix:")
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+
+	// remove the socket left by the previous process
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %v", err)
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := strconv.ParseUint(webSocketMode, 8, 32)
+	if err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("invalid socket mode %q: %v", webSocketMode, err)
+	}
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set permissions of socket: %v", err)
+	}
+	return ln, nil
+}
+
+// systemdListenFDsStart is the first file descriptor passed by systemd socket activation.
+const systemdListenFDsStart = 3
+
+// systemdListener returns the listener passed by systemd socket activation.
+// See sd_listen_fds(3).
+func systemdListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, fmt.Errorf("no sockets passed by systemd: LISTEN_PID is not set to the process")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("no sockets passed by systemd: invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	if n > 1 {
+		return nil, fmt.Errorf("%d sockets passed by systemd, but only one is supported", n)
+	}
+	// not to pass them to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(systemdListenFDsStart, "systemd-socket")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use the socket passed by systemd: %v", err)
+	}
+	return ln, nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		want   string
+	}{
+		{name: "ok", want: "status=200"},
+		{name: "not found", status: http.StatusNotFound, want: "status=404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&buf, nil))
+			handler := accessLog(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			req.Header.Set("User-Agent", "Prometheus/2.53.0")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			log := buf.String()
+			for _, want := range []string{"msg=access", "method=GET", "path=/metrics", tt.want, "user_agent=Prometheus/2.53.0"} {
+				if !strings.Contains(log, want) {
+					t.Errorf("access log = %q, want %q", log, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAccessLogUnauthorized(t *testing.T) {
+	oldConfigFile, oldAccessLog := webConfigFile, webAccessLog
+	t.Cleanup(func() { webConfigFile, webAccessLog = oldConfigFile, oldAccessLog })
+	webConfigFile, webAccessLog = "testdata/webconfig/web_config_users.good.yml", true
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the handler is called without authentication")
+	})}
+	if err := configureServer(server, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if log := buf.String(); !strings.Contains(log, "msg=access") || !strings.Contains(log, "status=401") {
+		t.Errorf("access log = %q, want the rejected request", log)
+	}
+}
+
+// setDebugFlags sets the web flags for the test, and restores them after it.
+func setDebugFlags(t *testing.T, configFile string, debugAPI, debugState, pprof bool) {
+	t.Helper()
+	oldConfigFile, oldDebugAPI, oldDebugState, oldPprof := webConfigFile, webEnableDebugAPI, webEnableDebugState, webEnablePprof
+	oldBearerTokenFile, oldTLSClientCA := webBearerTokenFile, webTLSClientCA
+	t.Cleanup(func() {
+		webConfigFile, webEnableDebugAPI, webEnableDebugState, webEnablePprof = oldConfigFile, oldDebugAPI, oldDebugState, oldPprof
+		webBearerTokenFile, webTLSClientCA = oldBearerTokenFile, oldTLSClientCA
+	})
+	t.Setenv(bearerTokensEnv, "")
+	webConfigFile, webEnableDebugAPI, webEnableDebugState, webEnablePprof = configFile, debugAPI, debugState, pprof
+	webBearerTokenFile, webTLSClientCA = "", ""
+}
+
+func TestCheckDebugAuth(t *testing.T) {
+	endpoints := []struct {
+		name                        string
+		debugAPI, debugState, pprof bool
+	}{
+		{name: "pprof", pprof: true},
+		{name: "debug api", debugAPI: true},
+		{name: "debug state", debugState: true},
+	}
+	tests := []struct {
+		name       string
+		configFile string
+		wantErr    bool
+	}{
+		{name: "no authentication", wantErr: true},
+		{name: "TLS only", configFile: "testdata/webconfig/web_config_noAuth.good.yml", wantErr: true},
+		{name: "basic authentication", configFile: "testdata/webconfig/web_config_users.good.yml"},
+		{name: "client certificates", configFile: "testdata/webconfig/tls_config_noAuth.requireandverifyclientcert.good.yml"},
+	}
+	for _, e := range endpoints {
+		for _, tt := range tests {
+			t.Run(e.name+"/"+tt.name, func(t *testing.T) {
+				setDebugFlags(t, tt.configFile, e.debugAPI, e.debugState, e.pprof)
+				if err := checkDebugAuth(); (err != nil) != tt.wantErr {
+					t.Errorf("checkDebugAuth() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			})
+		}
+	}
+}
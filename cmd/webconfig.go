@@ -0,0 +1,241 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/exporter-toolkit/web"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// loadWebConfig loads the web configuration file of prometheus/exporter-toolkit,
+// so that the exporter works with the same files as the official exporters.
+// The file is validated by the toolkit itself, and relative paths are resolved from the directory of the file.
+// See https://github.com/prometheus/exporter-toolkit/blob/master/docs/web-configuration.md
+func loadWebConfig(path string) (*web.Config, error) {
+	if err := web.Validate(path); err != nil {
+		return nil, fmt.Errorf("invalid web config file: %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read web config file: %v", err)
+	}
+	// the same defaults as the toolkit
+	cfg := &web.Config{
+		TLSConfig: web.TLSConfig{
+			MinVersion:               tls.VersionTLS12,
+			MaxVersion:               tls.VersionTLS13,
+			PreferServerCipherSuites: true,
+		},
+		HTTPConfig: web.HTTPConfig{HTTP2: true},
+	}
+	if err := yaml.UnmarshalStrict(b, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse web config file: %v", err)
+	}
+	cfg.TLSConfig.SetDirectory(filepath.Dir(path))
+	return cfg, nil
+}
+
+// webConfigTLSEnabled reports whether the server should serve HTTPS.
+// The toolkit serves HTTP only when none of the TLS settings are given.
+func webConfigTLSEnabled(cfg *web.Config) bool {
+	t := cfg.TLSConfig
+	return t.TLSCertPath != "" || t.TLSCert != "" || t.TLSKeyPath != "" || t.TLSKey != "" ||
+		t.ClientCAs != "" || t.ClientCAsText != "" || t.ClientAuth != ""
+}
+
+// webConfigAuthenticates reports whether the clients are authenticated by the configuration,
+// by the basic authentication or the verified client certificates.
+func webConfigAuthenticates(cfg *web.Config) bool {
+	return len(cfg.Users) > 0 || cfg.TLSConfig.ClientAuth == "RequireAndVerifyClientCert"
+}
+
+// webConfigLoader holds the web configuration, and reloads it when the file is modified.
+// The TLS settings are applied to new connections and the users to new requests,
+// so that certificates and passwords can be rotated without restarting the exporter.
+type webConfigLoader struct {
+	path   string
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	config  *web.Config
+	tls     *tls.Config
+	modTime time.Time
+
+	// authCache caches the successful results of bcrypt, which is slow by design.
+	// The keys include the hashes, so it does not need to be cleared on reload.
+	authMu    sync.Mutex
+	authCache map[[sha256.Size]byte]struct{}
+	// userLocks serialize the bcrypt comparisons of each user,
+	// so that concurrent requests cannot use up the CPU with the same user.
+	userLocks map[string]*sync.Mutex
+}
+
+// authCacheSize is the maximum number of the successful authentications to cache.
+const authCacheSize = 100
+
+func newWebConfigLoader(path string, logger *slog.Logger) (*webConfigLoader, error) {
+	l := &webConfigLoader{path: path, logger: logger}
+	if err := l.reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *webConfigLoader) reload() error {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat web config file: %v", err)
+	}
+	cfg, err := loadWebConfig(l.path)
+	if err != nil {
+		return err
+	}
+	var tlsConfig *tls.Config
+	if webConfigTLSEnabled(cfg) {
+		// the certificate is read on each handshake, so it can be rotated without modifying the configuration file
+		tlsConfig, err = web.ConfigToTLSConfig(&cfg.TLSConfig)
+		if err != nil {
+			return err
+		}
+	}
+	l.config = cfg
+	l.tls = tlsConfig
+	l.modTime = info.ModTime()
+	return nil
+}
+
+// Get returns the configuration, reloading it if the file has been modified.
+// If the reload fails, the previous configuration keeps being used.
+func (l *webConfigLoader) Get() (*web.Config, *tls.Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if info, err := os.Stat(l.path); err == nil && info.ModTime().After(l.modTime) {
+		if err := l.reload(); err != nil {
+			l.logger.Error(err.Error())
+		} else {
+			l.logger.Info("web config reloaded")
+		}
+	}
+	return l.config, l.tls
+}
+
+// GetConfigForClient is used as tls.Config.GetConfigForClient to apply the latest TLS settings.
+func (l *webConfigLoader) GetConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	_, tlsConfig := l.Get()
+	if tlsConfig == nil {
+		return nil, fmt.Errorf("TLS is disabled in the web config file")
+	}
+	return tlsConfig, nil
+}
+
+// Handler wraps the handler with the basic authentication and the response headers.
+func (l *webConfigLoader) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg, _ := l.Get()
+		for k, v := range cfg.HTTPConfig.Header {
+			w.Header().Set(k, v)
+		}
+
+		if len(cfg.Users) > 0 {
+			user, pass, ok := r.BasicAuth()
+			if !ok || !l.authenticate(cfg, user, pass) {
+				w.Header().Set("WWW-Authenticate", "Basic")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *webConfigLoader) authenticate(cfg *web.Config, user, pass string) bool {
+	secret, ok := cfg.Users[user]
+	if !ok {
+		// compare anyway to make the response time independent of the existence of the user
+		unlock := l.lockUser("")
+		defer unlock()
+		bcrypt.CompareHashAndPassword([]byte("$2y$10$QOauhQNbBCuQDKes6eFzPeMqBSjb7Mr5DUmpZ/VcEd00UAV/LDeSi"), []byte(pass))
+		return false
+	}
+
+	hash := string(secret)
+	key := sha256.Sum256([]byte(user + "\x00" + hash + "\x00" + pass))
+	if l.cached(key) {
+		return true
+	}
+	unlock := l.lockUser(user)
+	defer unlock()
+	// the password may have been verified while waiting for the lock
+	if l.cached(key) {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) != nil {
+		return false
+	}
+
+	l.authMu.Lock()
+	defer l.authMu.Unlock()
+	if l.authCache == nil {
+		l.authCache = make(map[[sha256.Size]byte]struct{})
+	}
+	if len(l.authCache) >= authCacheSize {
+		// evict an arbitrary entry, which only costs one more bcrypt comparison
+		for k := range l.authCache {
+			delete(l.authCache, k)
+			break
+		}
+	}
+	l.authCache[key] = struct{}{}
+	return true
+}
+
+func (l *webConfigLoader) cached(key [sha256.Size]byte) bool {
+	l.authMu.Lock()
+	defer l.authMu.Unlock()
+	_, ok := l.authCache[key]
+	return ok
+}
+
+// lockUser locks the bcrypt comparisons of the user, and returns the function to unlock them.
+// The unknown users share the lock of the empty name.
+func (l *webConfigLoader) lockUser(user string) func() {
+	l.authMu.Lock()
+	if l.userLocks == nil {
+		l.userLocks = make(map[string]*sync.Mutex)
+	}
+	mu, ok := l.userLocks[user]
+	if !ok {
+		mu = &sync.Mutex{}
+		l.userLocks[user] = mu
+	}
+	l.authMu.Unlock()
+
+	mu.Lock()
+	return mu.Unlock
+}
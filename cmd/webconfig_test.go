@@ -0,0 +1,128 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLoadWebConfigToolkitTestdata loads the test files of exporter-toolkit,
+// whose names tell whether the toolkit accepts them.
+func TestLoadWebConfigToolkitTestdata(t *testing.T) {
+	// the toolkit rejects them on the TLS handshakes, not when loading them
+	rejectedOnHandshake := map[string]bool{
+		"web_config_auth_clientCAs_missing.bad.yml": true,
+		"web_config_auth_client_san.bad.yaml":       true,
+	}
+	// the toolkit looks up the cipher suites in tls.CipherSuites,
+	// which no longer has the RSA key exchange suites in recent Go versions
+	rsaKeyExchange := map[string]bool{
+		"web_config_noAuth_allCiphers.good.yml":  true,
+		"web_config_noAuth_noHTTP2.good.yml":     true,
+		"web_config_noAuth_someCiphers.good.yml": true,
+	}
+
+	paths, err := filepath.Glob("testdata/webconfig/*.y*ml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		name := filepath.Base(path)
+		var wantErr bool
+		switch {
+		case strings.Contains(name, ".good."):
+		case strings.Contains(name, ".bad."):
+			wantErr = true
+		default:
+			continue
+		}
+		if rejectedOnHandshake[name] {
+			wantErr = false
+		}
+		t.Run(name, func(t *testing.T) {
+			if rsaKeyExchange[name] {
+				t.Skip("the cipher suites are not supported by the Go version")
+			}
+			cfg, err := loadWebConfig(path)
+			if (err != nil) != wantErr {
+				t.Fatalf("loadWebConfig() error = %v, wantErr %v", err, wantErr)
+			}
+			if err != nil {
+				return
+			}
+			// the toolkit keys are decoded, not only accepted
+			loader, err := newWebConfigLoader(path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, tlsConfig := loader.Get(); (tlsConfig != nil) != webConfigTLSEnabled(cfg) {
+				t.Errorf("TLS config = %v, want enabled %v", tlsConfig, webConfigTLSEnabled(cfg))
+			}
+		})
+	}
+}
+
+func TestLoadWebConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{name: "empty", config: ""},
+		{name: "basic auth", config: "basic_auth_users:\n  alice: $2y$10$QOauhQNbBCuQDKes6eFzPeMqBSjb7Mr5DUmpZ/VcEd00UAV/LDeSi\n"},
+		{name: "invalid hash", config: "basic_auth_users:\n  alice: password\n", wantErr: true},
+		{name: "typo of basic_auth_users", config: "basic_auth_user:\n  alice: $2y$10$abc\n", wantErr: true},
+		{name: "typo of tls_server_config", config: "tls_server_confg:\n  cert_file: server.crt\n", wantErr: true},
+		{name: "unknown nested key", config: "tls_server_config:\n  cert_path: server.crt\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "web.yml")
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadWebConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("loadWebConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadWebConfigToolkitKeys(t *testing.T) {
+	cfg, err := loadWebConfig("testdata/webconfig/web_config_noAuth_allCurves.good.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(cfg.TLSConfig.CurvePreferences); got != 4 {
+		t.Errorf("curve_preferences = %d curves, want 4", got)
+	}
+	if want := filepath.Join("testdata", "webconfig", "server.crt"); cfg.TLSConfig.TLSCertPath != want {
+		t.Errorf("cert_file = %q, want %q resolved from the directory of the file", cfg.TLSConfig.TLSCertPath, want)
+	}
+
+	cfg, err = loadWebConfig("testdata/webconfig/web_config_headers.good.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.HTTPConfig.Header["X-Frame-Options"]; got != "deny" {
+		t.Errorf("headers[X-Frame-Options] = %q, want %q", got, "deny")
+	}
+}
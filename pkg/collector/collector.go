@@ -0,0 +1,164 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package collector collects the sensor values of Nature Remo devices from the Nature Remo Cloud API
+// as Prometheus metrics, so that they can be embedded into other exporters.
+//
+//	reg := prometheus.NewRegistry()
+//	c := collector.New(collector.NewClientFetcher(natureremo.NewClient(token)), collector.Options{}, reg)
+//	if _, err := c.Update(ctx); err != nil {
+//		log.Print(err)
+//	}
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tenntenn/natureremo"
+)
+
+// Options configures a Collector.
+type Options struct {
+	// SensorTimestamps exports the sensor values with the time when they were created instead of the scrape time.
+	// The values older than SensorTimestampsMaxAge are not exported.
+	SensorTimestamps       bool
+	SensorTimestampsMaxAge time.Duration
+	// NativeHistogramBucketFactor makes the duration histograms native histograms with the factor if it is greater than 1.
+	NativeHistogramBucketFactor float64
+	// Conformance renames the metrics of the sensors to follow the naming conventions of Prometheus,
+	// and ConformanceLegacyNames also exports them by the old names.
+	Conformance            bool
+	ConformanceLegacyNames bool
+	// MaxSampleAge stops exporting the values of the sensors except the movements older than it,
+	// or MaxSampleAgeNaN exports NaN instead of them.
+	MaxSampleAge    time.Duration
+	MaxSampleAgeNaN bool
+	// StaleAfter stops exporting the sensor values of the devices not refreshed for it,
+	// e.g. while the API is failing, instead of the cached values. The cached values are kept forever if it is 0.
+	StaleAfter time.Duration
+	// Window exports the minimum, the maximum and the average of the sensor values over it if it is greater than 0.
+	Window time.Duration
+	// TrendWindow exports the rate of change of the temperature per hour over it if it is greater than 0.
+	TrendWindow time.Duration
+	// MovementDedupWindow is the tolerance of the creation time of the same movement event.
+	MovementDedupWindow time.Duration
+	// Plugins are registered to the registerer with the metrics, and updated with the devices by each update.
+	Plugins []Plugin
+	// Now returns the current time to measure the ages of the sensor values and the staleness of the devices by.
+	// It is time.Now if nil.
+	Now func() time.Time
+}
+
+// Collector updates the metrics from the Nature Remo API.
+// It is not safe to call Update concurrently.
+type Collector struct {
+	fetcher DeviceFetcher
+	metrics *Metrics
+	plugins []Plugin
+}
+
+// New creates a Collector which fetches the devices with the fetcher, and registers the metrics to the registerer.
+func New(fetcher DeviceFetcher, opts Options, reg prometheus.Registerer) *Collector {
+	metrics := NewMetrics()
+	if opts.Now != nil {
+		metrics.SetClock(opts.Now)
+	}
+	if opts.SensorTimestamps {
+		metrics.EnableSensorTimestamps(opts.SensorTimestampsMaxAge)
+	}
+	if opts.NativeHistogramBucketFactor > 1 {
+		metrics.EnableNativeHistograms(opts.NativeHistogramBucketFactor)
+	}
+	metrics.SetMovementDedupWindow(opts.MovementDedupWindow)
+	metrics.SetMaxSampleAge(opts.MaxSampleAge, opts.MaxSampleAgeNaN)
+	metrics.SetStaleAfter(opts.StaleAfter)
+	if opts.Window > 0 {
+		metrics.EnableWindowAggregates(opts.Window)
+	}
+	if opts.TrendWindow > 0 {
+		metrics.EnableTemperatureTrend(opts.TrendWindow)
+	}
+	if opts.Conformance {
+		metrics.EnableConformance(opts.ConformanceLegacyNames)
+	}
+	metrics.MustRegister(reg)
+	for _, p := range opts.Plugins {
+		reg.MustRegister(p)
+	}
+	return &Collector{fetcher: fetcher, metrics: metrics, plugins: opts.Plugins}
+}
+
+// Metrics returns the metrics updated by the collector.
+func (c *Collector) Metrics() *Metrics {
+	return c.metrics
+}
+
+// Update fetches all devices from the Nature Remo API and reflects them to the metrics and the plugins.
+// The fetched devices are returned so that the caller can keep them, even if some plugins fail.
+func (c *Collector) Update(ctx context.Context) ([]*natureremo.Device, error) {
+	start := time.Now()
+	defer func() {
+		c.metrics.ObservePollDuration(time.Since(start))
+	}()
+
+	devices, err := c.fetcher.FetchDevices(ctx)
+	req := &APIRequest{Endpoint: "/1/devices", Duration: time.Since(start)}
+	if rl, ok := c.fetcher.(RateLimiter); ok {
+		if rl, ok := rl.RateLimit(); ok {
+			req.RateLimit = &rl
+		}
+	}
+	c.metrics.ObserveAPIRequest(req)
+	if err != nil {
+		c.Fail()
+		return nil, fmt.Errorf("failed to get all devices from Nature Remo API: %v", err)
+	}
+	c.metrics.IncAPICallsTotal()
+	return devices, c.Reflect(ctx, devices, devices)
+}
+
+// Reflect reflects the devices fetched by a successful update to the metrics and the plugins,
+// for the callers which fetch the devices by themselves, e.g. with retries or conditional requests.
+// Only the changed devices are set to the metrics, and the others are marked as refreshed not to go stale.
+// The plugins are updated with all devices, and their error is returned after the metrics are updated.
+func (c *Collector) Reflect(ctx context.Context, devices, changed []*natureremo.Device) error {
+	ids := make([]string, len(devices))
+	for i, device := range devices {
+		ids[i] = device.ID
+	}
+	c.metrics.MarkRefreshed(ids)
+	c.metrics.RetainDevices(ids)
+	if err := c.metrics.Set(changed); err != nil {
+		return fmt.Errorf("failed to set metrics: %v", err)
+	}
+	c.metrics.ResetConsecutiveFailures()
+	c.metrics.SetUp(true)
+	c.metrics.SetUpdateSuccess("devices", true)
+	if err := updatePlugins(ctx, c.plugins, devices); err != nil {
+		return fmt.Errorf("failed to update plugins: %v", err)
+	}
+	return nil
+}
+
+// Fail records a failed update, keeping the metrics of the last successful one.
+func (c *Collector) Fail() {
+	c.metrics.IncConsecutiveFailures()
+	c.metrics.SetUp(false)
+	c.metrics.SetUpdateSuccess("devices", false)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/imishinist/nature-remo-exporter/pkg/collector"
+	"github.com/imishinist/nature-remo-exporter/pkg/collector/collectortest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tenntenn/natureremo"
+)
+
+func TestCollectorUpdate(t *testing.T) {
+	fetcher := &collectortest.Fetcher{}
+	fetcher.SetDevices(collectortest.Device("d1", "living", map[natureremo.SensorType]float64{
+		natureremo.SensorTypeTemperature: 21.5,
+	}, time.Now()))
+	reg := prometheus.NewRegistry()
+	c := collector.New(fetcher, collector.Options{}, reg)
+	m := c.Metrics()
+
+	devices, err := c.Update(context.Background())
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if len(devices) != 1 {
+		t.Fatalf("Update() returned %d devices, want 1", len(devices))
+	}
+	if got := testutil.ToFloat64(m.Temperature.WithLabelValues("d1", "living", "", "", "", "")); got != 21.5 {
+		t.Errorf("temperature = %v, want 21.5", got)
+	}
+	if got := testutil.ToFloat64(m.Up); got != 1 {
+		t.Errorf("up = %v, want 1", got)
+	}
+
+	// a failure keeps the values of the last successful update
+	fetcher.SetError(errors.New("unavailable"))
+	if _, err := c.Update(context.Background()); err == nil {
+		t.Fatal("Update() succeeded with a failing fetcher")
+	}
+	if got := testutil.ToFloat64(m.Up); got != 0 {
+		t.Errorf("up = %v, want 0", got)
+	}
+	if got := testutil.ToFloat64(m.ConsecutiveFailures); got != 1 {
+		t.Errorf("consecutive failures = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.Temperature.WithLabelValues("d1", "living", "", "", "", "")); got != 21.5 {
+		t.Errorf("temperature = %v after a failure, want 21.5", got)
+	}
+
+	fetcher.SetError(nil)
+	if _, err := c.Update(context.Background()); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if got := testutil.ToFloat64(m.ConsecutiveFailures); got != 0 {
+		t.Errorf("consecutive failures = %v after a success, want 0", got)
+	}
+}
+
+type failingPlugin struct {
+	updates int
+}
+
+func (p *failingPlugin) Describe(chan<- *prometheus.Desc) {}
+
+func (p *failingPlugin) Collect(chan<- prometheus.Metric) {}
+
+func (p *failingPlugin) Update(context.Context, []*natureremo.Device) error {
+	p.updates++
+	return errors.New("plugin failed")
+}
+
+func TestCollectorUpdatePluginError(t *testing.T) {
+	fetcher := &collectortest.Fetcher{}
+	fetcher.SetDevices(collectortest.Device("d1", "living", nil, time.Now()))
+	plugin := &failingPlugin{}
+	c := collector.New(fetcher, collector.Options{Plugins: []collector.Plugin{plugin}}, prometheus.NewRegistry())
+
+	devices, err := c.Update(context.Background())
+	if err == nil {
+		t.Fatal("Update() succeeded with a failing plugin")
+	}
+	if len(devices) != 1 {
+		t.Errorf("Update() returned %d devices with a failing plugin, want 1", len(devices))
+	}
+	if plugin.updates != 1 {
+		t.Errorf("plugin updated %d times, want 1", plugin.updates)
+	}
+	// the plugins do not fail the update of the devices
+	if got := testutil.ToFloat64(c.Metrics().Up); got != 1 {
+		t.Errorf("up = %v, want 1", got)
+	}
+}
+
+func TestCollectorReflectRefreshesUnchangedDevices(t *testing.T) {
+	const staleAfter = 10 * time.Minute
+	// the clock moves only when the test moves it
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fetcher := &collectortest.Fetcher{}
+	fetcher.SetDevices(collectortest.Device("d1", "living", map[natureremo.SensorType]float64{
+		natureremo.SensorTypeTemperature: 21.5,
+	}, now))
+	reg := prometheus.NewRegistry()
+	c := collector.New(fetcher, collector.Options{StaleAfter: staleAfter, Now: func() time.Time { return now }}, reg)
+
+	devices, err := c.Update(context.Background())
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	// the devices are fetched but not changed, e.g. by a conditional request
+	for i := 0; i < 3; i++ {
+		now = now.Add(staleAfter / 2)
+		if err := c.Reflect(context.Background(), devices, nil); err != nil {
+			t.Fatalf("Reflect() error = %v", err)
+		}
+	}
+	if n, err := testutil.GatherAndCount(reg, "nature_remo_temperature"); err != nil || n != 1 {
+		t.Errorf("temperature series = %d, %v, want 1 while the device is refreshed", n, err)
+	}
+
+	now = now.Add(staleAfter * 3 / 2)
+	if n, err := testutil.GatherAndCount(reg, "nature_remo_temperature"); err != nil || n != 0 {
+		t.Errorf("temperature series = %d, %v, want 0 after the device is not refreshed", n, err)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package collectortest provides a fake DeviceFetcher to test the collection without the Nature Remo API.
+package collectortest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tenntenn/natureremo"
+)
+
+// Fetcher is a fake collector.DeviceFetcher which returns the devices and the appliances set to it.
+type Fetcher struct {
+	mu         sync.Mutex
+	devices    []*natureremo.Device
+	appliances []*natureremo.Appliance
+	err        error
+	calls      int
+}
+
+// SetDevices sets the devices returned by the following calls of FetchDevices.
+func (f *Fetcher) SetDevices(devices ...*natureremo.Device) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.devices = devices
+}
+
+// SetAppliances sets the appliances returned by the following calls of FetchAppliances.
+func (f *Fetcher) SetAppliances(appliances ...*natureremo.Appliance) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.appliances = appliances
+}
+
+// SetError makes the following calls fail with the error, or succeed again if it is nil.
+func (f *Fetcher) SetError(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+// Calls returns the number of the calls of FetchDevices and FetchAppliances.
+func (f *Fetcher) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func (f *Fetcher) FetchDevices(ctx context.Context) ([]*natureremo.Device, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.devices, nil
+}
+
+func (f *Fetcher) FetchAppliances(ctx context.Context) ([]*natureremo.Appliance, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.appliances, nil
+}
+
+// Device returns a device with the sensor values created at the time.
+// The time of a movement is the one of the movement sensor, so a new time counts a movement.
+func Device(id, name string, values map[natureremo.SensorType]float64, createdAt time.Time) *natureremo.Device {
+	events := make(map[natureremo.SensorType]natureremo.SensorValue, len(values))
+	for sensor, v := range values {
+		events[sensor] = natureremo.SensorValue{Value: v, CreatedAt: createdAt}
+	}
+	return &natureremo.Device{
+		DeviceCore:   natureremo.DeviceCore{ID: id, Name: name},
+		NewestEvents: events,
+	}
+}
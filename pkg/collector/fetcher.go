@@ -0,0 +1,60 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+
+	"github.com/tenntenn/natureremo"
+)
+
+// DeviceFetcher fetches the devices and the appliances of an account.
+// It abstracts the Nature Remo API, so that the collection can be tested with a fake.
+type DeviceFetcher interface {
+	FetchDevices(ctx context.Context) ([]*natureremo.Device, error)
+	FetchAppliances(ctx context.Context) ([]*natureremo.Appliance, error)
+}
+
+// RateLimiter is implemented by the DeviceFetchers which know the rate limit told by the last response.
+type RateLimiter interface {
+	RateLimit() (RateLimit, bool)
+}
+
+// NewClientFetcher returns a DeviceFetcher which fetches from the Nature Remo API with the client.
+func NewClientFetcher(client *natureremo.Client) DeviceFetcher {
+	return &clientFetcher{client: client}
+}
+
+type clientFetcher struct {
+	client *natureremo.Client
+}
+
+func (f *clientFetcher) FetchDevices(ctx context.Context) ([]*natureremo.Device, error) {
+	return f.client.DeviceService.GetAll(ctx)
+}
+
+func (f *clientFetcher) FetchAppliances(ctx context.Context) ([]*natureremo.Appliance, error) {
+	return f.client.ApplianceService.GetAll(ctx)
+}
+
+func (f *clientFetcher) RateLimit() (RateLimit, bool) {
+	rl := f.client.LastRateLimit
+	if rl == nil {
+		return RateLimit{}, false
+	}
+	return RateLimit{Limit: int(rl.Limit), Remaining: int(rl.Remaining), Reset: rl.Reset}, true
+}
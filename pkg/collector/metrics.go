@@ -0,0 +1,669 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"maps"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tenntenn/natureremo"
+)
+
+// Metrics is the set of the metrics of the exporter.
+// It is not safe for concurrent use except for the collection by the registry.
+type Metrics struct {
+	APICallsTotal       *prometheus.CounterVec
+	ConsecutiveFailures *prometheus.GaugeVec
+	Up                  *prometheus.GaugeVec
+	UpdateSuccess       *prometheus.GaugeVec
+	CircuitBreakerState *prometheus.GaugeVec
+	APIRequestDuration  *prometheus.HistogramVec
+	APIRequestPhases    *prometheus.HistogramVec
+	PollDuration        *prometheus.HistogramVec
+
+	Temperature  *prometheus.GaugeVec
+	Humidity     *prometheus.GaugeVec
+	Illumination *prometheus.GaugeVec
+	Movement     *prometheus.GaugeVec
+
+	// MovementsTotal is labeled only by the device ID, so that the counter continues across the changes of the other labels,
+	// e.g. by a firmware update. The other labels are joined from DeviceInfo by the ID.
+	MovementsTotal *prometheus.CounterVec
+	DeviceInfo     *prometheus.GaugeVec
+
+	InternalErrorsTotal *prometheus.CounterVec
+
+	LocalReachable *prometheus.GaugeVec
+
+	Leader *prometheus.GaugeVec
+
+	lastMovements map[string]time.Time
+	// movementDedupWindow is the tolerance of the creation time of the same movement event.
+	movementDedupWindow time.Duration
+	// deviceInfo is the labels of DeviceInfo of each device.
+	deviceInfo map[string]prometheus.Labels
+	// movementCounts is the value of MovementsTotal of each device, to save it in the state.
+	movementCounts map[string]float64
+	// restoredMovements is the value of MovementsTotal restored from the state,
+	// which is added to the counter when the device is seen, not to export the counters of the removed devices.
+	restoredMovements map[string]float64
+
+	// legacy is the metrics by the names before EnableConformance, which are updated along with the renamed ones
+	// during the deprecation period of the names.
+	legacy *legacyMetrics
+
+	// sensorTimestamps holds the creation time of the sensor values when the timestamps are exported or the stale values are dropped.
+	sensorTimestamps *sensorTimestamps
+	exportTimestamps bool
+	timestampsMaxAge time.Duration
+	maxSampleAge     time.Duration
+	maxSampleAgeNaN  bool
+	staleAfter       time.Duration
+
+	// history holds the recent readings of the sensors when they are aggregated over the window or the trend is exported.
+	history     *sensorHistory
+	window      time.Duration
+	trendWindow time.Duration
+	// conformance is whether the metrics are renamed by EnableConformance.
+	conformance bool
+
+	// rateLimit is the rate limit told by the last response, if any.
+	rateLimit     RateLimit
+	rateLimitSeen bool
+
+	// now returns the current time to age the sensor values by, which is replaced by SetClock.
+	now func() time.Time
+}
+
+// deviceLabels is the labels of the metrics of the devices.
+var deviceLabels = []string{
+	"id",
+	"name",
+	"firmware_version",
+	"bt_mac_address",
+	"mac_address",
+	"serial_number",
+}
+
+// NewMetrics creates the metrics. They are registered to a registry by MustRegister.
+func NewMetrics() *Metrics {
+	namespace := "nature_remo"
+
+	apiCallsTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_calls_total",
+		Help:      "Total number of API calls",
+	}, []string{})
+	consecutiveFailures := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consecutive_failures",
+		Help:      "Number of consecutive failed updates",
+	}, []string{})
+
+	up := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "up",
+		Help:      "Whether the last poll of the Nature Remo API succeeded (1) or failed (0)",
+	}, []string{})
+	updateSuccess := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "update_success",
+		Help:      "Whether the last update of the target succeeded (1) or failed (0)",
+	}, []string{"target"})
+	circuitBreakerState := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "circuit_breaker_state",
+		Help:      "State of the circuit breaker of the API calls (0: closed, 1: open, 2: half-open)",
+	}, []string{})
+
+	apiRequestDuration, apiRequestPhases, pollDuration := newDurationHistograms(0)
+
+	temperature := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "temperature",
+		Help:      "current temperature",
+	}, deviceLabels)
+	humidity := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "humidity",
+		Help:      "current humidity",
+	}, deviceLabels)
+	illumination := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "illumination",
+		Help:      "current illumination",
+	}, deviceLabels)
+	movement := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "movement",
+		Help:      "current movement",
+	}, deviceLabels)
+
+	movementsTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "movements_total",
+		Help:      "Total number of the movements of the device",
+	}, []string{"id"})
+	deviceInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "device_info",
+		Help:      "Labels of the device, which is always 1",
+	}, deviceLabels)
+
+	internalErrorsTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "internal_errors_total",
+		Help:      "Total number of panics recovered in the polls",
+	}, []string{})
+	// initialize the counter to export it before any panic
+	internalErrorsTotal.WithLabelValues()
+
+	localReachable := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "local_reachable",
+		Help:      "Whether the device is reachable on the LAN (1) or not (0)",
+	}, []string{"device"})
+
+	leader := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "Whether the replica is the leader which polls the API (1) or not (0) in the HA mode",
+	}, []string{})
+	return &Metrics{
+		now:                 time.Now,
+		APICallsTotal:       apiCallsTotal,
+		ConsecutiveFailures: consecutiveFailures,
+		Up:                  up,
+		UpdateSuccess:       updateSuccess,
+		CircuitBreakerState: circuitBreakerState,
+		APIRequestDuration:  apiRequestDuration,
+		APIRequestPhases:    apiRequestPhases,
+		PollDuration:        pollDuration,
+		Temperature:         temperature,
+		Humidity:            humidity,
+		Illumination:        illumination,
+		Movement:            movement,
+		MovementsTotal:      movementsTotal,
+		DeviceInfo:          deviceInfo,
+		InternalErrorsTotal: internalErrorsTotal,
+		LocalReachable:      localReachable,
+		Leader:              leader,
+
+		lastMovements:     make(map[string]time.Time),
+		movementCounts:    make(map[string]float64),
+		deviceInfo:        make(map[string]prometheus.Labels),
+		restoredMovements: make(map[string]float64),
+	}
+}
+
+// newDurationHistograms creates the histograms of the API request duration, the durations of its phases and the poll duration.
+// They are native histograms with the bucket factor as well as classic ones if the factor is greater than 1.
+func newDurationHistograms(nativeBucketFactor float64) (apiRequestDuration, apiRequestPhases, pollDuration *prometheus.HistogramVec) {
+	opts := func(name, help string) prometheus.HistogramOpts {
+		opts := prometheus.HistogramOpts{
+			Namespace: "nature_remo",
+			Name:      name,
+			Help:      help,
+		}
+		if nativeBucketFactor > 1 {
+			opts.NativeHistogramBucketFactor = nativeBucketFactor
+			opts.NativeHistogramMaxBucketNumber = 100
+			opts.NativeHistogramMinResetDuration = time.Hour
+		}
+		return opts
+	}
+	apiRequestDuration = prometheus.NewHistogramVec(opts("api_request_duration_seconds", "Duration of the requests to the Nature Remo API"), []string{"endpoint"})
+	apiRequestPhases = prometheus.NewHistogramVec(opts("api_request_phase_duration_seconds", "Duration of the phases of the requests to the Nature Remo API (dns, connect, tls and ttfb)"), []string{"endpoint", "phase"})
+	pollDuration = prometheus.NewHistogramVec(opts("poll_duration_seconds", "Duration of the updates"), []string{})
+	return apiRequestDuration, apiRequestPhases, pollDuration
+}
+
+// EnableNativeHistograms makes the duration histograms native histograms with the bucket factor.
+// It must be called before MustRegister.
+func (m *Metrics) EnableNativeHistograms(bucketFactor float64) {
+	m.APIRequestDuration, m.APIRequestPhases, m.PollDuration = newDurationHistograms(bucketFactor)
+}
+
+// MustRegister registers all exporter metrics to the given registerer.
+func (m *Metrics) MustRegister(reg prometheus.Registerer) {
+	reg.MustRegister(m.APICallsTotal, m.ConsecutiveFailures, m.Up, m.UpdateSuccess, m.CircuitBreakerState, m.APIRequestDuration, m.APIRequestPhases, m.PollDuration)
+	reg.MustRegister(m.MovementsTotal, m.DeviceInfo, m.InternalErrorsTotal, m.LocalReachable, m.Leader)
+	m.mustRegisterSensors(reg, map[natureremo.SensorType]*prometheus.GaugeVec{
+		natureremo.SensorTypeTemperature:  m.Temperature,
+		natureremo.SensorTypeHumidity:     m.Humidity,
+		natureremo.SensorTypeIllumination: m.Illumination,
+		natureremo.SensorTypeMovement:     m.Movement,
+	})
+	if m.window > 0 {
+		c := newWindowCollector(m.history, m.window, m.conformance)
+		c.timestamps, c.staleAfter, c.now = m.sensorTimestamps, m.staleAfter, m.clock
+		c.maxSampleAge, c.nan = m.maxSampleAge, m.maxSampleAgeNaN
+		reg.MustRegister(c)
+	}
+	if m.trendWindow > 0 {
+		c := newTrendCollector(m.history, m.trendWindow, m.conformance)
+		c.timestamps, c.staleAfter, c.now = m.sensorTimestamps, m.staleAfter, m.clock
+		c.maxSampleAge, c.nan = m.maxSampleAge, m.maxSampleAgeNaN
+		reg.MustRegister(c)
+	}
+	if m.legacy != nil {
+		reg.MustRegister(m.legacy.MovementsTotal)
+		m.mustRegisterSensors(reg, map[natureremo.SensorType]*prometheus.GaugeVec{
+			natureremo.SensorTypeTemperature: m.legacy.Temperature,
+			natureremo.SensorTypeHumidity:    m.legacy.Humidity,
+		})
+	}
+}
+
+// mustRegisterSensors registers the gauges of the sensors, which are collected by the creation time of the values if it is tracked.
+func (m *Metrics) mustRegisterSensors(reg prometheus.Registerer, gauges map[natureremo.SensorType]*prometheus.GaugeVec) {
+	if m.sensorTimestamps != nil {
+		reg.MustRegister(&sensorCollector{
+			gauges:           gauges,
+			timestamps:       m.sensorTimestamps,
+			export:           m.exportTimestamps,
+			timestampsMaxAge: m.timestampsMaxAge,
+			maxSampleAge:     m.maxSampleAge,
+			nan:              m.maxSampleAgeNaN,
+			staleAfter:       m.staleAfter,
+			now:              m.clock,
+		})
+		return
+	}
+	for _, gauge := range gauges {
+		reg.MustRegister(gauge)
+	}
+}
+
+// keepHistory keeps the readings of the sensors for the retention at least.
+func (m *Metrics) keepHistory(retention time.Duration) {
+	if m.history == nil {
+		m.history = newSensorHistory(retention, m.clock)
+		return
+	}
+	m.history.retention = max(m.history.retention, retention)
+}
+
+// EnableWindowAggregates exports the minimum, the maximum and the time-weighted average of the temperature,
+// the humidity and the illumination over the last window as the metrics suffixed with _min, _max and _avg,
+// so that the short spikes between the scrapes are not lost.
+// They are computed from the readings seen by the polls, so the interval should be shorter than the window.
+// It must be called before MustRegister.
+func (m *Metrics) EnableWindowAggregates(window time.Duration) {
+	m.window = window
+	m.keepHistory(window)
+}
+
+// EnableTemperatureTrend exports the rate of change of the temperature in degrees Celsius per hour
+// over the last window as nature_remo_temperature_trend, fitted to the readings seen by the polls.
+// It must be called before MustRegister.
+func (m *Metrics) EnableTemperatureTrend(window time.Duration) {
+	m.trendWindow = window
+	m.keepHistory(window)
+}
+
+// ConformanceNames maps the names of the metrics renamed by EnableConformance from the old ones.
+var ConformanceNames = map[string]string{
+	"nature_remo_temperature":     "nature_remo_temperature_celsius",
+	"nature_remo_humidity":        "nature_remo_humidity_percent",
+	"nature_remo_movements_total": "nature_remo_movement_events_total",
+}
+
+// legacyMetrics is the metrics by the names before EnableConformance.
+type legacyMetrics struct {
+	Temperature    *prometheus.GaugeVec
+	Humidity       *prometheus.GaugeVec
+	MovementsTotal *prometheus.CounterVec
+}
+
+// EnableConformance renames the metrics of the sensors to follow the naming conventions of Prometheus
+// with the units in ConformanceNames, and describes them by their help texts.
+// If legacy is true, the metrics are also exported by the old names.
+// It must be called before MustRegister.
+func (m *Metrics) EnableConformance(legacy bool) {
+	m.conformance = true
+	if legacy {
+		m.legacy = &legacyMetrics{Temperature: m.Temperature, Humidity: m.Humidity, MovementsTotal: m.MovementsTotal}
+	}
+	m.Temperature = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: ConformanceNames["nature_remo_temperature"],
+		Help: "Temperature measured by the device in degrees Celsius",
+	}, deviceLabels)
+	m.Humidity = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: ConformanceNames["nature_remo_humidity"],
+		Help: "Relative humidity measured by the device in percent",
+	}, deviceLabels)
+	m.Illumination = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nature_remo_illumination",
+		Help: "Illumination measured by the device",
+	}, deviceLabels)
+	m.Movement = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nature_remo_movement",
+		Help: "Value of the last movement event of the device",
+	}, deviceLabels)
+	m.MovementsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: ConformanceNames["nature_remo_movements_total"],
+		Help: "Total number of the movement events of the device",
+	}, []string{"id"})
+}
+
+// SetClock replaces the clock which the ages of the sensor values and the staleness of the devices are measured by,
+// which is time.Now by default.
+func (m *Metrics) SetClock(now func() time.Time) {
+	m.now = now
+}
+
+// clock returns the current time by the clock, which is passed as a function to follow SetClock.
+func (m *Metrics) clock() time.Time {
+	return m.now()
+}
+
+// EnableSensorTimestamps exports the sensor values with the time when they were created
+// instead of the scrape time. The values older than maxAge are not exported.
+// It must be called before MustRegister.
+func (m *Metrics) EnableSensorTimestamps(maxAge time.Duration) {
+	m.exportTimestamps, m.timestampsMaxAge = true, maxAge
+	if m.sensorTimestamps == nil {
+		m.sensorTimestamps = newSensorTimestamps(m.clock)
+	}
+}
+
+// SetMaxSampleAge stops exporting the values of the temperature, the humidity and the illumination
+// created longer ago than maxAge, e.g. of an unplugged device, or exports NaN instead of them if nan is true.
+// The movements are not affected, since their newest events are old while nobody moves.
+// It must be called before MustRegister.
+func (m *Metrics) SetMaxSampleAge(maxAge time.Duration, nan bool) {
+	m.maxSampleAge, m.maxSampleAgeNaN = maxAge, nan
+	if maxAge > 0 && m.sensorTimestamps == nil {
+		m.sensorTimestamps = newSensorTimestamps(m.clock)
+	}
+}
+
+func (m *Metrics) IncAPICallsTotal() {
+	m.APICallsTotal.WithLabelValues().Inc()
+}
+
+func (m *Metrics) IncInternalErrors() {
+	m.InternalErrorsTotal.WithLabelValues().Inc()
+}
+
+func (m *Metrics) IncConsecutiveFailures() {
+	m.ConsecutiveFailures.WithLabelValues().Inc()
+}
+
+func (m *Metrics) ResetConsecutiveFailures() {
+	m.ConsecutiveFailures.WithLabelValues().Set(0)
+}
+
+// SetUp records whether the last poll succeeded.
+func (m *Metrics) SetUp(up bool) {
+	v := 0.0
+	if up {
+		v = 1
+	}
+	m.Up.WithLabelValues().Set(v)
+}
+
+// SetUpdateSuccess records whether the last update of the target, such as devices or appliances, succeeded.
+func (m *Metrics) SetUpdateSuccess(target string, success bool) {
+	v := 0.0
+	if success {
+		v = 1
+	}
+	m.UpdateSuccess.WithLabelValues(target).Set(v)
+}
+
+// RateLimit returns the rate limit told by the last response. It returns false if no response has told it.
+func (m *Metrics) RateLimit() (RateLimit, bool) {
+	return m.rateLimit, m.rateLimitSeen
+}
+
+// ObserveAPIRequest records the duration of the API request and its phases, and the rate limit if it has been sent.
+// The trace ID is attached as an exemplar if the request is traced.
+// A request without the endpoint, which has not been sent, is ignored.
+func (m *Metrics) ObserveAPIRequest(res *APIRequest) {
+	if res.Endpoint == "" {
+		return
+	}
+	if res.RateLimit != nil {
+		m.rateLimit, m.rateLimitSeen = *res.RateLimit, true
+	}
+	for phase, d := range res.Phases {
+		m.APIRequestPhases.WithLabelValues(res.Endpoint, phase).Observe(d.Seconds())
+	}
+	o := m.APIRequestDuration.WithLabelValues(res.Endpoint)
+	if res.TraceID != "" {
+		o.(prometheus.ExemplarObserver).ObserveWithExemplar(res.Duration.Seconds(), prometheus.Labels{"trace_id": res.TraceID})
+		return
+	}
+	o.Observe(res.Duration.Seconds())
+}
+
+func (m *Metrics) ObservePollDuration(d time.Duration) {
+	m.PollDuration.WithLabelValues().Observe(d.Seconds())
+}
+
+func (m *Metrics) Set(devices []*natureremo.Device) error {
+	for _, device := range devices {
+		labels := prometheus.Labels{
+			"id":               device.ID,
+			"name":             device.Name,
+			"firmware_version": device.FirmwareVersion,
+			"mac_address":      device.MacAddress,
+			"bt_mac_address":   device.BtMacAddress,
+			"serial_number":    device.SerialNumber,
+		}
+		if m.sensorTimestamps != nil {
+			m.sensorTimestamps.Set(device)
+		}
+		if m.history != nil {
+			m.history.Add(device, labels)
+		}
+		// the series of the old labels are deleted, e.g. when the device is renamed,
+		// so that the old name is not exported forever and the joins by the ID do not find duplicates
+		if old, ok := m.deviceInfo[device.ID]; ok && !maps.Equal(old, labels) {
+			m.DeviceInfo.Delete(old)
+			for _, s := range m.sensorGauges() {
+				s.gauge.Delete(old)
+			}
+		}
+		m.deviceInfo[device.ID] = labels
+		m.DeviceInfo.With(labels).Set(1)
+		for _, s := range m.sensorGauges() {
+			setSensor(s.gauge, labels, device, s.sensor)
+		}
+
+		// the device without the movement sensor has no movement to count
+		movement, ok := device.NewestEvents[natureremo.SensorTypeMovement]
+
+		inc := 0.0
+		if ok && m.updateLastMovement(device.ID, movement.CreatedAt) {
+			inc = 1
+		}
+		if n, ok := m.restoredMovements[device.ID]; ok {
+			inc += n
+			delete(m.restoredMovements, device.ID)
+		}
+		m.movementCounts[device.ID] += inc
+		m.MovementsTotal.WithLabelValues(device.ID).Add(inc)
+		if m.legacy != nil {
+			m.legacy.MovementsTotal.WithLabelValues(device.ID).Add(inc)
+		}
+	}
+	return nil
+}
+
+type sensorGauge struct {
+	sensor natureremo.SensorType
+	gauge  *prometheus.GaugeVec
+}
+
+// sensorGauges returns the gauges of the sensor values, including the legacy ones.
+func (m *Metrics) sensorGauges() []sensorGauge {
+	gauges := []sensorGauge{
+		{natureremo.SensorTypeTemperature, m.Temperature},
+		{natureremo.SensorTypeHumidity, m.Humidity},
+		{natureremo.SensorTypeIllumination, m.Illumination},
+		{natureremo.SensorTypeMovement, m.Movement},
+	}
+	if m.legacy != nil {
+		gauges = append(gauges,
+			sensorGauge{natureremo.SensorTypeTemperature, m.legacy.Temperature},
+			sensorGauge{natureremo.SensorTypeHumidity, m.legacy.Humidity},
+		)
+	}
+	return gauges
+}
+
+// setSensor sets the gauge to the value of the sensor of the device,
+// or deletes it if the device does not have the sensor, e.g. Nature Remo mini without the humidity sensor,
+// so that the missing sensor is not exported as 0.
+func setSensor(gauge *prometheus.GaugeVec, labels prometheus.Labels, device *natureremo.Device, sensor natureremo.SensorType) {
+	if value, ok := device.NewestEvents[sensor]; ok {
+		gauge.With(labels).Set(value.Value)
+	} else {
+		gauge.Delete(labels)
+	}
+}
+
+// updateLastMovement records the creation time of the newest movement event of the device,
+// and reports whether it is a new movement.
+// The time apart from the last one within the dedup window is the same event with jittered timestamps,
+// and the time before the last one beyond the window is a new event after the clock of the device is corrected backwards,
+// since the newest event cannot be older than the previous newest one otherwise.
+func (m *Metrics) updateLastMovement(key string, lastMovement time.Time) bool {
+	l, ok := m.lastMovements[key]
+	m.lastMovements[key] = lastMovement
+	if !ok {
+		return false
+	}
+	d := lastMovement.Sub(l)
+	if d < 0 {
+		d = -d
+	}
+	return d > m.movementDedupWindow
+}
+
+// SetStaleAfter stops exporting the sensor values of the devices which have not been refreshed by Set or MarkRefreshed for d,
+// since the API has been failing or the device has been removed from the account,
+// so that Prometheus marks the series stale instead of the cached values being repeated forever.
+// It is 0 by default, which keeps the cached values. It must be called before MustRegister.
+func (m *Metrics) SetStaleAfter(d time.Duration) {
+	m.staleAfter = d
+	if d > 0 && m.sensorTimestamps == nil {
+		m.sensorTimestamps = newSensorTimestamps(m.clock)
+	}
+}
+
+// MarkRefreshed records that the devices of the IDs are fetched successfully even though they are not Set,
+// e.g. when the API responds that they are not modified or they are not due to be reflected,
+// so that their sensor values are not dropped by SetStaleAfter.
+func (m *Metrics) MarkRefreshed(ids []string) {
+	if m.sensorTimestamps != nil {
+		m.sensorTimestamps.MarkRefreshed(ids)
+	}
+}
+
+// RetainDevices drops the readings kept for the window aggregates and the trend of the devices other than the IDs,
+// e.g. removed from the account, so that they are not exported forever regardless of SetStaleAfter.
+func (m *Metrics) RetainDevices(ids []string) {
+	if m.history != nil {
+		m.history.Retain(ids)
+	}
+}
+
+// SetMovementDedupWindow sets the tolerance of the creation time of the same movement event,
+// so that the jittered timestamps of an event are not counted as new movements. It is 0 by default.
+func (m *Metrics) SetMovementDedupWindow(window time.Duration) {
+	m.movementDedupWindow = window
+}
+
+// RestoreState restores the last movements and the movement counters from the state,
+// so that a movement during the downtime is counted and the counters do not reset.
+// It must be called before the first Set.
+func (m *Metrics) RestoreState(st *State) {
+	for id, d := range st.Devices {
+		m.lastMovements[id] = d.LastMovement
+		m.restoredMovements[id] = d.MovementsTotal
+	}
+}
+
+// State returns the state to restore on the next start.
+// The devices which have not been seen since the restoration are kept.
+func (m *Metrics) State() *State {
+	st := &State{Devices: make(map[string]DeviceState)}
+	for id, t := range m.lastMovements {
+		st.Devices[id] = DeviceState{LastMovement: t, MovementsTotal: m.movementCounts[id] + m.restoredMovements[id]}
+	}
+	return st
+}
+
+// APIRequest is an observation of a request to the Nature Remo API.
+type APIRequest struct {
+	// Endpoint is the path of the request, e.g. /1/devices.
+	Endpoint string
+	Duration time.Duration
+	// Phases is the durations of the phases of the request, such as the DNS lookup and the time to the first byte.
+	Phases map[string]time.Duration
+	// RateLimit is the rate limit told by the response, if any.
+	RateLimit *RateLimit
+	// TraceID is the ID of the sampled trace which the request belongs to, if any.
+	TraceID string
+}
+
+// RateLimit represents the rate limit headers of the Nature Remo API.
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// ParseRateLimit parses X-Rate-Limit-* headers.
+// It returns false if the headers are missing or malformed.
+func ParseRateLimit(h http.Header) (RateLimit, bool) {
+	limit, err := strconv.Atoi(h.Get("X-Rate-Limit-Limit"))
+	if err != nil {
+		return RateLimit{}, false
+	}
+	remaining, err := strconv.Atoi(h.Get("X-Rate-Limit-Remaining"))
+	if err != nil {
+		return RateLimit{}, false
+	}
+	reset, err := strconv.ParseInt(h.Get("X-Rate-Limit-Reset"), 10, 64)
+	if err != nil {
+		return RateLimit{}, false
+	}
+	return RateLimit{
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     time.Unix(reset, 0),
+	}, true
+}
+
+// State is the state of the metrics to keep across restarts.
+type State struct {
+	Devices map[string]DeviceState `json:"devices"`
+}
+
+// DeviceState is the state of the movement counter of a device.
+type DeviceState struct {
+	LastMovement   time.Time `json:"last_movement"`
+	MovementsTotal float64   `json:"movements_total"`
+}
@@ -0,0 +1,447 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector_test
+
+import (
+	"context"
+	"maps"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imishinist/nature-remo-exporter/pkg/collector"
+	"github.com/imishinist/nature-remo-exporter/pkg/collector/collectortest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tenntenn/natureremo"
+)
+
+// deviceFamilies is the metric families of the devices compared by TestMetrics.
+var deviceFamilies = map[string]bool{
+	"nature_remo_temperature":       true,
+	"nature_remo_humidity":          true,
+	"nature_remo_illumination":      true,
+	"nature_remo_movement":          true,
+	"nature_remo_movements_total":   true,
+	"nature_remo_device_info":       true,
+	"nature_remo_temperature_min":   true,
+	"nature_remo_temperature_max":   true,
+	"nature_remo_temperature_avg":   true,
+	"nature_remo_temperature_trend": true,
+
+	"nature_remo_temperature_celsius":   true,
+	"nature_remo_humidity_percent":      true,
+	"nature_remo_movement_events_total": true,
+}
+
+func TestMetrics(t *testing.T) {
+	t0 := time.Now().Add(-time.Hour)
+	// now is the time of the scrape, which the ages of the values are measured at and the windows end at
+	now := t0.Add(time.Hour)
+	all := map[natureremo.SensorType]float64{
+		natureremo.SensorTypeTemperature:  21.5,
+		natureremo.SensorTypeHumidity:     45,
+		natureremo.SensorTypeIllumination: 120,
+		natureremo.SensorTypeMovement:     1,
+	}
+	temperatureOnly := map[natureremo.SensorType]float64{
+		natureremo.SensorTypeTemperature: 19,
+	}
+	movement := map[natureremo.SensorType]float64{
+		natureremo.SensorTypeMovement: 1,
+	}
+	temperature := func(v float64) map[natureremo.SensorType]float64 {
+		return map[natureremo.SensorType]float64{natureremo.SensorTypeTemperature: v}
+	}
+
+	tests := []struct {
+		name  string
+		opts  collector.Options
+		polls [][]*natureremo.Device
+		want  map[string]float64
+	}{
+		{
+			name: "all sensors",
+			polls: [][]*natureremo.Device{
+				{collectortest.Device("d1", "living", all, t0)},
+			},
+			want: map[string]float64{
+				"nature_remo_temperature{d1,living}":  21.5,
+				"nature_remo_humidity{d1,living}":     45,
+				"nature_remo_illumination{d1,living}": 120,
+				"nature_remo_movement{d1,living}":     1,
+				"nature_remo_movements_total{d1}":     0,
+				"nature_remo_device_info{d1,living}":  1,
+			},
+		},
+		{
+			name: "missing sensors are not exported",
+			polls: [][]*natureremo.Device{
+				{collectortest.Device("d1", "mini", temperatureOnly, t0)},
+			},
+			want: map[string]float64{
+				"nature_remo_temperature{d1,mini}": 19,
+				"nature_remo_movements_total{d1}":  0,
+				"nature_remo_device_info{d1,mini}": 1,
+			},
+		},
+		{
+			name: "sensor disappearing is deleted",
+			polls: [][]*natureremo.Device{
+				{collectortest.Device("d1", "living", all, t0)},
+				{collectortest.Device("d1", "living", temperatureOnly, t0.Add(time.Minute))},
+			},
+			want: map[string]float64{
+				"nature_remo_temperature{d1,living}": 19,
+				"nature_remo_movements_total{d1}":    0,
+				"nature_remo_device_info{d1,living}": 1,
+			},
+		},
+		{
+			name: "renamed device drops the series of the old name",
+			polls: [][]*natureremo.Device{
+				{collectortest.Device("d1", "living", temperatureOnly, t0)},
+				{collectortest.Device("d1", "lounge", temperatureOnly, t0)},
+			},
+			want: map[string]float64{
+				"nature_remo_temperature{d1,lounge}": 19,
+				"nature_remo_movements_total{d1}":    0,
+				"nature_remo_device_info{d1,lounge}": 1,
+			},
+		},
+		{
+			name: "renamed device keeps the movement counter",
+			polls: [][]*natureremo.Device{
+				{collectortest.Device("d1", "living", movement, t0)},
+				{collectortest.Device("d1", "living", movement, t0.Add(time.Minute))},
+				{collectortest.Device("d1", "lounge", movement, t0.Add(2*time.Minute))},
+			},
+			want: map[string]float64{
+				"nature_remo_movement{d1,lounge}":    1,
+				"nature_remo_movements_total{d1}":    2,
+				"nature_remo_device_info{d1,lounge}": 1,
+			},
+		},
+		{
+			name: "same movement is not counted",
+			polls: [][]*natureremo.Device{
+				{collectortest.Device("d1", "living", movement, t0)},
+				{collectortest.Device("d1", "living", movement, t0)},
+			},
+			want: map[string]float64{
+				"nature_remo_movement{d1,living}":    1,
+				"nature_remo_movements_total{d1}":    0,
+				"nature_remo_device_info{d1,living}": 1,
+			},
+		},
+		{
+			name: "jittered movement is deduplicated within the window",
+			opts: collector.Options{MovementDedupWindow: 5 * time.Second},
+			polls: [][]*natureremo.Device{
+				{collectortest.Device("d1", "living", movement, t0)},
+				{collectortest.Device("d1", "living", movement, t0.Add(2*time.Second))},
+				{collectortest.Device("d1", "living", movement, t0.Add(-time.Second))},
+				{collectortest.Device("d1", "living", movement, t0.Add(time.Minute))},
+			},
+			want: map[string]float64{
+				"nature_remo_movement{d1,living}":    1,
+				"nature_remo_movements_total{d1}":    1,
+				"nature_remo_device_info{d1,living}": 1,
+			},
+		},
+		{
+			name: "jittered movement is counted without the window",
+			polls: [][]*natureremo.Device{
+				{collectortest.Device("d1", "living", movement, t0)},
+				{collectortest.Device("d1", "living", movement, t0.Add(2*time.Second))},
+			},
+			want: map[string]float64{
+				"nature_remo_movement{d1,living}":    1,
+				"nature_remo_movements_total{d1}":    1,
+				"nature_remo_device_info{d1,living}": 1,
+			},
+		},
+		{
+			name: "conformance renames the metrics",
+			opts: collector.Options{Conformance: true},
+			polls: [][]*natureremo.Device{
+				{collectortest.Device("d1", "living", all, t0)},
+			},
+			want: map[string]float64{
+				"nature_remo_temperature_celsius{d1,living}": 21.5,
+				"nature_remo_humidity_percent{d1,living}":    45,
+				"nature_remo_illumination{d1,living}":        120,
+				"nature_remo_movement{d1,living}":            1,
+				"nature_remo_movement_events_total{d1}":      0,
+				"nature_remo_device_info{d1,living}":         1,
+			},
+		},
+		{
+			name: "conformance with the legacy names exports both names",
+			opts: collector.Options{Conformance: true, ConformanceLegacyNames: true},
+			polls: [][]*natureremo.Device{
+				{collectortest.Device("d1", "living", all, t0)},
+				{collectortest.Device("d1", "living", all, t0.Add(time.Minute))},
+			},
+			want: map[string]float64{
+				"nature_remo_temperature_celsius{d1,living}": 21.5,
+				"nature_remo_temperature{d1,living}":         21.5,
+				"nature_remo_humidity_percent{d1,living}":    45,
+				"nature_remo_humidity{d1,living}":            45,
+				"nature_remo_illumination{d1,living}":        120,
+				"nature_remo_movement{d1,living}":            1,
+				"nature_remo_movement_events_total{d1}":      1,
+				"nature_remo_movements_total{d1}":            1,
+				"nature_remo_device_info{d1,living}":         1,
+			},
+		},
+		{
+			name: "values older than the max sample age are dropped except the movements",
+			opts: collector.Options{MaxSampleAge: time.Hour},
+			polls: [][]*natureremo.Device{
+				{
+					collectortest.Device("d1", "unplugged", all, now.Add(-2*time.Hour)),
+					collectortest.Device("d2", "mini", temperatureOnly, now.Add(-time.Minute)),
+				},
+			},
+			want: map[string]float64{
+				"nature_remo_movement{d1,unplugged}":    1,
+				"nature_remo_temperature{d2,mini}":      19,
+				"nature_remo_movements_total{d1}":       0,
+				"nature_remo_movements_total{d2}":       0,
+				"nature_remo_device_info{d1,unplugged}": 1,
+				"nature_remo_device_info{d2,mini}":      1,
+			},
+		},
+		{
+			name: "window aggregates the readings held within the window",
+			opts: collector.Options{Window: 10 * time.Minute},
+			polls: [][]*natureremo.Device{
+				// the spike before the window is evicted by the next reading before the window
+				{collectortest.Device("d1", "living", temperature(30), now.Add(-30*time.Minute))},
+				{collectortest.Device("d1", "living", temperature(20), now.Add(-20*time.Minute))},
+				{collectortest.Device("d1", "living", temperature(24), now.Add(-8*time.Minute))},
+				{collectortest.Device("d1", "living", temperature(20), now.Add(-4*time.Minute))},
+			},
+			want: map[string]float64{
+				"nature_remo_temperature{d1,living}":     20,
+				"nature_remo_temperature_min{d1,living}": 20,
+				"nature_remo_temperature_max{d1,living}": 24,
+				// 20 for 2m from the start of the window, 24 for 4m and 20 for 4m
+				"nature_remo_temperature_avg{d1,living}": 21.6,
+				"nature_remo_movements_total{d1}":        0,
+				"nature_remo_device_info{d1,living}":     1,
+			},
+		},
+		{
+			name: "window of a removed device is not exported",
+			opts: collector.Options{Window: 10 * time.Minute},
+			polls: [][]*natureremo.Device{
+				{
+					collectortest.Device("d1", "living", temperature(20), now.Add(-5*time.Minute)),
+					collectortest.Device("d2", "bedroom", temperature(18), now.Add(-5*time.Minute)),
+				},
+				{collectortest.Device("d2", "bedroom", temperature(18), now.Add(-5*time.Minute))},
+			},
+			want: map[string]float64{
+				"nature_remo_temperature{d1,living}":      20,
+				"nature_remo_temperature{d2,bedroom}":     18,
+				"nature_remo_temperature_min{d2,bedroom}": 18,
+				"nature_remo_temperature_max{d2,bedroom}": 18,
+				"nature_remo_temperature_avg{d2,bedroom}": 18,
+				"nature_remo_movements_total{d1}":         0,
+				"nature_remo_movements_total{d2}":         0,
+				"nature_remo_device_info{d1,living}":      1,
+				"nature_remo_device_info{d2,bedroom}":     1,
+			},
+		},
+		{
+			name: "window of the values older than the max sample age is dropped",
+			opts: collector.Options{Window: 10 * time.Minute, MaxSampleAge: time.Hour},
+			polls: [][]*natureremo.Device{
+				{
+					collectortest.Device("d1", "unplugged", temperature(20), now.Add(-2*time.Hour)),
+					collectortest.Device("d2", "mini", temperature(18), now.Add(-5*time.Minute)),
+				},
+			},
+			want: map[string]float64{
+				"nature_remo_temperature{d2,mini}":      18,
+				"nature_remo_temperature_min{d2,mini}":  18,
+				"nature_remo_temperature_max{d2,mini}":  18,
+				"nature_remo_temperature_avg{d2,mini}":  18,
+				"nature_remo_movements_total{d1}":       0,
+				"nature_remo_movements_total{d2}":       0,
+				"nature_remo_device_info{d1,unplugged}": 1,
+				"nature_remo_device_info{d2,mini}":      1,
+			},
+		},
+		{
+			name: "window of the values older than the max sample age is NaN",
+			opts: collector.Options{Window: 10 * time.Minute, MaxSampleAge: time.Hour, MaxSampleAgeNaN: true},
+			polls: [][]*natureremo.Device{
+				{collectortest.Device("d1", "unplugged", temperature(20), now.Add(-2*time.Hour))},
+			},
+			want: map[string]float64{
+				"nature_remo_temperature{d1,unplugged}":     math.NaN(),
+				"nature_remo_temperature_min{d1,unplugged}": math.NaN(),
+				"nature_remo_temperature_max{d1,unplugged}": math.NaN(),
+				"nature_remo_temperature_avg{d1,unplugged}": math.NaN(),
+				"nature_remo_movements_total{d1}":           0,
+				"nature_remo_device_info{d1,unplugged}":     1,
+			},
+		},
+		{
+			name: "trend of flat readings is zero",
+			opts: collector.Options{TrendWindow: 30 * time.Minute},
+			polls: [][]*natureremo.Device{
+				{collectortest.Device("d1", "living", temperature(20), now.Add(-30*time.Minute))},
+				{collectortest.Device("d1", "living", temperature(20), now.Add(-20*time.Minute))},
+				{collectortest.Device("d1", "living", temperature(20), now.Add(-10*time.Minute))},
+			},
+			want: map[string]float64{
+				"nature_remo_temperature{d1,living}":       20,
+				"nature_remo_temperature_trend{d1,living}": 0,
+				"nature_remo_movements_total{d1}":          0,
+				"nature_remo_device_info{d1,living}":       1,
+			},
+		},
+		{
+			name: "trend of rising readings is fitted per hour",
+			opts: collector.Options{TrendWindow: 30 * time.Minute},
+			polls: [][]*natureremo.Device{
+				{collectortest.Device("d1", "living", temperature(20), now.Add(-30*time.Minute))},
+				{collectortest.Device("d1", "living", temperature(21), now.Add(-20*time.Minute))},
+				{collectortest.Device("d1", "living", temperature(22), now.Add(-10*time.Minute))},
+			},
+			want: map[string]float64{
+				"nature_remo_temperature{d1,living}": 22,
+				// fitted to 20, 21, 22 and 22 at 0, 10, 20 and 30 minutes into the window
+				"nature_remo_temperature_trend{d1,living}": 4.2,
+				"nature_remo_movements_total{d1}":          0,
+				"nature_remo_device_info{d1,living}":       1,
+			},
+		},
+		{
+			name: "trend of a single reading is zero",
+			opts: collector.Options{TrendWindow: 30 * time.Minute},
+			polls: [][]*natureremo.Device{
+				{collectortest.Device("d1", "living", temperature(20), now.Add(-10*time.Minute))},
+			},
+			want: map[string]float64{
+				"nature_remo_temperature{d1,living}":       20,
+				"nature_remo_temperature_trend{d1,living}": 0,
+				"nature_remo_movements_total{d1}":          0,
+				"nature_remo_device_info{d1,living}":       1,
+			},
+		},
+		{
+			name: "trend of readings with identical timestamps is finite",
+			opts: collector.Options{TrendWindow: 30 * time.Minute},
+			polls: [][]*natureremo.Device{
+				{collectortest.Device("d1", "living", temperature(20), now.Add(-10*time.Minute))},
+				{collectortest.Device("d1", "living", temperature(25), now.Add(-10*time.Minute))},
+			},
+			want: map[string]float64{
+				"nature_remo_temperature{d1,living}":       25,
+				"nature_remo_temperature_trend{d1,living}": 0,
+				"nature_remo_movements_total{d1}":          0,
+				"nature_remo_device_info{d1,living}":       1,
+			},
+		},
+		{
+			name: "trend of the values older than the max sample age is dropped",
+			opts: collector.Options{TrendWindow: 30 * time.Minute, MaxSampleAge: time.Hour},
+			polls: [][]*natureremo.Device{
+				{
+					collectortest.Device("d1", "unplugged", temperature(20), now.Add(-2*time.Hour)),
+					collectortest.Device("d2", "mini", temperature(18), now.Add(-5*time.Minute)),
+				},
+			},
+			want: map[string]float64{
+				"nature_remo_temperature{d2,mini}":       18,
+				"nature_remo_temperature_trend{d2,mini}": 0,
+				"nature_remo_movements_total{d1}":        0,
+				"nature_remo_movements_total{d2}":        0,
+				"nature_remo_device_info{d1,unplugged}":  1,
+				"nature_remo_device_info{d2,mini}":       1,
+			},
+		},
+		{
+			name: "trend of the values older than the max sample age is NaN",
+			opts: collector.Options{TrendWindow: 30 * time.Minute, MaxSampleAge: time.Hour, MaxSampleAgeNaN: true},
+			polls: [][]*natureremo.Device{
+				{collectortest.Device("d1", "unplugged", temperature(20), now.Add(-2*time.Hour))},
+			},
+			want: map[string]float64{
+				"nature_remo_temperature{d1,unplugged}":       math.NaN(),
+				"nature_remo_temperature_trend{d1,unplugged}": math.NaN(),
+				"nature_remo_movements_total{d1}":             0,
+				"nature_remo_device_info{d1,unplugged}":       1,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := &collectortest.Fetcher{}
+			reg := prometheus.NewRegistry()
+			c := collector.New(fetcher, tt.opts, reg)
+			for _, devices := range tt.polls {
+				fetcher.SetDevices(devices...)
+				if _, err := c.Update(context.Background()); err != nil {
+					t.Fatalf("Update() error = %v", err)
+				}
+			}
+
+			got := gatherDeviceSeries(t, reg)
+			// NaN is not equal to itself, but the NaN values are expected
+			equal := func(a, b float64) bool { return a == b || math.IsNaN(a) && math.IsNaN(b) }
+			if !maps.EqualFunc(got, tt.want, equal) {
+				t.Errorf("series = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// gatherDeviceSeries returns the values of the series of deviceFamilies by their names and the id and name labels.
+func gatherDeviceSeries(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	t.Helper()
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	series := make(map[string]float64)
+	for _, mf := range mfs {
+		if !deviceFamilies[mf.GetName()] {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			var values []string
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "id" || l.GetName() == "name" {
+					values = append(values, l.GetValue())
+				}
+			}
+			value := m.GetGauge().GetValue()
+			if m.Counter != nil {
+				value = m.GetCounter().GetValue()
+			}
+			// the aggregates over the windows ending at the scrape time are rounded off the elapsed time of the test
+			series[mf.GetName()+"{"+strings.Join(values, ",")+"}"] = math.Round(value*1000) / 1000
+		}
+	}
+	return series
+}
@@ -0,0 +1,44 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tenntenn/natureremo"
+)
+
+// Plugin is a custom collector which is updated with the devices after each update,
+// e.g. to export the metrics derived from the sensor values, or joined with another source.
+type Plugin interface {
+	prometheus.Collector
+	// Update is called with the devices fetched by each successful update.
+	Update(ctx context.Context, devices []*natureremo.Device) error
+}
+
+// updatePlugins updates the plugins with the devices. All plugins are updated even if some of them fail.
+func updatePlugins(ctx context.Context, plugins []Plugin, devices []*natureremo.Device) error {
+	var errs []error
+	for _, p := range plugins {
+		if err := p.Update(ctx, devices); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
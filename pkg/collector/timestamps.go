@@ -0,0 +1,188 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/tenntenn/natureremo"
+)
+
+// sensorTimestamps holds the creation time of the latest sensor values per device,
+// and the time when each device was refreshed by the exporter.
+type sensorTimestamps struct {
+	mu         sync.Mutex
+	timestamps map[string]map[natureremo.SensorType]time.Time
+	refreshed  map[string]time.Time
+	now        func() time.Time
+}
+
+func newSensorTimestamps(now func() time.Time) *sensorTimestamps {
+	return &sensorTimestamps{
+		now:        now,
+		timestamps: make(map[string]map[natureremo.SensorType]time.Time),
+		refreshed:  make(map[string]time.Time),
+	}
+}
+
+// Set records the creation time of the newest events of the device, and that the device is refreshed now.
+func (t *sensorTimestamps) Set(device *natureremo.Device) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timestamps := make(map[natureremo.SensorType]time.Time, len(device.NewestEvents))
+	for sensor, value := range device.NewestEvents {
+		timestamps[sensor] = value.CreatedAt
+	}
+	t.timestamps[device.ID] = timestamps
+	t.refreshed[device.ID] = t.now()
+}
+
+// MarkRefreshed records that the devices are refreshed now without changing their sensor values.
+func (t *sensorTimestamps) MarkRefreshed(ids []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for _, id := range ids {
+		t.refreshed[id] = now
+	}
+}
+
+// Refreshed returns the time when the device was refreshed last.
+func (t *sensorTimestamps) Refreshed(id string) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ts, ok := t.refreshed[id]
+	return ts, ok
+}
+
+// Get returns the creation time of the sensor value of the device.
+func (t *sensorTimestamps) Get(id string, sensor natureremo.SensorType) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ts, ok := t.timestamps[id][sensor]
+	return ts, ok && !ts.IsZero()
+}
+
+// Expired reports whether the sensor value of the device was created longer ago than maxAge at now.
+// It is false if maxAge is not positive or the creation time is unknown.
+func (t *sensorTimestamps) Expired(id string, sensor natureremo.SensorType, maxAge time.Duration, now time.Time) bool {
+	if maxAge <= 0 {
+		return false
+	}
+	ts, ok := t.Get(id, sensor)
+	return ok && now.Sub(ts) > maxAge
+}
+
+// sensorCollector collects the metrics of the gauges by the creation time of the sensor values.
+// If export is true, the creation time is attached to the metrics, and the metrics of the values older than
+// timestampsMaxAge are dropped, so that Prometheus does not reject the whole scrape
+// and the series go stale after the lookback period as usual.
+// The values older than maxSampleAge are dropped, or replaced with NaN if nan is true, except the movements,
+// whose newest events are old while nobody moves.
+// The values of the devices not refreshed for staleAfter, since the API has been failing or the device has been removed,
+// are dropped instead of repeating the cached values, so that Prometheus marks the series stale.
+type sensorCollector struct {
+	gauges     map[natureremo.SensorType]*prometheus.GaugeVec
+	timestamps *sensorTimestamps
+
+	export           bool
+	timestampsMaxAge time.Duration
+	maxSampleAge     time.Duration
+	nan              bool
+	staleAfter       time.Duration
+	now              func() time.Time
+}
+
+func (c *sensorCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, gauge := range c.gauges {
+		gauge.Describe(ch)
+	}
+}
+
+func (c *sensorCollector) Collect(ch chan<- prometheus.Metric) {
+	now := c.now()
+	for sensor, gauge := range c.gauges {
+		metrics := make(chan prometheus.Metric)
+		go func() {
+			gauge.Collect(metrics)
+			close(metrics)
+		}()
+		for m := range metrics {
+			var pb dto.Metric
+			if err := m.Write(&pb); err != nil {
+				ch <- m
+				continue
+			}
+			id := deviceID(&pb)
+			if c.staleAfter > 0 {
+				if refreshed, ok := c.timestamps.Refreshed(id); ok && now.Sub(refreshed) > c.staleAfter {
+					continue
+				}
+			}
+			ts, ok := c.timestamps.Get(id, sensor)
+			if !ok {
+				ch <- m
+				continue
+			}
+			age := now.Sub(ts)
+			if c.export && age > c.timestampsMaxAge {
+				continue
+			}
+			if c.maxSampleAge > 0 && age > c.maxSampleAge && sensor != natureremo.SensorTypeMovement {
+				if !c.nan {
+					continue
+				}
+				nan, err := prometheus.NewConstMetric(m.Desc(), prometheus.GaugeValue, math.NaN(), labelValues(&pb)...)
+				if err != nil {
+					continue
+				}
+				m = nan
+			}
+			if c.export {
+				m = prometheus.NewMetricWithTimestamp(ts, m)
+			}
+			ch <- m
+		}
+	}
+}
+
+// deviceID returns the ID of the device of the metric.
+func deviceID(pb *dto.Metric) string {
+	for _, l := range pb.GetLabel() {
+		if l.GetName() == "id" {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+// labelValues returns the values of deviceLabels of the metric in their order.
+func labelValues(pb *dto.Metric) []string {
+	values := make(map[string]string, len(pb.GetLabel()))
+	for _, l := range pb.GetLabel() {
+		values[l.GetName()] = l.GetValue()
+	}
+	ordered := make([]string, len(deviceLabels))
+	for i, name := range deviceLabels {
+		ordered[i] = values[name]
+	}
+	return ordered
+}
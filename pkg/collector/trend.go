@@ -0,0 +1,123 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tenntenn/natureremo"
+)
+
+// slope returns the rate of change per hour of the readings between from and to by the least squares,
+// where each reading holds until the next one, sampled at the time of each reading within the range, at from and at to.
+// It returns false if there is no reading until to.
+func slope(readings []reading, from, to time.Time) (float64, bool) {
+	var xs, ys []float64
+	for i, r := range readings {
+		if r.at.After(to) {
+			break
+		}
+		if i+1 < len(readings) && !readings[i+1].at.After(from) {
+			continue
+		}
+		xs = append(xs, maxTime(r.at, from).Sub(from).Hours())
+		ys = append(ys, r.value)
+	}
+	if len(xs) == 0 {
+		return 0, false
+	}
+	xs = append(xs, to.Sub(from).Hours())
+	ys = append(ys, ys[len(ys)-1])
+
+	var mx, my float64
+	for i := range xs {
+		mx += xs[i]
+		my += ys[i]
+	}
+	mx /= float64(len(xs))
+	my /= float64(len(ys))
+	var sxy, sxx float64
+	for i := range xs {
+		sxy += (xs[i] - mx) * (ys[i] - my)
+		sxx += (xs[i] - mx) * (xs[i] - mx)
+	}
+	if sxx == 0 {
+		return 0, true
+	}
+	return sxy / sxx, true
+}
+
+// trendCollector collects the rate of change of the temperature over the window from the history,
+// which is more stable than deriv() over the sparse samples in PromQL.
+// The devices not refreshed for staleAfter and the values older than maxSampleAge are dropped,
+// or the latter are NaN if nan is true, as the gauges of the sensors.
+type trendCollector struct {
+	history      *sensorHistory
+	window       time.Duration
+	timestamps   *sensorTimestamps
+	staleAfter   time.Duration
+	maxSampleAge time.Duration
+	nan          bool
+	now          func() time.Time
+
+	desc *prometheus.Desc
+}
+
+func newTrendCollector(history *sensorHistory, window time.Duration, conformance bool) *trendCollector {
+	name := "nature_remo_temperature_trend"
+	if conformance {
+		name += "_celsius_per_hour"
+	}
+	return &trendCollector{
+		history: history,
+		window:  window,
+		desc:    prometheus.NewDesc(name, "Rate of change of the temperature over the window in degrees Celsius per hour", deviceLabels, nil),
+	}
+}
+
+func (c *trendCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *trendCollector) Collect(ch chan<- prometheus.Metric) {
+	now := c.now()
+	for _, id := range c.history.IDs() {
+		if c.staleAfter > 0 {
+			if refreshed, ok := c.timestamps.Refreshed(id); ok && now.Sub(refreshed) > c.staleAfter {
+				continue
+			}
+		}
+		readings, labels := c.history.Get(id, natureremo.SensorTypeTemperature)
+		v, ok := slope(readings, now.Add(-c.window), now)
+		if !ok {
+			continue
+		}
+		values := make([]string, len(deviceLabels))
+		for i, name := range deviceLabels {
+			values[i] = labels[name]
+		}
+		if c.timestamps.Expired(id, natureremo.SensorTypeTemperature, c.maxSampleAge, now) {
+			if !c.nan {
+				continue
+			}
+			v = math.NaN()
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, v, values...)
+	}
+}
@@ -0,0 +1,237 @@
+/*
+Copyright © 2024 Taisuke Miyazaki <imishinist@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tenntenn/natureremo"
+)
+
+// windowSensors is the sensors aggregated over the window, with the names of their metrics and the units by the conformance.
+var windowSensors = []struct {
+	sensor natureremo.SensorType
+	name   string
+	unit   string
+	help   string
+}{
+	{natureremo.SensorTypeTemperature, "nature_remo_temperature", "_celsius", "temperature"},
+	{natureremo.SensorTypeHumidity, "nature_remo_humidity", "_percent", "humidity"},
+	{natureremo.SensorTypeIllumination, "nature_remo_illumination", "", "illumination"},
+}
+
+// reading is a sensor value seen by a poll, with the time when it was created.
+type reading struct {
+	value float64
+	at    time.Time
+}
+
+// sensorHistory holds the readings of the sensors of each device seen by the polls in memory,
+// as long as the retention, and the last one before it which holds at its start.
+type sensorHistory struct {
+	mu        sync.Mutex
+	retention time.Duration
+	now       func() time.Time
+	labels    map[string]prometheus.Labels
+	readings  map[string]map[natureremo.SensorType][]reading
+}
+
+func newSensorHistory(retention time.Duration, now func() time.Time) *sensorHistory {
+	return &sensorHistory{
+		retention: retention,
+		now:       now,
+		labels:    make(map[string]prometheus.Labels),
+		readings:  make(map[string]map[natureremo.SensorType][]reading),
+	}
+}
+
+// Add records the newest events of the device. An event seen by the previous polls is recorded once.
+func (h *sensorHistory) Add(device *natureremo.Device, labels prometheus.Labels) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.labels[device.ID] = labels
+	readings, ok := h.readings[device.ID]
+	if !ok {
+		readings = make(map[natureremo.SensorType][]reading)
+		h.readings[device.ID] = readings
+	}
+	cutoff := h.now().Add(-h.retention)
+	for _, ws := range windowSensors {
+		event, ok := device.NewestEvents[ws.sensor]
+		if !ok || event.CreatedAt.IsZero() {
+			continue
+		}
+		rs := readings[ws.sensor]
+		if n := len(rs); n == 0 || event.CreatedAt.After(rs[n-1].at) {
+			rs = append(rs, reading{value: event.Value, at: event.CreatedAt})
+		}
+		// the readings before the cutoff are dropped except the last one, which holds at the start of the retention
+		i := 0
+		for i+1 < len(rs) && !rs[i+1].at.After(cutoff) {
+			i++
+		}
+		readings[ws.sensor] = rs[i:]
+	}
+}
+
+// Get returns a copy of the readings of the sensor of the device, and the labels of the device.
+func (h *sensorHistory) Get(id string, sensor natureremo.SensorType) ([]reading, prometheus.Labels) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]reading(nil), h.readings[id][sensor]...), h.labels[id]
+}
+
+// Retain drops the readings of the devices other than the IDs.
+func (h *sensorHistory) Retain(ids []string) {
+	keep := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		keep[id] = true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id := range h.labels {
+		if !keep[id] {
+			delete(h.labels, id)
+			delete(h.readings, id)
+		}
+	}
+}
+
+// IDs returns the IDs of the devices in the history.
+func (h *sensorHistory) IDs() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ids := make([]string, 0, len(h.labels))
+	for id := range h.labels {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// aggregate returns the minimum, the maximum and the time-weighted average of the readings between from and to,
+// where each reading holds until the next one. It returns false if there is no reading until to.
+func aggregate(readings []reading, from, to time.Time) (lo, hi, avg float64, ok bool) {
+	var sum, total float64
+	for i, r := range readings {
+		if r.at.After(to) {
+			break
+		}
+		end := to
+		if i+1 < len(readings) && readings[i+1].at.Before(to) {
+			end = readings[i+1].at
+		}
+		if !end.After(from) && i+1 < len(readings) {
+			continue
+		}
+		if !ok {
+			lo, hi, ok = r.value, r.value, true
+		}
+		lo, hi = min(lo, r.value), max(hi, r.value)
+		if d := end.Sub(maxTime(r.at, from)).Seconds(); d > 0 {
+			sum += r.value * d
+			total += d
+		}
+	}
+	if !ok {
+		return 0, 0, 0, false
+	}
+	if total == 0 {
+		return lo, hi, readings[len(readings)-1].value, true
+	}
+	return lo, hi, sum / total, true
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// windowCollector collects the minimum, the maximum and the average of the sensor values over the window
+// from the history, so that the short spikes between the scrapes are not lost.
+// The devices not refreshed for staleAfter and the values older than maxSampleAge are dropped,
+// or the latter are NaN if nan is true, as the gauges of the sensors.
+type windowCollector struct {
+	history      *sensorHistory
+	window       time.Duration
+	timestamps   *sensorTimestamps
+	staleAfter   time.Duration
+	maxSampleAge time.Duration
+	nan          bool
+	now          func() time.Time
+
+	descs map[natureremo.SensorType][3]*prometheus.Desc
+}
+
+func newWindowCollector(history *sensorHistory, window time.Duration, conformance bool) *windowCollector {
+	c := &windowCollector{history: history, window: window, descs: make(map[natureremo.SensorType][3]*prometheus.Desc)}
+	for _, ws := range windowSensors {
+		unit := ""
+		if conformance {
+			unit = ws.unit
+		}
+		c.descs[ws.sensor] = [3]*prometheus.Desc{
+			prometheus.NewDesc(ws.name+"_min"+unit, "Minimum "+ws.help+" over the window", deviceLabels, nil),
+			prometheus.NewDesc(ws.name+"_max"+unit, "Maximum "+ws.help+" over the window", deviceLabels, nil),
+			prometheus.NewDesc(ws.name+"_avg"+unit, "Time-weighted average "+ws.help+" over the window", deviceLabels, nil),
+		}
+	}
+	return c
+}
+
+func (c *windowCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, descs := range c.descs {
+		for _, desc := range descs {
+			ch <- desc
+		}
+	}
+}
+
+func (c *windowCollector) Collect(ch chan<- prometheus.Metric) {
+	now := c.now()
+	for _, id := range c.history.IDs() {
+		if c.staleAfter > 0 {
+			if refreshed, ok := c.timestamps.Refreshed(id); ok && now.Sub(refreshed) > c.staleAfter {
+				continue
+			}
+		}
+		for sensor, descs := range c.descs {
+			readings, labels := c.history.Get(id, sensor)
+			lo, hi, avg, ok := aggregate(readings, now.Add(-c.window), now)
+			if !ok {
+				continue
+			}
+			values := make([]string, len(deviceLabels))
+			for i, name := range deviceLabels {
+				values[i] = labels[name]
+			}
+			if c.timestamps.Expired(id, sensor, c.maxSampleAge, now) {
+				if !c.nan {
+					continue
+				}
+				lo, hi, avg = math.NaN(), math.NaN(), math.NaN()
+			}
+			for i, v := range []float64{lo, hi, avg} {
+				ch <- prometheus.MustNewConstMetric(descs[i], prometheus.GaugeValue, v, values...)
+			}
+		}
+	}
+}